@@ -0,0 +1,141 @@
+package api
+
+import (
+	"encoding/json"
+	"net/url"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// TestUserWireFormat 测试用户的JSON字段名，字段名变化会破坏已有客户端，密码哈希不会被序列化
+func TestUserWireFormat(t *testing.T) {
+	data, err := json.Marshal(User{ID: 1, Name: "张三", Email: "zhangsan@example.com", Password: "x", PasswordHash: "hash", Role: RoleEditor})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]interface{}
+	json.Unmarshal(data, &fields)
+
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	expected := []string{"email", "id", "name", "password", "role", "version"}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("期望字段 %v，实际 %v", expected, keys)
+	}
+}
+
+// TestVersionPath 测试路径参数代入和版本前缀
+func TestVersionPath(t *testing.T) {
+	if got := V1.Path(PathUser, 42); got != "/v1/users/42" {
+		t.Errorf("期望 /v1/users/42，实际 %s", got)
+	}
+	if got := V2.Path(PathUsers); got != "/v2/users" {
+		t.Errorf("期望 /v2/users，实际 %s", got)
+	}
+}
+
+// TestMatch 测试路由匹配
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		version Version
+		method  string
+		path    string
+		want    bool
+	}{
+		{V1, "GET", "/v1/users/1", true},
+		{V1, "POST", "/v1/users", true},
+		{V1, "POST", "/v1/users/encrypted", true},
+		{V1, "GET", "/v1/users/encrypted", false},
+		{V1, "PUT", "/v1/users/1", false},
+		{V2, "PUT", "/v2/users/1", true},
+		{V2, "DELETE", "/v2/users/1", true},
+		{V2, "GET", "/v1/users/1", false},
+		{V1, "GET", "/v1/users/", false},
+		{V2, "POST", "/v2/users:import", true},
+		{V2, "GET", "/v2/users:import", false},
+		{V1, "GET", "/v1/users:export", false},
+	}
+	for _, tt := range tests {
+		if _, ok := Match(tt.version, tt.method, tt.path); ok != tt.want {
+			t.Errorf("%s %s %s: 期望 %v，实际 %v", tt.version, tt.method, tt.path, tt.want, ok)
+		}
+	}
+}
+
+// TestUserQueryRoundTrip 测试客户端编码的搜索条件能被服务端原样解析
+func TestUserQueryRoundTrip(t *testing.T) {
+	q := NewUserQuery().Contains("zhang").WithNamePrefix("张").SortBy(SortByNameDesc).Select("id", "name")
+	parsed, err := ParseUserQuery(q.Values())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed, *q) {
+		t.Errorf("期望 %+v，实际 %+v", *q, parsed)
+	}
+
+	parsed, _ = ParseUserQuery(NewUserQuery().Values())
+	if parsed.Sort != SortByID {
+		t.Errorf("默认排序期望 %s，实际 %s", SortByID, parsed.Sort)
+	}
+
+	for _, raw := range []string{"sort=age", "fields=id,password"} {
+		values, _ := url.ParseQuery(raw)
+		if _, err := ParseUserQuery(values); err == nil {
+			t.Errorf("%s 应返回错误", raw)
+		}
+	}
+}
+
+// TestUserETag 测试ETag格式化和解析
+func TestUserETag(t *testing.T) {
+	if tag := UserETag(3); tag != `"3"` {
+		t.Errorf(`期望 "3"，实际 %s`, tag)
+	}
+	if v, ok := ParseUserETag(UserETag(42)); !ok || v != 42 {
+		t.Errorf("期望 42，实际 %d %v", v, ok)
+	}
+	for _, tag := range []string{`W/"3"`, "3", `"abc"`, `"0"`, `"`} {
+		if _, ok := ParseUserETag(tag); ok {
+			t.Errorf("%s 不应解析成功", tag)
+		}
+	}
+}
+
+// TestUserValidate 测试用户字段校验，每个出错的字段都单独列出
+func TestUserValidate(t *testing.T) {
+	valid := User{Name: "张三", Email: "zhangsan@example.com", Password: "password123"}
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("期望校验通过，实际 %v", err)
+	}
+
+	tests := []struct {
+		user  User
+		field string
+	}{
+		{User{Name: " ", Email: "zhangsan@example.com"}, "name"},
+		{User{Name: string(make([]rune, MaxNameLength+1)), Email: "zhangsan@example.com"}, "name"},
+		{User{Name: "张\n三", Email: "zhangsan@example.com"}, "name"},
+		{User{Name: "张三", Email: "zhangsan"}, "email"},
+		{User{Name: "张三", Email: "张三 <zhangsan@example.com>"}, "email"},
+		{User{Name: "张三", Email: "zhangsan@localhost"}, "email"},
+		{User{Name: "张三", Email: "zhangsan@example.com", Password: "short1"}, "password"},
+		{User{Name: "张三", Email: "zhangsan@example.com", Password: "onlyletters"}, "password"},
+		{User{Name: "张三", Email: "zhangsan@example.com", Password: "12345678"}, "password"},
+	}
+	for _, tt := range tests {
+		err := tt.user.Validate()
+		errs, ok := err.(FieldErrors)
+		if !ok || len(errs) != 1 || errs[0].Name != tt.field {
+			t.Errorf("%+v: 期望字段 %s 出错，实际 %v", tt.user, tt.field, err)
+		}
+	}
+
+	err := (&User{Email: "bad", Password: "x"}).Validate()
+	if errs, ok := err.(FieldErrors); !ok || len(errs) != 3 {
+		t.Errorf("期望三个字段出错，实际 %v", err)
+	}
+}
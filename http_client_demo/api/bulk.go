@@ -0,0 +1,55 @@
+package api
+
+// NDJSONContentType 每行一个JSON值的媒体类型，用于批量导入和导出
+const NDJSONContentType = "application/x-ndjson"
+
+// ImportMode 批量导入模式
+type ImportMode string
+
+const (
+	// ImportBestEffort 逐行写入，出错的行跳过，其余行照常创建
+	ImportBestEffort ImportMode = "best-effort"
+	// ImportAtomic 全部行校验通过后才写入，任何一行出错都不创建用户
+	ImportAtomic ImportMode = "atomic"
+)
+
+// ImportStatus 导入结果中一行的状态
+type ImportStatus string
+
+const (
+	// ImportCreated 已创建用户
+	ImportCreated ImportStatus = "created"
+	// ImportFailed 该行数据有误或写入失败
+	ImportFailed ImportStatus = "failed"
+	// ImportSkipped 原子模式下该行本身正确，但因为其他行出错没有写入
+	ImportSkipped ImportStatus = "skipped"
+	// ImportDone 结果流的最后一行，带汇总数量
+	ImportDone ImportStatus = "done"
+)
+
+// ImportResult 导入响应中的一行。每个非空输入行对应一条结果，最后一条的 Status 为 ImportDone
+type ImportResult struct {
+	// Line 输入中的行号，从1开始
+	Line   int          `json:"line,omitempty"`
+	Status ImportStatus `json:"status"`
+	// User 创建后的用户
+	User          *User          `json:"user,omitempty"`
+	Error         string         `json:"error,omitempty"`
+	InvalidParams []InvalidParam `json:"invalid_params,omitempty"`
+	// Created 和 Failed 只出现在最后一行
+	Created int `json:"created,omitempty"`
+	Failed  int `json:"failed,omitempty"`
+}
+
+// ExportFormat 批量导出格式
+type ExportFormat string
+
+const (
+	// ExportNDJSON 每行一个用户的JSON
+	ExportNDJSON ExportFormat = "ndjson"
+	// ExportCSV 带表头的CSV，列为 UserCSVHeader
+	ExportCSV ExportFormat = "csv"
+)
+
+// UserCSVHeader 导出CSV的表头，不包含密码
+var UserCSVHeader = []string{"id", "name", "email", "role", "version"}
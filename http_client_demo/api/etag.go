@@ -0,0 +1,24 @@
+package api
+
+import (
+	"strconv"
+	"strings"
+)
+
+// UserETag 把用户版本号格式化为强ETag，例如 "3"
+func UserETag(version int) string {
+	return strconv.Quote(strconv.Itoa(version))
+}
+
+// ParseUserETag 解析 UserETag 生成的ETag，弱ETag（W/前缀）和其他格式返回false
+func ParseUserETag(tag string) (int, bool) {
+	tag = strings.TrimSpace(tag)
+	if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+		return 0, false
+	}
+	version, err := strconv.Atoi(tag[1 : len(tag)-1])
+	if err != nil || version <= 0 {
+		return 0, false
+	}
+	return version, true
+}
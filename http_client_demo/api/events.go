@@ -0,0 +1,41 @@
+package api
+
+import "time"
+
+// UserEventType 用户事件类型
+type UserEventType string
+
+const (
+	// UserCreated 创建用户
+	UserCreated UserEventType = "user.created"
+	// UserUpdated 更新用户，包括修改密码和角色
+	UserUpdated UserEventType = "user.updated"
+	// UserDeleted 删除用户
+	UserDeleted UserEventType = "user.deleted"
+	// StreamReset 只出现在事件流中：断线期间的事件已无法补发，客户端应重新获取全量数据，
+	// 事件ID是服务端当前的位置
+	StreamReset UserEventType = "stream.reset"
+)
+
+// UserEventTypes 所有用户事件类型，不含 StreamReset
+var UserEventTypes = []UserEventType{UserCreated, UserUpdated, UserDeleted}
+
+// Valid 判断是否为已定义的事件类型
+func (t UserEventType) Valid() bool {
+	for _, typ := range UserEventTypes {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// UserEvent 用户变更事件，webhook 推送的请求体和 GET /events 事件流的 data 字段都是一个事件
+type UserEvent struct {
+	// ID 服务端按发生顺序分配的递增序号
+	ID   int64         `json:"id"`
+	Type UserEventType `json:"type"`
+	Time time.Time     `json:"time"`
+	// User 变更后的用户，删除事件为删除前的用户，StreamReset 为nil
+	User *User `json:"user"`
+}
@@ -0,0 +1,3 @@
+module http_client_demo/api
+
+go 1.21
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ProblemContentType RFC 7807 错误详情的媒体类型
+const ProblemContentType = "application/problem+json"
+
+// 错误类型，作为 Problem.Type 的取值，客户端可以据此区分错误而不必解析文字说明
+const (
+	// ProblemMalformed 请求体不是合法的JSON，或包含未知字段、类型不匹配的字段
+	ProblemMalformed = "/problems/malformed-request"
+	// ProblemValidation 请求体格式正确，但字段的值不符合要求
+	ProblemValidation = "/problems/validation-failed"
+	// ProblemTooLarge 请求体超过服务端允许的大小
+	ProblemTooLarge = "/problems/request-too-large"
+)
+
+// InvalidParam 一个不合法的字段
+type InvalidParam struct {
+	// Name 字段的JSON名称，嵌套字段用 "." 连接
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// Problem RFC 7807 错误详情，请求数据有误时服务端以 application/problem+json 返回
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	// Instance 出错的请求路径
+	Instance string `json:"instance,omitempty"`
+	// RequestID 请求ID，反馈问题时提供给支持人员用于查找日志
+	RequestID string `json:"request_id,omitempty"`
+	// InvalidParams 不合法的字段列表
+	InvalidParams []InvalidParam `json:"invalid_params,omitempty"`
+}
+
+// Error 实现 error 接口，客户端把错误响应解析为 *Problem 返回
+func (p *Problem) Error() string {
+	msg := fmt.Sprintf("%s（状态码 %d）", p.Title, p.Status)
+	if p.Detail != "" {
+		msg += ": " + p.Detail
+	}
+	if len(p.InvalidParams) > 0 {
+		msg += ": " + FieldErrors(p.InvalidParams).Error()
+	}
+	return msg
+}
+
+// Field 返回指定字段的错误原因，字段没有错误时返回空字符串
+func (p *Problem) Field(name string) string {
+	for _, param := range p.InvalidParams {
+		if param.Name == name {
+			return param.Reason
+		}
+	}
+	return ""
+}
+
+// FieldErrors 字段校验失败的列表，Validate 返回这一类型
+type FieldErrors []InvalidParam
+
+// Error 实现 error 接口
+func (e FieldErrors) Error() string {
+	parts := make([]string, len(e))
+	for i, param := range e {
+		parts[i] = param.Name + ": " + param.Reason
+	}
+	return strings.Join(parts, "; ")
+}
+
+// add 追加一个字段错误
+func (e *FieldErrors) add(name, format string, args ...interface{}) {
+	*e = append(*e, InvalidParam{Name: name, Reason: fmt.Sprintf(format, args...)})
+}
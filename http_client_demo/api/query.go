@@ -0,0 +1,133 @@
+package api
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// UserSort 用户搜索结果的排序方式，带 "-" 前缀表示降序
+type UserSort string
+
+const (
+	SortByID       UserSort = "id"
+	SortByIDDesc   UserSort = "-id"
+	SortByName     UserSort = "name"
+	SortByNameDesc UserSort = "-name"
+)
+
+// UserFields 用户搜索可以通过 fields 参数选择返回的字段
+var UserFields = []string{"id", "name", "email"}
+
+// UserQuery 用户搜索条件，对应 GET /users 的查询参数，多个条件同时生效。
+// 客户端通过链式方法构造，服务端通过 ParseUserQuery 解析
+type UserQuery struct {
+	// Text 在用户名或邮箱中查找的子串，不区分大小写（q）
+	Text string
+	// Email 邮箱完全匹配，不区分大小写（email）
+	Email string
+	// NamePrefix 用户名前缀，不区分大小写（name_prefix）
+	NamePrefix string
+	// Sort 排序方式，默认按ID升序（sort）
+	Sort UserSort
+	// Fields 只返回这些字段，为空时返回完整用户（fields，逗号分隔）
+	Fields []string
+}
+
+// NewUserQuery 创建空的用户搜索条件，不加任何条件时返回所有用户
+func NewUserQuery() *UserQuery {
+	return &UserQuery{}
+}
+
+// Contains 按用户名或邮箱中的子串搜索
+func (q *UserQuery) Contains(text string) *UserQuery {
+	q.Text = text
+	return q
+}
+
+// WithEmail 按邮箱精确搜索
+func (q *UserQuery) WithEmail(email string) *UserQuery {
+	q.Email = email
+	return q
+}
+
+// WithNamePrefix 按用户名前缀搜索
+func (q *UserQuery) WithNamePrefix(prefix string) *UserQuery {
+	q.NamePrefix = prefix
+	return q
+}
+
+// SortBy 设置排序方式
+func (q *UserQuery) SortBy(sort UserSort) *UserQuery {
+	q.Sort = sort
+	return q
+}
+
+// Select 设置返回的字段
+func (q *UserQuery) Select(fields ...string) *UserQuery {
+	q.Fields = fields
+	return q
+}
+
+// Values 编码为URL查询参数，未设置的条件不出现在结果中
+func (q *UserQuery) Values() url.Values {
+	values := url.Values{}
+	if q.Text != "" {
+		values.Set("q", q.Text)
+	}
+	if q.Email != "" {
+		values.Set("email", q.Email)
+	}
+	if q.NamePrefix != "" {
+		values.Set("name_prefix", q.NamePrefix)
+	}
+	if q.Sort != "" {
+		values.Set("sort", string(q.Sort))
+	}
+	if len(q.Fields) > 0 {
+		values.Set("fields", strings.Join(q.Fields, ","))
+	}
+	return values
+}
+
+// ParseUserQuery 解析并校验 GET /users 的查询参数
+func ParseUserQuery(values url.Values) (UserQuery, error) {
+	q := UserQuery{
+		Text:       values.Get("q"),
+		Email:      values.Get("email"),
+		NamePrefix: values.Get("name_prefix"),
+		Sort:       UserSort(values.Get("sort")),
+	}
+
+	switch q.Sort {
+	case "":
+		q.Sort = SortByID
+	case SortByID, SortByIDDesc, SortByName, SortByNameDesc:
+	default:
+		return UserQuery{}, fmt.Errorf("无效的排序方式: %s", q.Sort)
+	}
+
+	if fields := values.Get("fields"); fields != "" {
+		for _, field := range strings.Split(fields, ",") {
+			field = strings.TrimSpace(field)
+			if field == "" {
+				continue
+			}
+			if !isUserField(field) {
+				return UserQuery{}, fmt.Errorf("未知字段: %s", field)
+			}
+			q.Fields = append(q.Fields, field)
+		}
+	}
+	return q, nil
+}
+
+// isUserField 判断是否是可以选择返回的用户字段
+func isUserField(field string) bool {
+	for _, f := range UserFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
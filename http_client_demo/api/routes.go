@@ -0,0 +1,156 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Version API版本
+type Version string
+
+const (
+	V1 Version = "v1"
+	V2 Version = "v2"
+)
+
+// Versions 所有API版本，按发布顺序排列
+var Versions = []Version{V1, V2}
+
+// 路由路径，花括号中的部分是路径参数
+const (
+	PathUsers          = "/users"
+	PathUser           = "/users/{id}"
+	PathEncryptedUsers = "/users/encrypted"
+	PathLogin          = "/login"
+	PathLogout         = "/logout"
+	PathMe             = "/me"
+	PathEvents         = "/events"
+	PathUsersImport    = "/users:import"
+	PathUsersExport    = "/users:export"
+	PathUpload         = "/upload"
+	PathFiles          = "/files"
+	PathFile           = "/files/{id}"
+)
+
+// Route 一条API路由
+type Route struct {
+	Method string
+	Path   string
+	Since  Version // 从哪个版本开始提供
+}
+
+// 所有API路由，客户端和服务端都以此为准
+var routes = []Route{
+	{Method: "POST", Path: PathUsers, Since: V1},
+	{Method: "GET", Path: PathUser, Since: V1},
+	{Method: "POST", Path: PathEncryptedUsers, Since: V1},
+	{Method: "POST", Path: PathLogin, Since: V1},
+	{Method: "POST", Path: PathUpload, Since: V1},
+	{Method: "GET", Path: PathUsers, Since: V2},
+	{Method: "PUT", Path: PathUser, Since: V2},
+	{Method: "DELETE", Path: PathUser, Since: V2},
+	{Method: "GET", Path: PathFiles, Since: V2},
+	{Method: "GET", Path: PathFile, Since: V2},
+	{Method: "DELETE", Path: PathFile, Since: V2},
+	{Method: "POST", Path: PathLogout, Since: V2},
+	{Method: "GET", Path: PathMe, Since: V2},
+	{Method: "GET", Path: PathEvents, Since: V2},
+	{Method: "POST", Path: PathUsersImport, Since: V2},
+	{Method: "GET", Path: PathUsersExport, Since: V2},
+}
+
+// Prefix 返回版本的路径前缀，如 /v1
+func (v Version) Prefix() string {
+	return "/" + string(v)
+}
+
+// Supports 判断该版本是否包含 since 版本引入的路由
+func (v Version) Supports(since Version) bool {
+	return v.index() >= since.index()
+}
+
+func (v Version) index() int {
+	for i, version := range Versions {
+		if version == v {
+			return i
+		}
+	}
+	return -1
+}
+
+// Routes 返回指定版本提供的所有路由
+func Routes(v Version) []Route {
+	result := make([]Route, 0, len(routes))
+	for _, r := range routes {
+		if v.Supports(r.Since) {
+			result = append(result, r)
+		}
+	}
+	return result
+}
+
+// Path 把路径参数依次代入路由路径，并加上版本前缀
+func (v Version) Path(path string, params ...interface{}) string {
+	segments := strings.Split(path, "/")
+	for i, seg := range segments {
+		if isParam(seg) && len(params) > 0 {
+			segments[i] = fmt.Sprint(params[0])
+			params = params[1:]
+		}
+	}
+	return v.Prefix() + strings.Join(segments, "/")
+}
+
+// Match 查找与请求方法和路径匹配的路由，路径需包含版本前缀。
+// 字面量路径优先于带参数的路径，例如 /users/encrypted 不会匹配 /users/{id}
+func Match(v Version, method, path string) (Route, bool) {
+	rest, ok := strings.CutPrefix(path, v.Prefix())
+	if !ok {
+		return Route{}, false
+	}
+
+	literal := false
+	for _, r := range Routes(v) {
+		if r.Path == rest {
+			literal = true
+			if r.Method == method {
+				return r, true
+			}
+		}
+	}
+	if literal {
+		return Route{}, false
+	}
+
+	for _, r := range Routes(v) {
+		if r.Method == method && matchPath(r.Path, rest) {
+			return r, true
+		}
+	}
+	return Route{}, false
+}
+
+// matchPath 按路径段匹配，参数段匹配任意非空段
+func matchPath(pattern, path string) bool {
+	ps := strings.Split(pattern, "/")
+	ss := strings.Split(path, "/")
+	if len(ps) != len(ss) {
+		return false
+	}
+	for i := range ps {
+		if isParam(ps[i]) {
+			if ss[i] == "" {
+				return false
+			}
+			continue
+		}
+		if ps[i] != ss[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func isParam(segment string) bool {
+	return strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}")
+}
@@ -0,0 +1,61 @@
+package api
+
+import "time"
+
+// User 用户结构体
+type User struct {
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
+	// Password 只在创建或修改密码时提交，服务端保存哈希后丢弃明文，响应中不会返回
+	Password string `json:"password,omitempty"`
+	// PasswordHash 服务端保存的密码哈希，不参与JSON序列化
+	PasswordHash string `json:"-"`
+	// Version 服务端维护的版本号，创建时为1，每次更新加1，更新时提交的值会被忽略
+	Version int `json:"version"`
+	// Role 用户角色，新用户为 viewer，只能由管理员通过专门的接口修改，创建和更新用户时提交的值会被忽略
+	Role Role `json:"role,omitempty"`
+}
+
+// Role 用户角色，决定用户登录后能调用哪些接口
+type Role string
+
+const (
+	// RoleAdmin 管理员：所有权限，包括管理API Key和用户角色
+	RoleAdmin Role = "admin"
+	// RoleEditor 编辑：查询和修改用户、上传文件
+	RoleEditor Role = "editor"
+	// RoleViewer 只读：查询用户
+	RoleViewer Role = "viewer"
+)
+
+// Roles 所有角色，按权限从高到低排列
+var Roles = []Role{RoleAdmin, RoleEditor, RoleViewer}
+
+// Valid 判断是否为已定义的角色
+func (r Role) Valid() bool {
+	for _, role := range Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// APIResponse API响应结构体
+type APIResponse struct {
+	Success bool        `json:"success"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data"`
+	// RequestID 出错时服务端回显的请求ID，反馈问题时提供给支持人员用于查找日志
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// FileInfo 上传文件的元数据，ID是文件内容的SHA-256，相同内容只保存一份
+type FileInfo struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Size        int64     `json:"size"`
+	ContentType string    `json:"content_type"`
+	UploadedAt  time.Time `json:"uploaded_at"`
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+	"unicode"
+)
+
+const (
+	// MaxNameLength 用户名的最多字符数
+	MaxNameLength = 64
+	// MaxEmailLength 邮箱的最多字符数（RFC 5321）
+	MaxEmailLength = 254
+	// MinPasswordLength 密码的最少字符数
+	MinPasswordLength = 8
+	// MaxPasswordLength 密码的最多字符数
+	MaxPasswordLength = 128
+)
+
+// Validate 校验创建或更新用户时提交的数据，不合法时返回 FieldErrors，列出每个出错的字段
+func (u *User) Validate() error {
+	var errs FieldErrors
+
+	name := strings.TrimSpace(u.Name)
+	switch {
+	case name == "":
+		errs.add("name", "用户名不能为空")
+	case len([]rune(name)) > MaxNameLength:
+		errs.add("name", "用户名不能超过%d个字符", MaxNameLength)
+	case strings.IndexFunc(u.Name, unicode.IsControl) >= 0:
+		errs.add("name", "用户名不能包含控制字符")
+	}
+
+	if reason := validateEmail(u.Email); reason != "" {
+		errs.add("email", reason)
+	}
+
+	// 密码为空表示不修改
+	if u.Password != "" {
+		if reason := validatePassword(u.Password); reason != "" {
+			errs.add("password", reason)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validateEmail 返回邮箱不合法的原因，合法时返回空字符串
+func validateEmail(email string) string {
+	if email == "" {
+		return "邮箱不能为空"
+	}
+	if len(email) > MaxEmailLength {
+		return "邮箱太长"
+	}
+	// 只接受纯地址，不接受 "张三 <zhangsan@example.com>" 这样带显示名的形式
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return "邮箱格式不正确"
+	}
+	domain := email[strings.LastIndex(email, "@")+1:]
+	if !strings.Contains(domain, ".") || strings.HasPrefix(domain, ".") || strings.HasSuffix(domain, ".") {
+		return "邮箱域名不正确"
+	}
+	return ""
+}
+
+// validatePassword 返回密码不符合策略的原因：长度在限制范围内，且同时包含字母和数字
+func validatePassword(password string) string {
+	n := len([]rune(password))
+	if n < MinPasswordLength {
+		return fmt.Sprintf("密码至少需要%d个字符", MinPasswordLength)
+	}
+	if n > MaxPasswordLength {
+		return fmt.Sprintf("密码不能超过%d个字符", MaxPasswordLength)
+	}
+	if strings.IndexFunc(password, unicode.IsLetter) < 0 || strings.IndexFunc(password, unicode.IsDigit) < 0 {
+		return "密码需要同时包含字母和数字"
+	}
+	return ""
+}
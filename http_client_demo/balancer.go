@@ -0,0 +1,330 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BalanceStrategy 负载均衡策略
+type BalanceStrategy int
+
+const (
+	// RoundRobin 轮询
+	RoundRobin BalanceStrategy = iota
+	// LeastOutstanding 选择未完成请求最少的节点
+	LeastOutstanding
+	// PowerOfTwoChoices 随机选两个节点，取未完成请求较少的一个
+	PowerOfTwoChoices
+)
+
+// BalancerConfig 负载均衡配置
+type BalancerConfig struct {
+	Strategy BalanceStrategy
+
+	// 被动摘除：连续失败 MaxFailures 次后摘除节点 EjectionTime，
+	// 同一节点重复被摘除时摘除时间翻倍，最长 MaxEjectionTime
+	MaxFailures     int
+	EjectionTime    time.Duration
+	MaxEjectionTime time.Duration
+
+	// 主动健康检查：HealthCheckPath 为空时不启用
+	HealthCheckPath     string
+	HealthCheckInterval time.Duration
+	HealthCheckTimeout  time.Duration
+}
+
+// EndpointStatus 节点状态快照
+type EndpointStatus struct {
+	URL          string
+	Outstanding  int64
+	Failures     int
+	Healthy      bool
+	EjectedUntil time.Time
+}
+
+// endpoint 单个后端节点
+type endpoint struct {
+	url         *url.URL
+	outstanding int64
+
+	mu           sync.Mutex
+	failures     int
+	ejections    int
+	ejectedUntil time.Time
+	healthy      bool
+}
+
+// available 节点当前是否可用
+func (e *endpoint) available(now time.Time) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.healthy && !now.Before(e.ejectedUntil)
+}
+
+// balancer 实现 http.RoundTripper，把请求分发到多个节点
+type balancer struct {
+	endpoints []*endpoint
+	config    BalancerConfig
+	next      http.RoundTripper
+	counter   uint64
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// newBalancer 创建负载均衡器，所有节点的路径前缀必须一致
+func newBalancer(rawURLs []string, config BalancerConfig, next http.RoundTripper) (*balancer, error) {
+	if len(rawURLs) == 0 {
+		return nil, fmt.Errorf("至少需要一个节点")
+	}
+	if config.MaxFailures <= 0 {
+		config.MaxFailures = 3
+	}
+	if config.EjectionTime <= 0 {
+		config.EjectionTime = 30 * time.Second
+	}
+	if config.MaxEjectionTime <= 0 {
+		config.MaxEjectionTime = 5 * time.Minute
+	}
+	if config.HealthCheckInterval <= 0 {
+		config.HealthCheckInterval = 10 * time.Second
+	}
+	if config.HealthCheckTimeout <= 0 {
+		config.HealthCheckTimeout = 2 * time.Second
+	}
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	b := &balancer{
+		config: config,
+		next:   next,
+		stop:   make(chan struct{}),
+	}
+	for _, raw := range rawURLs {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("解析节点地址失败: %v", err)
+		}
+		if u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("节点地址无效: %s", raw)
+		}
+		if len(b.endpoints) > 0 && u.Path != b.endpoints[0].url.Path {
+			return nil, fmt.Errorf("节点路径前缀不一致: %s", raw)
+		}
+		b.endpoints = append(b.endpoints, &endpoint{url: u, healthy: true})
+	}
+
+	if config.HealthCheckPath != "" {
+		b.wg.Add(1)
+		go b.healthCheckLoop()
+	}
+	return b, nil
+}
+
+// RoundTrip 选择节点并改写请求地址
+func (b *balancer) RoundTrip(req *http.Request) (*http.Response, error) {
+	ep := b.pick()
+
+	outReq := req.Clone(req.Context())
+	outReq.URL.Scheme = ep.url.Scheme
+	outReq.URL.Host = ep.url.Host
+	outReq.Host = ""
+
+	atomic.AddInt64(&ep.outstanding, 1)
+	resp, err := b.next.RoundTrip(outReq)
+	if err != nil {
+		atomic.AddInt64(&ep.outstanding, -1)
+		b.report(ep, false)
+		return nil, err
+	}
+	b.report(ep, resp.StatusCode < http.StatusInternalServerError)
+
+	// 响应体读完关闭后才算请求结束
+	resp.Body = &outstandingBody{ReadCloser: resp.Body, ep: ep}
+	return resp, nil
+}
+
+// pick 按策略选择节点，没有可用节点时退化为在全部节点中选择
+func (b *balancer) pick() *endpoint {
+	now := time.Now()
+	candidates := make([]*endpoint, 0, len(b.endpoints))
+	for _, ep := range b.endpoints {
+		if ep.available(now) {
+			candidates = append(candidates, ep)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = b.endpoints
+	}
+
+	switch b.config.Strategy {
+	case LeastOutstanding:
+		// 从轮询位置开始比较，未完成请求数相同时不会总是选中第一个节点
+		start := int(atomic.AddUint64(&b.counter, 1) % uint64(len(candidates)))
+		best := candidates[start]
+		for i := 1; i < len(candidates); i++ {
+			ep := candidates[(start+i)%len(candidates)]
+			if atomic.LoadInt64(&ep.outstanding) < atomic.LoadInt64(&best.outstanding) {
+				best = ep
+			}
+		}
+		return best
+	case PowerOfTwoChoices:
+		if len(candidates) == 1 {
+			return candidates[0]
+		}
+		i := rand.Intn(len(candidates))
+		j := rand.Intn(len(candidates) - 1)
+		if j >= i {
+			j++
+		}
+		a, c := candidates[i], candidates[j]
+		if atomic.LoadInt64(&c.outstanding) < atomic.LoadInt64(&a.outstanding) {
+			return c
+		}
+		return a
+	default:
+		n := atomic.AddUint64(&b.counter, 1) - 1
+		return candidates[n%uint64(len(candidates))]
+	}
+}
+
+// report 记录请求结果，连续失败达到阈值时摘除节点
+func (b *balancer) report(ep *endpoint, ok bool) {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+
+	if ok {
+		ep.failures = 0
+		return
+	}
+	ep.failures++
+	if ep.failures < b.config.MaxFailures {
+		return
+	}
+
+	ejection := b.config.EjectionTime << uint(ep.ejections)
+	if ejection <= 0 || ejection > b.config.MaxEjectionTime {
+		ejection = b.config.MaxEjectionTime
+	}
+	ep.ejections++
+	ep.failures = 0
+	ep.ejectedUntil = time.Now().Add(ejection)
+}
+
+// healthCheckLoop 定期对所有节点做主动健康检查
+func (b *balancer) healthCheckLoop() {
+	defer b.wg.Done()
+
+	ticker := time.NewTicker(b.config.HealthCheckInterval)
+	defer ticker.Stop()
+
+	b.checkAll()
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-ticker.C:
+			b.checkAll()
+		}
+	}
+}
+
+// checkAll 检查所有节点，2xx 视为健康
+func (b *balancer) checkAll() {
+	for _, ep := range b.endpoints {
+		healthy := b.check(ep)
+		ep.mu.Lock()
+		if healthy && !ep.healthy {
+			// 恢复健康的节点重新开始计算摘除次数
+			ep.ejections = 0
+			ep.ejectedUntil = time.Time{}
+		}
+		ep.healthy = healthy
+		ep.mu.Unlock()
+	}
+}
+
+func (b *balancer) check(ep *endpoint) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), b.config.HealthCheckTimeout)
+	defer cancel()
+
+	target := *ep.url
+	target.Path += b.config.HealthCheckPath
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return false
+	}
+	resp, err := b.next.RoundTrip(req)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode >= 200 && resp.StatusCode < 300
+}
+
+// status 返回所有节点的状态快照
+func (b *balancer) status() []EndpointStatus {
+	statuses := make([]EndpointStatus, 0, len(b.endpoints))
+	for _, ep := range b.endpoints {
+		ep.mu.Lock()
+		statuses = append(statuses, EndpointStatus{
+			URL:          ep.url.String(),
+			Outstanding:  atomic.LoadInt64(&ep.outstanding),
+			Failures:     ep.failures,
+			Healthy:      ep.healthy,
+			EjectedUntil: ep.ejectedUntil,
+		})
+		ep.mu.Unlock()
+	}
+	return statuses
+}
+
+// close 停止主动健康检查
+func (b *balancer) close() {
+	b.stopOnce.Do(func() { close(b.stop) })
+	b.wg.Wait()
+}
+
+// outstandingBody 在响应体关闭时减少节点的未完成请求数
+type outstandingBody struct {
+	io.ReadCloser
+	ep   *endpoint
+	once sync.Once
+}
+
+func (body *outstandingBody) Close() error {
+	body.once.Do(func() { atomic.AddInt64(&body.ep.outstanding, -1) })
+	return body.ReadCloser.Close()
+}
+
+// NewBalancedHTTPClient 创建在多个节点间做负载均衡的HTTP客户端，
+// 所有节点应提供相同的API，只有协议和主机不同
+func NewBalancedHTTPClient(endpoints []string, apiKey string, config BalancerConfig) (*HTTPClient, error) {
+	b, err := newBalancer(endpoints, config, http.DefaultTransport)
+	if err != nil {
+		return nil, err
+	}
+	c := NewHTTPClient(endpoints[0], apiKey)
+	c.client.Transport = b
+	c.balancer = b
+	return c, nil
+}
+
+// Endpoints 返回负载均衡节点的状态，未启用负载均衡时返回nil
+func (c *HTTPClient) Endpoints() []EndpointStatus {
+	if c.balancer == nil {
+		return nil
+	}
+	return c.balancer.status()
+}
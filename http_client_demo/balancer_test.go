@@ -0,0 +1,122 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newCountingServer 创建统计请求次数的测试服务器
+func newCountingServer(t *testing.T, status int) (*httptest.Server, *int64) {
+	t.Helper()
+	var count int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&count, 1)
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &count
+}
+
+func sendN(t *testing.T, b *balancer, url string, n int) {
+	t.Helper()
+	client := &http.Client{Transport: b}
+	for i := 0; i < n; i++ {
+		resp, err := client.Get(url + "/users/1")
+		if err != nil {
+			t.Fatalf("请求失败: %v", err)
+		}
+		resp.Body.Close()
+	}
+}
+
+// TestBalancerRoundRobin 测试轮询策略均匀分发请求
+func TestBalancerRoundRobin(t *testing.T) {
+	a, countA := newCountingServer(t, http.StatusOK)
+	b, countB := newCountingServer(t, http.StatusOK)
+
+	lb, err := newBalancer([]string{a.URL, b.URL}, BalancerConfig{Strategy: RoundRobin}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lb.close()
+
+	sendN(t, lb, a.URL, 10)
+	if *countA != 5 || *countB != 5 {
+		t.Errorf("期望各 5 次请求，实际 %d 和 %d", *countA, *countB)
+	}
+}
+
+// TestBalancerStrategies 测试所有策略都会使用每个节点
+func TestBalancerStrategies(t *testing.T) {
+	for _, strategy := range []BalanceStrategy{LeastOutstanding, PowerOfTwoChoices} {
+		a, countA := newCountingServer(t, http.StatusOK)
+		b, countB := newCountingServer(t, http.StatusOK)
+
+		lb, err := newBalancer([]string{a.URL, b.URL}, BalancerConfig{Strategy: strategy}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sendN(t, lb, a.URL, 50)
+		lb.close()
+
+		if *countA == 0 || *countB == 0 {
+			t.Errorf("策略 %d: 期望两个节点都收到请求，实际 %d 和 %d", strategy, *countA, *countB)
+		}
+		for _, st := range lb.status() {
+			if st.Outstanding != 0 {
+				t.Errorf("策略 %d: 请求结束后未完成数应为 0，实际 %d", strategy, st.Outstanding)
+			}
+		}
+	}
+}
+
+// TestBalancerEjectsFailingEndpoint 测试连续失败的节点被摘除
+func TestBalancerEjectsFailingEndpoint(t *testing.T) {
+	good, countGood := newCountingServer(t, http.StatusOK)
+	bad, countBad := newCountingServer(t, http.StatusInternalServerError)
+
+	lb, err := newBalancer([]string{good.URL, bad.URL}, BalancerConfig{
+		MaxFailures:  2,
+		EjectionTime: time.Minute,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lb.close()
+
+	sendN(t, lb, good.URL, 20)
+	if *countBad != 2 {
+		t.Errorf("期望故障节点在 2 次失败后被摘除，实际收到 %d 次请求", *countBad)
+	}
+	if *countGood != 18 {
+		t.Errorf("期望健康节点收到 18 次请求，实际 %d", *countGood)
+	}
+}
+
+// TestBalancerActiveHealthCheck 测试主动健康检查标记不健康的节点
+func TestBalancerActiveHealthCheck(t *testing.T) {
+	good, _ := newCountingServer(t, http.StatusOK)
+	bad, _ := newCountingServer(t, http.StatusServiceUnavailable)
+
+	lb, err := newBalancer([]string{good.URL, bad.URL}, BalancerConfig{
+		HealthCheckPath:     "/healthz",
+		HealthCheckInterval: 10 * time.Millisecond,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lb.close()
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		st := lb.status()
+		if st[0].Healthy && !st[1].Healthy {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Errorf("健康检查未能识别不健康节点: %+v", lb.status())
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"http_client_demo/api"
+)
+
+// ImportResult 批量导入结果中的一行
+type ImportResult = api.ImportResult
+
+// ImportReport 批量导入的汇总，Failures 只保留出错的行
+type ImportReport struct {
+	Created  int
+	Failed   int
+	Failures []ImportResult
+}
+
+// streamingClient 返回不带整体超时的客户端，用于事件流和批量导入导出这类持续时间不确定的请求，
+// 与普通请求共用连接池和负载均衡、调试等 Transport 设置
+func (c *HTTPClient) streamingClient() *http.Client {
+	return &http.Client{Transport: c.client.Transport}
+}
+
+// ImportUsers 批量导入用户（v2）。r 中每行一个用户的JSON，边读边发送，不会整体读入内存。
+// mode 为空时使用 api.ImportBestEffort；atomic 模式下任何一行出错都不会创建用户
+func (c *HTTPClient) ImportUsers(r io.Reader, mode api.ImportMode) (*ImportReport, error) {
+	endpoint, err := c.endpoint("POST", api.PathUsersImport)
+	if err != nil {
+		return nil, err
+	}
+	if mode != "" {
+		endpoint += "?" + url.Values{"mode": {string(mode)}}.Encode()
+	}
+
+	req, err := http.NewRequest("POST", endpoint, r)
+	if err != nil {
+		return nil, fmt.Errorf("创建请求失败: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	req.Header.Set("Content-Type", api.NDJSONContentType)
+
+	resp, err := c.streamingClient().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("发送请求失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(resp, "导入用户失败")
+	}
+
+	// 结果逐行返回，只保留出错的行，最后一行是汇总
+	report := &ImportReport{}
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var result ImportResult
+		if err := dec.Decode(&result); err != nil {
+			if err == io.EOF {
+				return nil, fmt.Errorf("导入结果不完整，缺少汇总")
+			}
+			return nil, fmt.Errorf("解析导入结果失败: %v", err)
+		}
+		switch result.Status {
+		case api.ImportDone:
+			report.Created, report.Failed = result.Created, result.Failed
+			return report, nil
+		case api.ImportFailed:
+			report.Failures = append(report.Failures, result)
+		}
+	}
+}
+
+// ExportUsers 导出所有用户（v2）并写入 w，format 为空时使用 api.ExportNDJSON
+func (c *HTTPClient) ExportUsers(w io.Writer, format api.ExportFormat) error {
+	endpoint, err := c.endpoint("GET", api.PathUsersExport)
+	if err != nil {
+		return err
+	}
+	if format != "" {
+		endpoint += "?" + url.Values{"format": {string(format)}}.Encode()
+	}
+
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("创建请求失败: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+
+	resp, err := c.streamingClient().Do(req)
+	if err != nil {
+		return fmt.Errorf("发送请求失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp, "导出用户失败")
+	}
+
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return fmt.Errorf("读取导出数据失败: %v", err)
+	}
+	return nil
+}
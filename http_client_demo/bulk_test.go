@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"http_client_demo/api"
+)
+
+// TestImportUsers 测试导入请求体原样发送、只保留出错的行，以及缺少汇总时返回错误
+func TestImportUsers(t *testing.T) {
+	var body, mode string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		body, mode = string(data), r.URL.Query().Get("mode")
+		w.Header().Set("Content-Type", api.NDJSONContentType)
+		w.Write([]byte(`{"line":1,"status":"created","user":{"id":4}}` + "\n" +
+			`{"line":2,"status":"failed","error":"请求数据校验失败","invalid_params":[{"name":"email","reason":"邮箱格式不正确"}]}` + "\n"))
+		if !strings.Contains(string(data), "truncated") {
+			w.Write([]byte(`{"status":"done","created":1,"failed":1}` + "\n"))
+		}
+	}))
+	defer srv.Close()
+
+	client := NewHTTPClient(srv.URL, "your-api-key-here")
+	input := `{"name":"赵六","email":"zhaoliu@example.com"}` + "\n" + `{"name":"钱七","email":"qianqi"}` + "\n"
+	report, err := client.ImportUsers(strings.NewReader(input), api.ImportAtomic)
+	if err != nil {
+		t.Fatalf("导入失败: %v", err)
+	}
+	if body != input || mode != "atomic" {
+		t.Errorf("请求不正确: mode=%s body=%q", mode, body)
+	}
+	if report.Created != 1 || report.Failed != 1 || len(report.Failures) != 1 ||
+		report.Failures[0].Line != 2 || report.Failures[0].InvalidParams[0].Name != "email" {
+		t.Errorf("汇总不正确: %+v", report)
+	}
+
+	if _, err := client.ImportUsers(strings.NewReader("truncated"), ""); err == nil || mode != "" {
+		t.Errorf("缺少汇总期望返回错误，实际 %v（mode=%s）", err, mode)
+	}
+}
+
+// TestExportUsers 测试导出格式参数和错误响应
+func TestExportUsers(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("format") == "xml" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"success":false,"message":"未知导出格式: xml"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "text/csv")
+		w.Write([]byte("id,name,email,role,version\n1,张三,zhangsan@example.com,admin,1\n"))
+	}))
+	defer srv.Close()
+
+	client := NewHTTPClient(srv.URL, "your-api-key-here")
+	var buf bytes.Buffer
+	if err := client.ExportUsers(&buf, api.ExportCSV); err != nil || !strings.Contains(buf.String(), "1,张三") {
+		t.Errorf("导出失败: %v %q", err, buf.String())
+	}
+	if err := client.ExportUsers(io.Discard, "xml"); err == nil || !strings.Contains(err.Error(), "400") {
+		t.Errorf("期望返回状态码 400，实际 %v", err)
+	}
+}
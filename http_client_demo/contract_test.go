@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"http_client_demo/api"
+)
+
+// TestContractClientRoutes 测试客户端发出的每个请求都是 api 包声明过的路由
+func TestContractClientRoutes(t *testing.T) {
+	for _, v := range api.Versions {
+		var mu sync.Mutex
+		seen := make(map[api.Route]bool)
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route, ok := api.Match(v, r.Method, r.URL.Path)
+			if !ok {
+				t.Errorf("%s: 客户端请求了未声明的路由 %s %s", v, r.Method, r.URL.Path)
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			mu.Lock()
+			seen[route] = true
+			mu.Unlock()
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"success":true,"data":{"id":1}}`))
+		}))
+
+		client := NewHTTPClient(srv.URL, "your-api-key-here")
+		client.SetAPIVersion(v)
+		user := &User{ID: 1, Name: "张三", Email: "zhangsan@example.com", Version: 1}
+
+		client.GetUser(1)
+		client.CreateUser(user)
+		client.LoginWithForm("zhangsan@example.com", "password123")
+		client.UploadFile("test.txt", []byte("data"))
+		client.CreateUserWithEncryption(user, []byte("your-32-byte-encryption-key-here"))
+		client.GetUserWithCustomToken(1, "your-secret-key")
+		client.UpdateUser(user)
+		client.DeleteUser(1)
+		client.SearchUsers(NewUserQuery().Contains("zhang"))
+		client.ListFiles()
+		client.DownloadFile("abc", 0, io.Discard)
+		client.DeleteFile("abc")
+		client.Me()
+		client.Logout()
+		ctx, cancel := context.WithCancel(context.Background())
+		client.Subscribe(ctx)
+		cancel()
+		client.ImportUsers(strings.NewReader(`{"name":"张三","email":"zhangsan@example.com"}`), "")
+		client.ExportUsers(io.Discard, "")
+		srv.Close()
+
+		for _, route := range api.Routes(v) {
+			if !seen[route] {
+				t.Errorf("%s: 客户端没有调用路由 %s %s", v, route.Method, route.Path)
+			}
+		}
+	}
+}
+
+// TestClientRejectsUnsupportedVersion 测试旧版本API下不会发出新版本才有的请求
+func TestClientRejectsUnsupportedVersion(t *testing.T) {
+	client := NewHTTPClient("http://127.0.0.1:0", "your-api-key-here")
+	client.SetAPIVersion(api.V1)
+	if err := client.DeleteUser(1); err == nil {
+		t.Error("v1 不支持删除用户，应返回错误")
+	}
+}
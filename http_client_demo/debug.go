@@ -0,0 +1,514 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// 脱敏后的占位符
+const redacted = "[REDACTED]"
+
+// harFlushInterval 有新条目时重写HAR文件的间隔，关闭客户端时再写入一次
+const harFlushInterval = time.Second
+
+// 默认脱敏的请求头和字段
+var (
+	defaultRedactHeaders = []string{"Authorization", "Cookie", "Set-Cookie", "X-Api-Key"}
+	defaultRedactFields  = []string{"password", "token", "encrypted_data"}
+)
+
+// DebugConfig 调试模式配置
+type DebugConfig struct {
+	// CurlOutput 不为nil时，每个发出的请求都会以curl命令的形式写入
+	CurlOutput io.Writer
+	// HARFile 不为空时，把完整的请求和响应记录到HAR 1.2文件。
+	// 文件每秒更新一次，调用 HTTPClient.Close 时写入剩余的条目
+	HARFile string
+	// RedactHeaders 需要脱敏的请求头和响应头，不区分大小写，为空时使用默认值
+	RedactHeaders []string
+	// RedactFields 需要脱敏的JSON、表单字段或URL查询参数，不区分大小写。
+	// "password" 匹配任意层级的 password 字段（即 User.Password），
+	// "data.token" 只匹配 data 对象下的 token 字段。为空时使用默认值
+	RedactFields []string
+}
+
+// EnableDebug 开启调试模式，之后的请求会按配置输出curl命令和HAR记录
+func (c *HTTPClient) EnableDebug(config DebugConfig) error {
+	if len(config.RedactHeaders) == 0 {
+		config.RedactHeaders = defaultRedactHeaders
+	}
+	if len(config.RedactFields) == 0 {
+		config.RedactFields = defaultRedactFields
+	}
+
+	d := &debugTransport{config: config, headers: make(map[string]bool)}
+	for _, h := range config.RedactHeaders {
+		d.headers[http.CanonicalHeaderKey(h)] = true
+	}
+	for _, f := range config.RedactFields {
+		d.fields = append(d.fields, strings.Split(strings.ToLower(f), "."))
+	}
+	if config.HARFile != "" {
+		if err := d.writeHAR(); err != nil {
+			return fmt.Errorf("创建HAR文件失败: %v", err)
+		}
+		d.stop = make(chan struct{})
+		d.done = make(chan struct{})
+		go d.flushLoop()
+	}
+	c.debug = d
+
+	// 负载均衡时记录改写地址后的真实请求
+	if c.balancer != nil {
+		d.next = c.balancer.next
+		c.balancer.next = d
+	} else {
+		d.next = c.client.Transport
+		if d.next == nil {
+			d.next = http.DefaultTransport
+		}
+		c.client.Transport = d
+	}
+	return nil
+}
+
+// debugTransport 记录请求的 http.RoundTripper
+type debugTransport struct {
+	next    http.RoundTripper
+	config  DebugConfig
+	headers map[string]bool
+	fields  [][]string
+
+	mu      sync.Mutex
+	entries []harEntry
+	dirty   bool // 有尚未写入HAR文件的条目
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// RoundTrip 输出curl命令，发送请求并记录HAR条目
+func (d *debugTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		body, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		reqBody = body
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	if d.config.CurlOutput != nil {
+		d.mu.Lock()
+		fmt.Fprintln(d.config.CurlOutput, d.curl(req, reqBody))
+		d.mu.Unlock()
+	}
+
+	start := time.Now()
+	resp, err := d.next.RoundTrip(req)
+	wait := time.Since(start)
+
+	if d.config.HARFile == "" {
+		return resp, err
+	}
+
+	entry := harEntry{
+		StartedDateTime: start.Format(time.RFC3339Nano),
+		Request:         d.harRequest(req, reqBody),
+		Cache:           struct{}{},
+		Timings:         harTimings{Send: 0, Wait: ms(wait), Receive: 0},
+	}
+
+	if err != nil {
+		entry.Response = harResponse{Headers: []harNameValue{}, Cookies: []harNameValue{}, HeadersSize: -1, BodySize: -1}
+		entry.Error = err.Error()
+	} else {
+		var respBody []byte
+		// 事件流不会结束，不能读取完整响应体
+		if mediaType(resp.Header.Get("Content-Type")) != "text/event-stream" {
+			body, readErr := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if readErr != nil {
+				return nil, readErr
+			}
+			respBody = body
+			resp.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		entry.Response = d.harResponse(resp, respBody)
+		entry.Timings.Receive = ms(time.Since(start) - wait)
+	}
+	entry.Time = ms(time.Since(start))
+
+	d.mu.Lock()
+	d.entries = append(d.entries, entry)
+	d.dirty = true
+	d.mu.Unlock()
+	return resp, err
+}
+
+// flushLoop 定期把新条目写入HAR文件，避免每个请求都重写整个文件
+func (d *debugTransport) flushLoop() {
+	defer close(d.done)
+	ticker := time.NewTicker(harFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-ticker.C:
+			d.flush()
+		}
+	}
+}
+
+// flush 有新条目时重写HAR文件
+func (d *debugTransport) flush() {
+	d.mu.Lock()
+	dirty := d.dirty
+	d.mu.Unlock()
+	if !dirty {
+		return
+	}
+	if err := d.writeHAR(); err != nil {
+		fmt.Fprintf(os.Stderr, "写入HAR文件失败: %v\n", err)
+		d.mu.Lock()
+		d.dirty = true
+		d.mu.Unlock()
+	}
+}
+
+// close 停止定期写入并写入剩余的条目
+func (d *debugTransport) close() {
+	if d.stop == nil {
+		return
+	}
+	select {
+	case <-d.stop:
+		return
+	default:
+		close(d.stop)
+	}
+	<-d.done
+	d.flush()
+}
+
+// curl 把请求渲染为可直接运行的curl命令
+func (d *debugTransport) curl(req *http.Request, body []byte) string {
+	var b strings.Builder
+	b.WriteString("curl -X " + req.Method + " " + shellQuote(d.redactURL(req.URL).String()))
+
+	for _, h := range d.headerList(req.Header) {
+		b.WriteString(" \\\n  -H " + shellQuote(h.Name+": "+h.Value))
+	}
+
+	if len(body) > 0 {
+		redactedBody := d.redactBody(req.Header.Get("Content-Type"), body)
+		if utf8.Valid(redactedBody) {
+			b.WriteString(" \\\n  --data-binary " + shellQuote(string(redactedBody)))
+		} else {
+			b.WriteString(fmt.Sprintf(" \\\n  --data-binary @body.bin  # 二进制请求体 %d 字节", len(body)))
+		}
+	}
+	return b.String()
+}
+
+// headerList 返回按名称排序并脱敏后的请求头，Location 中的查询参数同样脱敏
+func (d *debugTransport) headerList(header http.Header) []harNameValue {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	list := []harNameValue{}
+	for _, name := range names {
+		for _, value := range header[name] {
+			if d.headers[http.CanonicalHeaderKey(name)] {
+				value = redacted
+			} else if http.CanonicalHeaderKey(name) == "Location" {
+				value = d.redactLocation(value)
+			}
+			list = append(list, harNameValue{Name: name, Value: value})
+		}
+	}
+	return list
+}
+
+// redactBody 按字段规则脱敏JSON和表单请求体，其他类型原样返回
+func (d *debugTransport) redactBody(contentType string, body []byte) []byte {
+	switch mediaType(contentType) {
+	case "application/json", "application/problem+json":
+		var v interface{}
+		if err := json.Unmarshal(body, &v); err != nil {
+			return body
+		}
+		out, err := json.Marshal(d.redactValue(v, nil))
+		if err != nil {
+			return body
+		}
+		return out
+	case "application/x-www-form-urlencoded":
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			return body
+		}
+		for key := range values {
+			if d.matchField([]string{strings.ToLower(key)}) {
+				values[key] = []string{redacted}
+			}
+		}
+		return []byte(values.Encode())
+	}
+	return body
+}
+
+// redactURL 返回查询参数按字段规则脱敏后的URL副本
+func (d *debugTransport) redactURL(u *url.URL) *url.URL {
+	copied := *u
+	if copied.User != nil {
+		if _, ok := copied.User.Password(); ok {
+			copied.User = url.UserPassword(copied.User.Username(), redacted)
+		}
+	}
+	if copied.RawQuery == "" {
+		return &copied
+	}
+	query := copied.Query()
+	changed := false
+	for key := range query {
+		if d.matchField([]string{strings.ToLower(key)}) {
+			query[key] = []string{redacted}
+			changed = true
+		}
+	}
+	if changed {
+		copied.RawQuery = query.Encode()
+	}
+	return &copied
+}
+
+// redactValue 递归脱敏JSON值
+func (d *debugTransport) redactValue(v interface{}, path []string) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for key, child := range val {
+			childPath := append(append([]string(nil), path...), strings.ToLower(key))
+			if d.matchField(childPath) {
+				val[key] = redacted
+			} else {
+				val[key] = d.redactValue(child, childPath)
+			}
+		}
+	case []interface{}:
+		for i, child := range val {
+			val[i] = d.redactValue(child, path)
+		}
+	}
+	return v
+}
+
+// matchField 判断字段路径是否以某条脱敏规则结尾
+func (d *debugTransport) matchField(path []string) bool {
+	for _, rule := range d.fields {
+		if len(rule) > len(path) {
+			continue
+		}
+		tail := path[len(path)-len(rule):]
+		matched := true
+		for i := range rule {
+			if rule[i] != tail[i] {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *debugTransport) harRequest(req *http.Request, body []byte) harRequest {
+	u := d.redactURL(req.URL)
+	hr := harRequest{
+		Method:      req.Method,
+		URL:         u.String(),
+		HTTPVersion: "HTTP/1.1",
+		Cookies:     []harNameValue{},
+		Headers:     d.headerList(req.Header),
+		QueryString: []harNameValue{},
+		HeadersSize: -1,
+		BodySize:    len(body),
+	}
+	for key, values := range u.Query() {
+		for _, value := range values {
+			hr.QueryString = append(hr.QueryString, harNameValue{Name: key, Value: value})
+		}
+	}
+	if len(body) > 0 {
+		hr.PostData = &harPostData{
+			MimeType: req.Header.Get("Content-Type"),
+			Text:     harText(d.redactBody(req.Header.Get("Content-Type"), body)),
+		}
+	}
+	return hr
+}
+
+// redactLocation 脱敏重定向地址中的查询参数，无法解析时原样返回
+func (d *debugTransport) redactLocation(location string) string {
+	u, err := url.Parse(location)
+	if err != nil || location == "" {
+		return location
+	}
+	return d.redactURL(u).String()
+}
+
+func (d *debugTransport) harResponse(resp *http.Response, body []byte) harResponse {
+	contentType := resp.Header.Get("Content-Type")
+	return harResponse{
+		Status:      resp.StatusCode,
+		StatusText:  http.StatusText(resp.StatusCode),
+		HTTPVersion: resp.Proto,
+		Cookies:     []harNameValue{},
+		Headers:     d.headerList(resp.Header),
+		Content: harContent{
+			Size:     len(body),
+			MimeType: contentType,
+			Text:     harText(d.redactBody(contentType, body)),
+		},
+		RedirectURL: d.redactLocation(resp.Header.Get("Location")),
+		HeadersSize: -1,
+		BodySize:    len(body),
+	}
+}
+
+// writeHAR 把全部条目重写到HAR文件，只由 EnableDebug、flushLoop 和 close 依次调用
+func (d *debugTransport) writeHAR() error {
+	d.mu.Lock()
+	doc := harDocument{Log: harLog{
+		Version: "1.2",
+		Creator: harCreator{Name: "Go-HTTP-Client", Version: "1.0"},
+		Entries: append([]harEntry{}, d.entries...),
+	}}
+	d.dirty = false
+	d.mu.Unlock()
+
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := d.config.HARFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Clean(d.config.HARFile))
+}
+
+// shellQuote 用单引号包裹字符串，适用于POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// harText 文本内容原样记录，二进制内容不记录
+func harText(body []byte) string {
+	if utf8.Valid(body) {
+		return string(body)
+	}
+	return ""
+}
+
+func mediaType(contentType string) string {
+	mt, _, _ := mime.ParseMediaType(contentType)
+	return mt
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// HAR 1.2 文档结构，见 http://www.softwareishard.com/blog/har-12-spec/
+type harDocument struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	Error           string      `json:"_error,omitempty"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestDebugCurlRedaction 测试curl输出会脱敏认证头和密码字段
+func TestDebugCurlRedaction(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"success":true,"data":{"id":4,"name":"赵六"}}`))
+	}))
+	defer srv.Close()
+
+	var out bytes.Buffer
+	client := NewHTTPClient(srv.URL, "secret-api-key")
+	if err := client.EnableDebug(DebugConfig{CurlOutput: &out}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.CreateUser(&User{Name: "赵六", Email: "zhaoliu@example.com", Password: "p@ss'word"}); err != nil {
+		t.Fatal(err)
+	}
+
+	curl := out.String()
+	if !strings.HasPrefix(curl, "curl -X POST '"+srv.URL) {
+		t.Errorf("curl命令格式不正确: %s", curl)
+	}
+	if strings.Contains(curl, "secret-api-key") || strings.Contains(curl, "p@ss") {
+		t.Errorf("curl命令泄露了敏感信息: %s", curl)
+	}
+	if !strings.Contains(curl, "-H 'Authorization: [REDACTED]'") || !strings.Contains(curl, `"password":"[REDACTED]"`) {
+		t.Errorf("curl命令缺少脱敏后的内容: %s", curl)
+	}
+	if !strings.Contains(curl, "zhaoliu@example.com") {
+		t.Errorf("curl命令应保留非敏感字段: %s", curl)
+	}
+}
+
+// TestDebugHARFile 测试关闭客户端后HAR文件记录完整的请求和响应
+func TestDebugHARFile(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"success":true,"message":"登录成功","data":"token_abc"}`))
+	}))
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "client.har")
+	client := NewHTTPClient(srv.URL, "secret-api-key")
+	if err := client.EnableDebug(DebugConfig{HARFile: path}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.LoginWithForm("zhangsan@example.com", "password123"); err != nil {
+		t.Fatal(err)
+	}
+	// 条目定期写入，关闭客户端时写入剩余的条目
+	client.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("password123")) {
+		t.Error("HAR文件泄露了表单中的密码")
+	}
+
+	var doc harDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("HAR文件不是合法的JSON: %v", err)
+	}
+	if doc.Log.Version != "1.2" || len(doc.Log.Entries) != 1 {
+		t.Fatalf("HAR文件内容不正确: %+v", doc.Log)
+	}
+	entry := doc.Log.Entries[0]
+	if entry.Request.Method != "POST" || entry.Response.Status != http.StatusOK {
+		t.Errorf("HAR条目不正确: %+v", entry)
+	}
+	if !strings.Contains(entry.Response.Content.Text, "token_abc") {
+		t.Errorf("HAR条目缺少响应内容: %+v", entry.Response.Content)
+	}
+}
+
+// TestDebugRedactsQuery 测试curl命令和HAR文件中的URL查询参数按字段规则脱敏
+func TestDebugRedactsQuery(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", "/next?token=secret-next&page=2")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	var out bytes.Buffer
+	path := filepath.Join(t.TempDir(), "client.har")
+	client := NewHTTPClient(srv.URL, "secret-api-key")
+	if err := client.EnableDebug(DebugConfig{CurlOutput: &out, HARFile: path}); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.client.Get(srv.URL + "/v2/users?q=zhang&token=secret-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	client.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, text := range map[string]string{"curl": out.String(), "HAR": string(data)} {
+		if strings.Contains(text, "secret-token") || strings.Contains(text, "secret-next") {
+			t.Errorf("%s 泄露了查询参数中的令牌: %s", name, text)
+		}
+		if !strings.Contains(text, "q=zhang") {
+			t.Errorf("%s 应保留非敏感的查询参数: %s", name, text)
+		}
+	}
+}
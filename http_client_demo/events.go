@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"http_client_demo/api"
+)
+
+// UserEvent 用户变更事件，与服务端共用 api 包中的定义
+type UserEvent = api.UserEvent
+
+const (
+	// 断线后重连的初始等待时间，服务端通过 retry 字段指定时以服务端为准
+	subscribeRetryMin = time.Second
+	// 连续重连失败时等待时间翻倍，最多等待这么久
+	subscribeRetryMax = 30 * time.Second
+	// 超过这个时间没有收到任何数据（包括心跳）就认为连接已断开
+	subscribeIdleTimeout = 45 * time.Second
+)
+
+// Subscribe 订阅用户变更事件（v2），返回的通道按顺序收到事件，ctx 结束后关闭。
+// 首次连接失败时直接返回错误；之后断线会带上 Last-Event-ID 自动重连，服务端补发断线期间的事件。
+// 服务端无法补发时会收到 api.StreamReset 事件，调用方应重新获取全量数据
+func (c *HTTPClient) Subscribe(ctx context.Context) (<-chan UserEvent, error) {
+	url, err := c.endpoint("GET", api.PathEvents)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &subscription{
+		// 事件流是长连接，不能使用整体超时，改由心跳检测断线
+		client: c.streamingClient(),
+		url:    url,
+		apiKey: c.apiKey,
+		retry:  subscribeRetryMin,
+		events: make(chan UserEvent, 16),
+	}
+	body, err := s.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	go s.run(ctx, body)
+	return s.events, nil
+}
+
+// subscription 一次订阅的连接状态
+type subscription struct {
+	client *http.Client
+	url    string
+	apiKey string
+	lastID string
+	retry  time.Duration
+	events chan UserEvent
+}
+
+// connect 建立事件流连接，有 lastID 时请求服务端补发之后的事件
+func (s *subscription) connect(ctx context.Context) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", s.url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("创建请求失败: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+s.apiKey)
+	req.Header.Set("Accept", "text/event-stream")
+	if s.lastID != "" {
+		req.Header.Set("Last-Event-ID", s.lastID)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("发送请求失败: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("订阅事件失败，状态码: %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/event-stream") {
+		resp.Body.Close()
+		return nil, fmt.Errorf("订阅事件失败，响应类型: %s", ct)
+	}
+	return resp.Body, nil
+}
+
+// run 读取事件直到 ctx 结束，断线后按指数退避重连
+func (s *subscription) run(ctx context.Context, body io.ReadCloser) {
+	defer close(s.events)
+
+	failures := 0
+	for {
+		if body != nil {
+			s.read(ctx, body)
+			body.Close()
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		wait := s.retry
+		for i := 0; i < failures && wait < subscribeRetryMax; i++ {
+			wait *= 2
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(min(wait, subscribeRetryMax)):
+		}
+
+		var err error
+		body, err = s.connect(ctx)
+		if err != nil {
+			body = nil
+			failures++
+		} else {
+			failures = 0
+		}
+	}
+}
+
+// read 解析事件流，直到连接断开、长时间没有数据或 ctx 结束
+func (s *subscription) read(ctx context.Context, body io.ReadCloser) {
+	// 关闭连接让阻塞的读取返回
+	idle := time.AfterFunc(subscribeIdleTimeout, func() { body.Close() })
+	defer idle.Stop()
+
+	var id string
+	var data []string
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for scanner.Scan() {
+		idle.Reset(subscribeIdleTimeout)
+		line := scanner.Text()
+
+		// 空行表示一个事件结束
+		if line == "" {
+			if len(data) > 0 {
+				var event UserEvent
+				if err := json.Unmarshal([]byte(strings.Join(data, "\n")), &event); err == nil {
+					select {
+					case s.events <- event:
+					case <-ctx.Done():
+						return
+					}
+				}
+				// 事件交给调用方之后才记录ID，重连时不会漏掉未送达的事件
+				s.lastID = id
+			}
+			data = data[:0]
+			continue
+		}
+		// 冒号开头的是注释，服务端用来发送心跳
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "id":
+			id = value
+		case "data":
+			data = append(data, value)
+		case "retry":
+			if ms, err := strconv.Atoi(value); err == nil && ms > 0 {
+				s.retry = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"http_client_demo/api"
+)
+
+// TestSubscribeReconnects 测试断线后带 Last-Event-ID 重连并继续收到事件，ctx 结束后通道关闭
+func TestSubscribeReconnects(t *testing.T) {
+	var mu sync.Mutex
+	var lastIDs []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		lastIDs = append(lastIDs, r.Header.Get("Last-Event-ID"))
+		n := len(lastIDs)
+		mu.Unlock()
+
+		if n == 2 {
+			// 重连失败一次
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "retry: 10\n\n: heartbeat\n\n")
+		switch n {
+		case 1:
+			// 发送两个事件后断开
+			fmt.Fprint(w, "id: 1\nevent: user.created\ndata: {\"id\":1,\"type\":\"user.created\",\"user\":{\"id\":7}}\n\n")
+			fmt.Fprint(w, "id: 2\nevent: user.updated\ndata: {\"id\":2,\"type\":\"user.updated\",\"user\":{\"id\":7}}\n\n")
+		default:
+			fmt.Fprint(w, "id: 3\nevent: user.deleted\ndata: {\"id\":3,\"type\":\"user.deleted\",\"user\":{\"id\":7}}\n\n")
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		}
+	}))
+	defer srv.Close()
+
+	client := NewHTTPClient(srv.URL, "your-api-key-here")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := client.Subscribe(ctx)
+	if err != nil {
+		t.Fatalf("订阅失败: %v", err)
+	}
+
+	for _, want := range []api.UserEventType{api.UserCreated, api.UserUpdated, api.UserDeleted} {
+		select {
+		case event := <-events:
+			if event.Type != want || event.User == nil || event.User.ID != 7 {
+				t.Errorf("期望 %s 事件，实际 %+v", want, event)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("等待 %s 事件超时", want)
+		}
+	}
+
+	mu.Lock()
+	if len(lastIDs) != 3 || lastIDs[0] != "" || lastIDs[1] != "2" || lastIDs[2] != "2" {
+		t.Errorf("期望重连时带上 Last-Event-ID 2，实际 %q", lastIDs)
+	}
+	mu.Unlock()
+
+	cancel()
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Error("取消后期望通道关闭")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("取消后通道没有关闭")
+	}
+}
+
+// TestSubscribeInitialError 测试首次连接失败时直接返回错误
+func TestSubscribeInitialError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	if _, err := NewHTTPClient(srv.URL, "wrong-key").Subscribe(context.Background()); err == nil {
+		t.Error("期望返回错误")
+	}
+	client := NewHTTPClient(srv.URL, "your-api-key-here")
+	client.SetAPIVersion(api.V1)
+	if _, err := client.Subscribe(context.Background()); err == nil {
+		t.Error("v1 期望不支持订阅")
+	}
+}
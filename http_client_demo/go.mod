@@ -1,3 +1,7 @@
 module http_client_demo
 
 go 1.21
+
+require http_client_demo/api v0.0.0
+
+replace http_client_demo/api => ./api
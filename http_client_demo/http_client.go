@@ -2,34 +2,51 @@ package main
 
 import (
 	"bytes"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"strings"
 	"time"
+
+	"http_client_demo/api"
 )
 
-// User 用户结构体
-type User struct {
-	ID       int    `json:"id"`
-	Name     string `json:"name"`
-	Email    string `json:"email"`
-	Password string `json:"password,omitempty"`
-}
+// User 用户结构体，与服务端共用 api 包中的定义
+type User = api.User
 
 // APIResponse API响应结构体
-type APIResponse struct {
-	Success bool        `json:"success"`
-	Message string      `json:"message"`
-	Data    interface{} `json:"data"`
+type APIResponse = api.APIResponse
+
+// FileInfo 上传文件的元数据
+type FileInfo = api.FileInfo
+
+// Problem 服务端返回的 RFC 7807 错误详情。请求数据格式错误或字段校验失败时，
+// 创建和更新用户等方法返回 *Problem，可以通过 errors.As 取出出错的字段
+type Problem = api.Problem
+
+// UserQuery 用户搜索条件，通过链式方法构造，例如
+// NewUserQuery().Contains("zhang").SortBy(api.SortByName).Select("id", "name")
+type UserQuery = api.UserQuery
+
+// NewUserQuery 创建空的用户搜索条件
+func NewUserQuery() *UserQuery {
+	return api.NewUserQuery()
 }
 
 // HTTPClient HTTP客户端封装
 type HTTPClient struct {
-	client  *http.Client
-	baseURL string
-	apiKey  string
+	client   *http.Client
+	baseURL  string
+	apiKey   string
+	version  api.Version
+	balancer *balancer
+	outbox   *Outbox
+	debug    *debugTransport
 }
 
 // NewHTTPClient 创建新的HTTP客户端
@@ -40,12 +57,69 @@ func NewHTTPClient(baseURL, apiKey string) *HTTPClient {
 		},
 		baseURL: baseURL,
 		apiKey:  apiKey,
+		version: api.V2,
 	}
 }
 
+// SetAPIVersion 设置请求使用的API版本，默认使用最新版本
+func (c *HTTPClient) SetAPIVersion(version api.Version) {
+	c.version = version
+}
+
+// endpoint 生成指定路由的完整URL，当前API版本不提供该路由时返回错误
+func (c *HTTPClient) endpoint(method, path string, params ...interface{}) (string, error) {
+	for _, r := range api.Routes(c.version) {
+		if r.Method == method && r.Path == path {
+			return c.baseURL + c.version.Path(path, params...), nil
+		}
+	}
+	return "", fmt.Errorf("API版本 %s 不支持 %s %s", c.version, method, path)
+}
+
+// Close 释放客户端的后台资源
+func (c *HTTPClient) Close() {
+	if c.outbox != nil {
+		c.outbox.Close()
+	}
+	if c.balancer != nil {
+		c.balancer.close()
+	}
+	if c.debug != nil {
+		c.debug.close()
+	}
+}
+
+// IdempotencyKeyHeader 幂等键请求头，服务端据此识别重复的POST请求
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// do 发送请求，POST请求会自动附带幂等键，
+// 调用方已设置幂等键时保持不变，便于重试同一个逻辑请求
+func (c *HTTPClient) do(req *http.Request) (*http.Response, error) {
+	if req.Method == http.MethodPost && req.Header.Get(IdempotencyKeyHeader) == "" {
+		key, err := newIdempotencyKey()
+		if err != nil {
+			return nil, fmt.Errorf("生成幂等键失败: %v", err)
+		}
+		req.Header.Set(IdempotencyKeyHeader, key)
+	}
+	return c.client.Do(req)
+}
+
+// newIdempotencyKey 生成随机幂等键
+func newIdempotencyKey() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
 // 1. GET请求示例
 func (c *HTTPClient) GetUser(userID int) (*User, error) {
-	url := fmt.Sprintf("%s/users/%d", c.baseURL, userID)
+	url, err := c.endpoint("GET", api.PathUser, userID)
+	if err != nil {
+		return nil, err
+	}
 
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
@@ -56,7 +130,7 @@ func (c *HTTPClient) GetUser(userID int) (*User, error) {
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("User-Agent", "Go-HTTP-Client/1.0")
 
-	resp, err := c.client.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return nil, fmt.Errorf("发送请求失败: %v", err)
 	}
@@ -95,7 +169,10 @@ func (c *HTTPClient) CreateUser(user *User) (*User, error) {
 		return nil, fmt.Errorf("序列化用户数据失败: %v", err)
 	}
 
-	url := fmt.Sprintf("%s/users", c.baseURL)
+	url, err := c.endpoint("POST", api.PathUsers)
+	if err != nil {
+		return nil, err
+	}
 	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
 	if err != nil {
 		return nil, fmt.Errorf("创建请求失败: %v", err)
@@ -103,15 +180,21 @@ func (c *HTTPClient) CreateUser(user *User) (*User, error) {
 
 	req.Header.Set("Authorization", "Bearer "+c.apiKey)
 	req.Header.Set("Content-Type", "application/json")
+	if queued := c.queueBehindBacklog(req, jsonData, user.Password != ""); queued != nil {
+		return nil, queued
+	}
 
-	resp, err := c.client.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
+		if queued := c.queueOffline(req, jsonData, user.Password != "", err); queued != nil {
+			return nil, queued
+		}
 		return nil, fmt.Errorf("发送请求失败: %v", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusCreated {
-		return nil, fmt.Errorf("创建用户失败，状态码: %d", resp.StatusCode)
+		return nil, responseError(resp, "创建用户失败")
 	}
 
 	var apiResp APIResponse
@@ -142,7 +225,10 @@ func (c *HTTPClient) LoginWithForm(username, password string) (string, error) {
 	formData.Set("username", username)
 	formData.Set("password", password)
 
-	url := fmt.Sprintf("%s/login", c.baseURL)
+	url, err := c.endpoint("POST", api.PathLogin)
+	if err != nil {
+		return "", err
+	}
 	req, err := http.NewRequest("POST", url, strings.NewReader(formData.Encode()))
 	if err != nil {
 		return "", fmt.Errorf("创建请求失败: %v", err)
@@ -150,7 +236,7 @@ func (c *HTTPClient) LoginWithForm(username, password string) (string, error) {
 
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	resp, err := c.client.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return "", fmt.Errorf("发送请求失败: %v", err)
 	}
@@ -179,7 +265,10 @@ func (c *HTTPClient) LoginWithForm(username, password string) (string, error) {
 
 // 4. POST Raw数据请求示例
 func (c *HTTPClient) UploadFile(filename string, data []byte) error {
-	url := fmt.Sprintf("%s/upload", c.baseURL)
+	url, err := c.endpoint("POST", api.PathUpload)
+	if err != nil {
+		return err
+	}
 	req, err := http.NewRequest("POST", url, bytes.NewReader(data))
 	if err != nil {
 		return fmt.Errorf("创建请求失败: %v", err)
@@ -188,9 +277,15 @@ func (c *HTTPClient) UploadFile(filename string, data []byte) error {
 	req.Header.Set("Authorization", "Bearer "+c.apiKey)
 	req.Header.Set("Content-Type", "application/octet-stream")
 	req.Header.Set("X-Filename", filename)
+	if queued := c.queueBehindBacklog(req, data, false); queued != nil {
+		return queued
+	}
 
-	resp, err := c.client.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
+		if queued := c.queueOffline(req, data, false, err); queued != nil {
+			return queued
+		}
 		return fmt.Errorf("发送请求失败: %v", err)
 	}
 	defer resp.Body.Close()
@@ -201,3 +296,298 @@ func (c *HTTPClient) UploadFile(filename string, data []byte) error {
 
 	return nil
 }
+
+// ErrVersionConflict 用户在读取之后被其他请求修改，需要重新获取后再更新
+var ErrVersionConflict = errors.New("用户已被修改")
+
+// UpdateUser 更新用户信息（v2）。user.Version 必须是通过 GetUser 获取的版本，
+// 服务端据此判断用户是否已被修改，已被修改时返回 ErrVersionConflict
+func (c *HTTPClient) UpdateUser(user *User) (*User, error) {
+	url, err := c.endpoint("PUT", api.PathUser, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if user.Version == 0 {
+		return nil, fmt.Errorf("缺少用户版本号，请先通过GetUser获取用户")
+	}
+
+	jsonData, err := json.Marshal(user)
+	if err != nil {
+		return nil, fmt.Errorf("序列化用户数据失败: %v", err)
+	}
+
+	req, err := http.NewRequest("PUT", url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("创建请求失败: %v", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("If-Match", api.UserETag(user.Version))
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("发送请求失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusPreconditionFailed {
+		return nil, ErrVersionConflict
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(resp, "更新用户失败")
+	}
+
+	return decodeUserResponse(resp)
+}
+
+// DeleteUser 删除用户（v2），不检查用户是否已被修改
+func (c *HTTPClient) DeleteUser(userID int) error {
+	url, err := c.endpoint("DELETE", api.PathUser, userID)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("DELETE", url, nil)
+	if err != nil {
+		return fmt.Errorf("创建请求失败: %v", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	req.Header.Set("If-Match", "*")
+
+	resp, err := c.do(req)
+	if err != nil {
+		return fmt.Errorf("发送请求失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("删除用户失败，状态码: %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// SearchUsers 按条件搜索用户（v2），q为nil时返回所有用户。
+// 通过 Select 只选择部分字段时，未选择的字段保持零值
+func (c *HTTPClient) SearchUsers(q *UserQuery) ([]User, error) {
+	url, err := c.endpoint("GET", api.PathUsers)
+	if err != nil {
+		return nil, err
+	}
+	if q != nil {
+		if query := q.Values().Encode(); query != "" {
+			url += "?" + query
+		}
+	}
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("创建请求失败: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("发送请求失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var apiResp struct {
+		Success bool   `json:"success"`
+		Message string `json:"message"`
+		Data    []User `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&apiResp); err != nil {
+		return nil, fmt.Errorf("解析响应失败: %v", err)
+	}
+	if !apiResp.Success {
+		return nil, fmt.Errorf("API错误: %s", apiResp.Message)
+	}
+	return apiResp.Data, nil
+}
+
+// ListFiles 列出已上传的文件（v2）
+func (c *HTTPClient) ListFiles() ([]FileInfo, error) {
+	url, err := c.endpoint("GET", api.PathFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("创建请求失败: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("发送请求失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var apiResp struct {
+		Success bool       `json:"success"`
+		Message string     `json:"message"`
+		Data    []FileInfo `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&apiResp); err != nil {
+		return nil, fmt.Errorf("解析响应失败: %v", err)
+	}
+	if !apiResp.Success {
+		return nil, fmt.Errorf("API错误: %s", apiResp.Message)
+	}
+	return apiResp.Data, nil
+}
+
+// DownloadFile 下载文件内容写入w（v2），offset大于0时通过Range请求从该位置续传
+func (c *HTTPClient) DownloadFile(fileID string, offset int64, w io.Writer) (int64, error) {
+	url, err := c.endpoint("GET", api.PathFile, fileID)
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return 0, fmt.Errorf("创建请求失败: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return 0, fmt.Errorf("发送请求失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	expected := http.StatusOK
+	if offset > 0 {
+		expected = http.StatusPartialContent
+	}
+	if resp.StatusCode != expected {
+		return 0, fmt.Errorf("下载失败，状态码: %d", resp.StatusCode)
+	}
+
+	n, err := io.Copy(w, resp.Body)
+	if err != nil {
+		return n, fmt.Errorf("读取文件内容失败: %v", err)
+	}
+	return n, nil
+}
+
+// DeleteFile 删除已上传的文件（v2）
+func (c *HTTPClient) DeleteFile(fileID string) error {
+	url, err := c.endpoint("DELETE", api.PathFile, fileID)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("DELETE", url, nil)
+	if err != nil {
+		return fmt.Errorf("创建请求失败: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+
+	resp, err := c.do(req)
+	if err != nil {
+		return fmt.Errorf("发送请求失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("删除文件失败，状态码: %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// Me 获取当前会话所属的用户（v2），客户端需要用 LoginWithForm 返回的会话令牌创建
+func (c *HTTPClient) Me() (*User, error) {
+	url, err := c.endpoint("GET", api.PathMe)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("创建请求失败: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("发送请求失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("获取当前用户失败，状态码: %d", resp.StatusCode)
+	}
+
+	return decodeUserResponse(resp)
+}
+
+// Logout 注销当前会话（v2），之后该会话令牌不能再使用
+func (c *HTTPClient) Logout() error {
+	url, err := c.endpoint("POST", api.PathLogout)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", url, nil)
+	if err != nil {
+		return fmt.Errorf("创建请求失败: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+
+	resp, err := c.do(req)
+	if err != nil {
+		return fmt.Errorf("发送请求失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("注销失败，状态码: %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// responseError 把失败的响应转换为错误：application/problem+json 响应解析为 *Problem，
+// 其余响应返回带状态码的错误
+func responseError(resp *http.Response, action string) error {
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), api.ProblemContentType) {
+		var problem Problem
+		if err := json.NewDecoder(resp.Body).Decode(&problem); err == nil {
+			return &problem
+		}
+	}
+	return fmt.Errorf("%s，状态码: %d", action, resp.StatusCode)
+}
+
+// decodeUserResponse 从API响应中解析用户数据
+func decodeUserResponse(resp *http.Response) (*User, error) {
+	var apiResp APIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&apiResp); err != nil {
+		return nil, fmt.Errorf("解析响应失败: %v", err)
+	}
+
+	if !apiResp.Success {
+		return nil, fmt.Errorf("API错误: %s", apiResp.Message)
+	}
+
+	userData, err := json.Marshal(apiResp.Data)
+	if err != nil {
+		return nil, fmt.Errorf("序列化用户数据失败: %v", err)
+	}
+
+	var user User
+	if err := json.Unmarshal(userData, &user); err != nil {
+		return nil, fmt.Errorf("反序列化用户数据失败: %v", err)
+	}
+
+	return &user, nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"http_client_demo/api"
+)
+
+// TestCreateUserReturnsProblem 测试 problem+json 错误响应解析为 *Problem，其余错误响应只带状态码
+func TestCreateUserReturnsProblem(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v2/users/1" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"success":false,"message":"服务器内部错误"}`))
+			return
+		}
+		w.Header().Set("Content-Type", api.ProblemContentType)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"type":"/problems/validation-failed","title":"请求数据校验失败","status":400,` +
+			`"invalid_params":[{"name":"email","reason":"邮箱格式不正确"}]}`))
+	}))
+	defer srv.Close()
+
+	client := NewHTTPClient(srv.URL, "your-api-key-here")
+	_, err := client.CreateUser(&User{Name: "张三", Email: "zhangsan"})
+	var problem *Problem
+	if !errors.As(err, &problem) {
+		t.Fatalf("期望 *Problem，实际 %v", err)
+	}
+	if problem.Type != api.ProblemValidation || problem.Status != http.StatusBadRequest || problem.Field("email") != "邮箱格式不正确" {
+		t.Errorf("错误详情不正确: %+v", problem)
+	}
+	if want := "请求数据校验失败（状态码 400）: email: 邮箱格式不正确"; err.Error() != want {
+		t.Errorf("期望 %q，实际 %q", want, err.Error())
+	}
+
+	_, err = client.UpdateUser(&User{ID: 1, Name: "张三", Email: "zhangsan@example.com", Version: 1})
+	if err == nil || errors.As(err, &problem) {
+		t.Errorf("非 problem+json 响应期望普通错误，实际 %v", err)
+	}
+}
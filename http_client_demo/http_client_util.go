@@ -13,6 +13,8 @@ import (
 	"log"
 	"net/http"
 	"time"
+
+	"http_client_demo/api"
 )
 
 // 5. 带加密的POST请求示例
@@ -33,7 +35,10 @@ func (c *HTTPClient) CreateUserWithEncryption(user *User, encryptionKey []byte)
 		return nil, fmt.Errorf("序列化加密请求失败: %v", err)
 	}
 
-	url := fmt.Sprintf("%s/users/encrypted", c.baseURL)
+	url, err := c.endpoint("POST", api.PathEncryptedUsers)
+	if err != nil {
+		return nil, err
+	}
 	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
 	if err != nil {
 		return nil, fmt.Errorf("创建请求失败: %v", err)
@@ -43,14 +48,14 @@ func (c *HTTPClient) CreateUserWithEncryption(user *User, encryptionKey []byte)
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("X-Encryption", "AES-256-CBC")
 
-	resp, err := c.client.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return nil, fmt.Errorf("发送请求失败: %v", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusCreated {
-		return nil, fmt.Errorf("创建用户失败，状态码: %d", resp.StatusCode)
+		return nil, responseError(resp, "创建用户失败")
 	}
 
 	var apiResp APIResponse
@@ -80,7 +85,10 @@ func (c *HTTPClient) GetUserWithCustomToken(userID int, secretKey string) (*User
 	tokenData := fmt.Sprintf("user_%d_%d_%s", userID, time.Now().Unix(), secretKey)
 	tokenString := generateHash(tokenData)
 
-	url := fmt.Sprintf("%s/users/%d", c.baseURL, userID)
+	url, err := c.endpoint("GET", api.PathUser, userID)
+	if err != nil {
+		return nil, err
+	}
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("创建请求失败: %v", err)
@@ -89,7 +97,7 @@ func (c *HTTPClient) GetUserWithCustomToken(userID int, secretKey string) (*User
 	req.Header.Set("Authorization", "Bearer "+tokenString)
 	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := c.client.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return nil, fmt.Errorf("发送请求失败: %v", err)
 	}
@@ -139,6 +147,29 @@ func (c *HTTPClient) GetUserWithRetry(userID int, maxRetries int) (*User, error)
 	return nil, fmt.Errorf("重试%d次后仍然失败: %v", maxRetries, lastErr)
 }
 
+// modifyUserAttempts ModifyUser 遇到版本冲突时最多尝试的次数
+const modifyUserAttempts = 5
+
+// ModifyUser 读取用户、调用 modify 修改后写回（v2）。
+// 写回时用户已被其他请求修改则重新读取并再次调用 modify，modify 可能被调用多次，不应有副作用
+func (c *HTTPClient) ModifyUser(userID int, modify func(user *User) error) (*User, error) {
+	for i := 0; i < modifyUserAttempts; i++ {
+		user, err := c.GetUser(userID)
+		if err != nil {
+			return nil, err
+		}
+		if err := modify(user); err != nil {
+			return nil, err
+		}
+		updated, err := c.UpdateUser(user)
+		if err != ErrVersionConflict {
+			return updated, err
+		}
+		log.Printf("用户 %d 已被修改，重新读取后重试（第%d次）", userID, i+1)
+	}
+	return nil, fmt.Errorf("修改用户 %d 失败，%d 次尝试都发生版本冲突", userID, modifyUserAttempts)
+}
+
 // 8. 批量请求示例
 func (c *HTTPClient) GetUsersBatch(userIDs []int) ([]*User, error) {
 	users := make([]*User, 0, len(userIDs))
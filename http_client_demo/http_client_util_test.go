@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"http_client_demo/api"
+)
+
+// TestModifyUserRetriesOnConflict 测试写回时发生版本冲突会重新读取并再次修改
+func TestModifyUserRetriesOnConflict(t *testing.T) {
+	var mu sync.Mutex
+	current := User{ID: 1, Name: "张三", Email: "zhangsan@example.com", Version: 1}
+	puts := 0
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.Method == http.MethodPut {
+			puts++
+			// 第一次写回前另一个管理员修改了邮箱
+			if puts == 1 {
+				current.Email = "other@example.com"
+				current.Version++
+			}
+			if r.Header.Get("If-Match") != api.UserETag(current.Version) {
+				w.WriteHeader(http.StatusPreconditionFailed)
+				json.NewEncoder(w).Encode(APIResponse{Message: "用户已被修改"})
+				return
+			}
+			var user User
+			json.NewDecoder(r.Body).Decode(&user)
+			current.Name = user.Name
+			current.Email = user.Email
+			current.Version++
+		}
+		json.NewEncoder(w).Encode(APIResponse{Success: true, Data: current})
+	}))
+	defer srv.Close()
+
+	client := NewHTTPClient(srv.URL, "your-api-key-here")
+	calls := 0
+	updated, err := client.ModifyUser(1, func(u *User) error {
+		calls++
+		u.Name = "张三丰"
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 || puts != 2 {
+		t.Errorf("期望修改 2 次、写回 2 次，实际 %d 次、%d 次", calls, puts)
+	}
+	// 重新读取后保留了另一个管理员的修改
+	if updated.Name != "张三丰" || updated.Email != "other@example.com" || updated.Version != 3 {
+		t.Errorf("修改结果不正确: %+v", updated)
+	}
+}
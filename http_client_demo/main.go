@@ -1,26 +1,37 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
+	"os"
 	"os/exec"
+	"strings"
 	"time"
+
+	"http_client_demo/api"
 )
 
 func main() {
-	// 启动模拟服务器
-	go func() {
-		// 使用系统命令启动独立的服务器
-		cmd := exec.Command("go", "run", "server/.")
-		cmd.Dir = "."
-		cmd.Start()
-	}()
+	// 使用系统命令启动独立的模拟服务器
+	cmd := exec.Command("go", "run", ".")
+	cmd.Dir = "server"
+	if err := cmd.Start(); err != nil {
+		log.Printf("启动服务器失败: %v", err)
+	}
 
-	// 等待服务器启动
-	time.Sleep(3 * time.Second)
+	// 等待服务器就绪
+	if err := waitForServer("http://localhost:8080", 30*time.Second); err != nil {
+		log.Fatalf("服务器未就绪: %v", err)
+	}
 
 	// 创建HTTP客户端
 	client := NewHTTPClient("http://localhost:8080", "your-api-key-here")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
 	fmt.Println("=== GET请求示例 ===")
 	user, err := client.GetUser(1)
@@ -30,10 +41,16 @@ func main() {
 		fmt.Printf("获取到用户: %+v\n", user)
 	}
 
+	// 先订阅事件，之后创建和修改用户时可以收到通知
+	events, err := client.Subscribe(ctx)
+	if err != nil {
+		log.Printf("订阅用户事件失败: %v", err)
+	}
+
 	fmt.Println("\n=== POST JSON请求示例 ===")
 	newUser := &User{
-		Name:     "张三",
-		Email:    "zhangsan@example.com",
+		Name:     "赵六",
+		Email:    "zhaoliu@example.com",
 		Password: "password123",
 	}
 	createdUser, err := client.CreateUser(newUser)
@@ -43,12 +60,92 @@ func main() {
 		fmt.Printf("创建用户成功: %+v\n", createdUser)
 	}
 
+	fmt.Println("\n=== 乐观并发更新示例 ===")
+	if createdUser != nil {
+		modified, err := client.ModifyUser(createdUser.ID, func(u *User) error {
+			u.Name = "张三丰"
+			return nil
+		})
+		if err != nil {
+			log.Printf("修改用户失败: %v", err)
+		} else {
+			fmt.Printf("修改用户成功: %+v\n", modified)
+		}
+	}
+
+	fmt.Println("\n=== 订阅用户事件示例 ===")
+	if events != nil {
+		timeout := time.After(2 * time.Second)
+	receive:
+		for i := 0; i < 2; i++ {
+			select {
+			case event := <-events:
+				fmt.Printf("收到事件 %d: %s 用户 %d\n", event.ID, event.Type, event.User.ID)
+			case <-timeout:
+				log.Printf("等待用户事件超时")
+				break receive
+			}
+		}
+	}
+
+	fmt.Println("\n=== 数据校验示例 ===")
+	_, err = client.CreateUser(&User{Name: "李四", Email: "lisi", Password: "123"})
+	var problem *Problem
+	if errors.As(err, &problem) {
+		for _, param := range problem.InvalidParams {
+			fmt.Printf("字段 %s: %s\n", param.Name, param.Reason)
+		}
+	} else {
+		log.Printf("期望校验失败，实际: %v", err)
+	}
+
+	fmt.Println("\n=== 搜索用户示例 ===")
+	found, err := client.SearchUsers(NewUserQuery().Contains("example.com").SortBy(api.SortByName).Select("id", "name"))
+	if err != nil {
+		log.Printf("搜索用户失败: %v", err)
+	} else {
+		for _, u := range found {
+			fmt.Printf("用户 %d: %s\n", u.ID, u.Name)
+		}
+	}
+
+	fmt.Println("\n=== 批量导入导出示例 ===")
+	lines := `{"name":"周九","email":"zhoujiu@example.com","password":"zhoujiu2024"}
+{"name":"吴十","email":"wushi"}
+{"name":"郑十一","email":"zhengshiyi@example.com"}
+`
+	report, err := client.ImportUsers(strings.NewReader(lines), api.ImportBestEffort)
+	if err != nil {
+		log.Printf("批量导入失败: %v", err)
+	} else {
+		fmt.Printf("导入成功 %d 个，失败 %d 个\n", report.Created, report.Failed)
+		for _, failure := range report.Failures {
+			fmt.Printf("第 %d 行: %s %v\n", failure.Line, failure.Error, failure.InvalidParams)
+		}
+	}
+	if err := client.ExportUsers(os.Stdout, api.ExportCSV); err != nil {
+		log.Printf("导出用户失败: %v", err)
+	}
+
 	fmt.Println("\n=== POST Form请求示例 ===")
 	token, err := client.LoginWithForm("zhangsan@example.com", "password123")
 	if err != nil {
 		log.Printf("登录失败: %v", err)
 	} else {
 		fmt.Printf("登录成功，获得token: %s\n", token)
+
+		// 会话令牌可以代替API Key调用接口
+		sessionClient := NewHTTPClient("http://localhost:8080", token)
+		if me, err := sessionClient.Me(); err != nil {
+			log.Printf("获取当前用户失败: %v", err)
+		} else {
+			fmt.Printf("当前登录用户: %s (%s)，角色: %s\n", me.Name, me.Email, me.Role)
+		}
+		if err := sessionClient.Logout(); err != nil {
+			log.Printf("注销失败: %v", err)
+		} else if _, err := sessionClient.Me(); err != nil {
+			fmt.Printf("注销后会话失效: %v\n", err)
+		}
 	}
 
 	fmt.Println("\n=== POST Raw数据请求示例 ===")
@@ -60,6 +157,20 @@ func main() {
 		fmt.Println("文件上传成功")
 	}
 
+	fmt.Println("\n=== 文件列表和下载示例 ===")
+	files, err := client.ListFiles()
+	if err != nil {
+		log.Printf("获取文件列表失败: %v", err)
+	}
+	for _, f := range files {
+		var content bytes.Buffer
+		if _, err := client.DownloadFile(f.ID, 0, &content); err != nil {
+			log.Printf("下载文件失败: %v", err)
+			continue
+		}
+		fmt.Printf("文件 %s（%d 字节）: %s\n", f.Name, f.Size, content.String())
+	}
+
 	fmt.Println("\n=== 带加密的POST请求示例 ===")
 	encryptionKey := []byte("your-32-byte-encryption-key-here")
 	encryptedUser, err := client.CreateUserWithEncryption(newUser, encryptionKey)
@@ -102,3 +213,23 @@ func main() {
 	hash := generateHash(password)
 	fmt.Printf("密码: %s\n哈希值: %s\n", password, hash)
 }
+
+// waitForServer 轮询服务器的就绪检查接口，直到返回200或超时
+func waitForServer(baseURL string, timeout time.Duration) error {
+	client := &http.Client{Timeout: time.Second}
+	deadline := time.Now().Add(timeout)
+	for {
+		resp, err := client.Get(baseURL + "/readyz")
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return nil
+			}
+			err = fmt.Errorf("状态码 %d", resp.StatusCode)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("等待 %s 就绪超时: %v", baseURL, err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
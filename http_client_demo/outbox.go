@@ -0,0 +1,588 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// 发件箱条目状态
+const (
+	OutboxPending   = "pending"
+	OutboxDelivered = "delivered"
+	OutboxFailed    = "failed"
+)
+
+const (
+	// outboxHistory 内存中保留的最近已完成条目数，供 Status 和 Items 查询
+	outboxHistory = 100
+	// outboxCompactEvery 持续重放时每完成这么多条目压缩一次记录文件
+	outboxCompactEvery = 1000
+)
+
+// 重放时需要保留的请求头
+var outboxHeaders = []string{"Content-Type", "X-Filename", "X-Encryption", IdempotencyKeyHeader}
+
+// OutboxItem 发件箱中的一个待发送请求
+type OutboxItem struct {
+	ID        string            `json:"id"`
+	Method    string            `json:"method"`
+	URL       string            `json:"url"`
+	Header    map[string]string `json:"header"`
+	Body      []byte            `json:"body"`
+	Status    string            `json:"status"`
+	Attempts  int               `json:"attempts"`
+	LastError string            `json:"last_error,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+	UpdatedAt time.Time         `json:"updated_at"`
+}
+
+// outboxRecord 发件箱文件中的一行记录
+type outboxRecord struct {
+	Op   string      `json:"op"` // enqueue / delivered / failed
+	ID   string      `json:"id"`
+	Item *OutboxItem `json:"item,omitempty"`
+	// Sealed 为true时 Item.Body 是用 EncryptionKey 加密后的密文
+	Sealed bool      `json:"sealed,omitempty"`
+	Error  string    `json:"error,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+// OutboxConfig 发件箱配置
+type OutboxConfig struct {
+	RetryInterval    time.Duration // 首次重试间隔，默认1秒
+	MaxRetryInterval time.Duration // 最大重试间隔，默认1分钟
+	// EncryptionKey AES密钥（16、24或32字节），设置后请求体用AES-GCM加密后写入文件。
+	// 未设置时包含密码的请求不会写入发件箱
+	EncryptionKey []byte
+}
+
+// errOutboxSensitive 未配置加密密钥时拒绝把包含密码的请求写入文件
+var errOutboxSensitive = errors.New("请求包含密码，未配置 EncryptionKey 时不写入发件箱")
+
+// QueuedError 请求已写入发件箱，稍后自动重放。
+// Cause 为服务端不可达的错误，或者 ErrOutboxBacklog（前面还有未送达的请求）
+type QueuedError struct {
+	ItemID string
+	Cause  error
+}
+
+func (e *QueuedError) Error() string {
+	return fmt.Sprintf("请求已加入发件箱(%s): %v", e.ItemID, e.Cause)
+}
+
+// ErrOutboxBacklog 发件箱中还有未送达的请求，新的请求直接排在后面以保持顺序
+var ErrOutboxBacklog = errors.New("发件箱中还有未送达的请求")
+
+func (e *QueuedError) Unwrap() error {
+	return e.Cause
+}
+
+// Outbox 持久化发件箱：离线时把变更请求追加写入本地文件，
+// 后台协程在网络恢复后按顺序携带原幂等键重放
+type Outbox struct {
+	client *HTTPClient
+	config OutboxConfig
+	path   string
+	aead   cipher.AEAD // 配置了加密密钥时用于加密请求体
+
+	mu       sync.Mutex
+	file     *os.File
+	items    []*OutboxItem
+	finished int // 上次压缩之后完成的条目数
+
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+// EnableOutbox 为客户端开启发件箱，path 为追加写入的记录文件。
+// 开启后 CreateUser 和 UploadFile 在服务端不可达时返回 *QueuedError
+func (c *HTTPClient) EnableOutbox(path string, config OutboxConfig) (*Outbox, error) {
+	if c.outbox != nil {
+		return nil, fmt.Errorf("发件箱已开启")
+	}
+	if config.RetryInterval <= 0 {
+		config.RetryInterval = time.Second
+	}
+	if config.MaxRetryInterval <= 0 {
+		config.MaxRetryInterval = time.Minute
+	}
+
+	o := &Outbox{
+		client: c,
+		config: config,
+		path:   path,
+		wake:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	if len(config.EncryptionKey) > 0 {
+		block, err := aes.NewCipher(config.EncryptionKey)
+		if err != nil {
+			return nil, fmt.Errorf("发件箱加密密钥无效: %v", err)
+		}
+		if o.aead, err = cipher.NewGCM(block); err != nil {
+			return nil, fmt.Errorf("发件箱加密密钥无效: %v", err)
+		}
+	}
+	if err := o.load(); err != nil {
+		return nil, err
+	}
+
+	c.outbox = o
+	go o.run()
+	return o, nil
+}
+
+// load 读取记录文件恢复发件箱，并把文件压缩为只包含未完成和失败的条目
+func (o *Outbox) load() error {
+	f, err := os.Open(o.path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("打开发件箱文件失败: %v", err)
+	}
+
+	byID := make(map[string]*OutboxItem)
+	if f != nil {
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+		for scanner.Scan() {
+			var rec outboxRecord
+			if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+				// 崩溃时可能留下写了一半的最后一行，之后的内容全部忽略
+				break
+			}
+			switch rec.Op {
+			case "enqueue":
+				if rec.Item != nil {
+					if rec.Sealed {
+						if rec.Item.Body, err = o.open(rec.Item.ID, rec.Item.Body); err != nil {
+							f.Close()
+							return fmt.Errorf("解密发件箱条目 %s 失败: %v", rec.ID, err)
+						}
+					}
+					rec.Item.Status = OutboxPending
+					byID[rec.Item.ID] = rec.Item
+					o.items = append(o.items, rec.Item)
+				}
+			case OutboxDelivered, OutboxFailed:
+				if item, ok := byID[rec.ID]; ok {
+					item.Status = rec.Op
+					item.LastError = rec.Error
+					item.UpdatedAt = rec.Time
+				}
+			}
+		}
+		err := scanner.Err()
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("读取发件箱文件失败: %v", err)
+		}
+	}
+
+	// 已送达的条目不再保留
+	kept := o.items[:0]
+	for _, item := range o.items {
+		if item.Status != OutboxDelivered {
+			kept = append(kept, item)
+		}
+	}
+	o.items = kept
+	o.prune()
+
+	return o.compact()
+}
+
+// prune 只保留未完成的条目和最近 outboxHistory 个已完成的条目，调用方需持有锁
+func (o *Outbox) prune() {
+	finished := len(o.items) - o.depthLocked()
+	kept := o.items[:0]
+	for _, item := range o.items {
+		if item.Status == OutboxPending || finished <= outboxHistory {
+			kept = append(kept, item)
+		} else {
+			finished--
+		}
+	}
+	clear(o.items[len(kept):])
+	o.items = kept
+}
+
+// maybeCompact 上次压缩后有条目完成时清理已完成的条目并压缩记录文件。
+// force 为false时只在完成的条目达到 outboxCompactEvery 后压缩
+func (o *Outbox) maybeCompact(force bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.file == nil || o.finished == 0 || (!force && o.finished < outboxCompactEvery) {
+		return
+	}
+	o.prune()
+	if err := o.compact(); err != nil {
+		log.Printf("压缩发件箱文件失败: %v", err)
+		return
+	}
+	o.finished = 0
+}
+
+// compact 把当前条目重写到临时文件后原子替换记录文件，已送达的条目不写入。
+// 运行中调用时需持有锁
+func (o *Outbox) compact() error {
+	tmp, err := os.CreateTemp(filepath.Dir(o.path), filepath.Base(o.path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("创建发件箱临时文件失败: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	for _, item := range o.items {
+		if item.Status == OutboxDelivered {
+			continue
+		}
+		rec, err := o.enqueueRecord(item)
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		enc.Encode(rec)
+		if item.Status == OutboxFailed {
+			enc.Encode(outboxRecord{Op: OutboxFailed, ID: item.ID, Error: item.LastError, Time: item.UpdatedAt})
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("写入发件箱文件失败: %v", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("同步发件箱文件失败: %v", err)
+	}
+	tmp.Close()
+
+	if err := os.Rename(tmp.Name(), o.path); err != nil {
+		return fmt.Errorf("替换发件箱文件失败: %v", err)
+	}
+
+	f, err := os.OpenFile(o.path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("打开发件箱文件失败: %v", err)
+	}
+	if o.file != nil {
+		o.file.Close()
+	}
+	o.file = f
+	return nil
+}
+
+// enqueueRecord 生成写入文件的 enqueue 记录，配置了密钥时记录中的请求体为密文
+func (o *Outbox) enqueueRecord(item *OutboxItem) (outboxRecord, error) {
+	rec := outboxRecord{Op: "enqueue", ID: item.ID, Item: item, Time: item.CreatedAt}
+	if o.aead == nil {
+		return rec, nil
+	}
+	nonce := make([]byte, o.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return rec, fmt.Errorf("加密发件箱条目失败: %v", err)
+	}
+	sealed := *item
+	sealed.Body = o.aead.Seal(nonce, nonce, item.Body, []byte(item.ID))
+	rec.Item, rec.Sealed = &sealed, true
+	return rec, nil
+}
+
+// open 解密文件中的请求体，密文以nonce开头，条目ID作为附加数据
+func (o *Outbox) open(id string, body []byte) ([]byte, error) {
+	if o.aead == nil {
+		return nil, errors.New("条目已加密，需要配置 EncryptionKey")
+	}
+	if len(body) < o.aead.NonceSize() {
+		return nil, errors.New("密文长度不正确")
+	}
+	nonce, ciphertext := body[:o.aead.NonceSize()], body[o.aead.NonceSize():]
+	return o.aead.Open(nil, nonce, ciphertext, []byte(id))
+}
+
+// appendRecord 追加一条记录并落盘，调用方需持有锁
+func (o *Outbox) appendRecord(rec outboxRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := o.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return o.file.Sync()
+}
+
+// enqueue 把请求写入发件箱，sensitive 表示请求体包含密码。
+// onlyBehind 为true时只在还有未送达的条目时写入，否则返回nil，由调用方直接发送
+func (o *Outbox) enqueue(req *http.Request, body []byte, sensitive, onlyBehind bool) (*OutboxItem, error) {
+	if sensitive && o.aead == nil {
+		return nil, errOutboxSensitive
+	}
+	id, err := newIdempotencyKey()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	item := &OutboxItem{
+		ID:        id,
+		Method:    req.Method,
+		URL:       req.URL.String(),
+		Header:    make(map[string]string),
+		Body:      body,
+		Status:    OutboxPending,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	for _, name := range outboxHeaders {
+		if v := req.Header.Get(name); v != "" {
+			item.Header[name] = v
+		}
+	}
+	// 还没有发送过的请求由 do 生成幂等键，这里补上，重放时保持不变
+	if item.Method == http.MethodPost && item.Header[IdempotencyKeyHeader] == "" {
+		item.Header[IdempotencyKeyHeader] = id
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.file == nil {
+		return nil, fmt.Errorf("发件箱已关闭")
+	}
+	if onlyBehind && o.depthLocked() == 0 {
+		return nil, nil
+	}
+	rec, err := o.enqueueRecord(item)
+	if err != nil {
+		return nil, err
+	}
+	if err := o.appendRecord(rec); err != nil {
+		return nil, fmt.Errorf("写入发件箱失败: %v", err)
+	}
+	o.items = append(o.items, item)
+
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+	return item, nil
+}
+
+// run 后台按顺序重放未完成的条目，服务端不可达时指数退避
+func (o *Outbox) run() {
+	defer close(o.done)
+
+	backoff := o.config.RetryInterval
+	for {
+		if o.drain() {
+			backoff = o.config.RetryInterval
+		} else {
+			backoff *= 2
+			if backoff > o.config.MaxRetryInterval {
+				backoff = o.config.MaxRetryInterval
+			}
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-o.stop:
+			timer.Stop()
+			return
+		case <-o.wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// drain 依次发送未完成的条目，遇到需要稍后重试的错误时停止以保证顺序。
+// 返回 false 表示本轮因错误中断
+func (o *Outbox) drain() bool {
+	for {
+		item := o.nextPending()
+		if item == nil {
+			o.maybeCompact(true)
+			return true
+		}
+
+		status, err := o.send(item)
+
+		o.mu.Lock()
+		item.Attempts++
+		item.UpdatedAt = time.Now()
+		switch {
+		case err != nil:
+			item.LastError = err.Error()
+		case status >= 200 && status < 300:
+			item.Status = OutboxDelivered
+			item.LastError = ""
+		case status >= 400 && status < 500 && status != http.StatusRequestTimeout &&
+			status != http.StatusTooManyRequests:
+			// 客户端错误（包括409冲突）重试也不会成功，标记为失败后继续发送后续条目
+			item.Status = OutboxFailed
+			item.LastError = fmt.Sprintf("状态码: %d", status)
+		default:
+			item.LastError = fmt.Sprintf("状态码: %d", status)
+		}
+		if item.Status != OutboxPending && o.file != nil {
+			o.appendRecord(outboxRecord{Op: item.Status, ID: item.ID, Error: item.LastError, Time: item.UpdatedAt})
+		}
+		retry := item.Status == OutboxPending
+		if !retry {
+			o.finished++
+		}
+		o.mu.Unlock()
+
+		if retry {
+			return false
+		}
+		o.maybeCompact(false)
+	}
+}
+
+// nextPending 返回最早的未完成条目
+func (o *Outbox) nextPending() *OutboxItem {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, item := range o.items {
+		if item.Status == OutboxPending {
+			return item
+		}
+	}
+	return nil
+}
+
+// send 发送一个条目，返回响应状态码
+func (o *Outbox) send(item *OutboxItem) (int, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-o.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, item.Method, item.URL, bytes.NewReader(item.Body))
+	if err != nil {
+		return 0, err
+	}
+	for name, value := range item.Header {
+		req.Header.Set(name, value)
+	}
+	req.Header.Set("Authorization", "Bearer "+o.client.apiKey)
+
+	resp, err := o.client.do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode, nil
+}
+
+// Depth 返回尚未送达的条目数
+func (o *Outbox) Depth() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.depthLocked()
+}
+
+// depthLocked 返回尚未送达的条目数，调用方需持有锁
+func (o *Outbox) depthLocked() int {
+	depth := 0
+	for _, item := range o.items {
+		if item.Status == OutboxPending {
+			depth++
+		}
+	}
+	return depth
+}
+
+// Status 返回指定条目的状态
+func (o *Outbox) Status(id string) (OutboxItem, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, item := range o.items {
+		if item.ID == id {
+			return *item, true
+		}
+	}
+	return OutboxItem{}, false
+}
+
+// Items 返回所有条目的快照
+func (o *Outbox) Items() []OutboxItem {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	items := make([]OutboxItem, 0, len(o.items))
+	for _, item := range o.items {
+		items = append(items, *item)
+	}
+	return items
+}
+
+// Close 停止后台重放并关闭记录文件
+func (o *Outbox) Close() error {
+	select {
+	case <-o.stop:
+		return nil
+	default:
+		close(o.stop)
+	}
+	<-o.done
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	err := o.file.Close()
+	o.file = nil
+	return err
+}
+
+// queueOffline 服务端不可达时把请求写入发件箱，sensitive 表示请求体包含密码。
+// 未开启发件箱、错误不属于网络故障，或包含密码但未配置加密密钥时返回nil，由调用方按原逻辑处理
+func (c *HTTPClient) queueOffline(req *http.Request, body []byte, sensitive bool, cause error) error {
+	if c.outbox == nil || errors.Is(cause, context.Canceled) {
+		return nil
+	}
+	item, err := c.outbox.enqueue(req, body, sensitive, false)
+	if errors.Is(err, errOutboxSensitive) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("发送请求失败: %v，写入发件箱也失败: %v", cause, err)
+	}
+	return &QueuedError{ItemID: item.ID, Cause: cause}
+}
+
+// queueBehindBacklog 发件箱中还有未送达的请求时，把新请求直接写入发件箱排在后面，
+// 避免新请求先于之前离线的请求到达服务端。返回nil表示可以直接发送
+func (c *HTTPClient) queueBehindBacklog(req *http.Request, body []byte, sensitive bool) error {
+	if c.outbox == nil {
+		return nil
+	}
+	item, err := c.outbox.enqueue(req, body, sensitive, true)
+	if err != nil {
+		return fmt.Errorf("%v，写入发件箱失败: %v", ErrOutboxBacklog, err)
+	}
+	if item == nil {
+		return nil
+	}
+	return &QueuedError{ItemID: item.ID, Cause: ErrOutboxBacklog}
+}
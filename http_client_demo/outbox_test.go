@@ -0,0 +1,317 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"http_client_demo/api"
+)
+
+// flakyTransport 模拟网络中断
+type flakyTransport struct {
+	offline atomic.Bool
+	next    http.RoundTripper
+}
+
+func (t *flakyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.offline.Load() {
+		return nil, errors.New("网络不可达")
+	}
+	return t.next.RoundTrip(req)
+}
+
+// TestOutboxQueuesAndReplays 测试离线请求写入发件箱，重启后恢复，并在网络恢复后按原幂等键重放
+func TestOutboxQueuesAndReplays(t *testing.T) {
+	var mu sync.Mutex
+	var received []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		received = append(received, r.URL.Path+" "+r.Header.Get(IdempotencyKeyHeader))
+		mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"success":true,"data":{"id":9}}`))
+	}))
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "outbox.log")
+	config := OutboxConfig{RetryInterval: 10 * time.Millisecond, MaxRetryInterval: 20 * time.Millisecond}
+
+	transport := &flakyTransport{next: http.DefaultTransport}
+	transport.offline.Store(true)
+
+	client := NewHTTPClient(srv.URL, "your-api-key-here")
+	client.client.Transport = transport
+	if _, err := client.EnableOutbox(path, config); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := client.CreateUser(&User{Name: "赵六", Email: "zhaoliu@example.com"})
+	var queued *QueuedError
+	if !errors.As(err, &queued) {
+		t.Fatalf("期望返回 QueuedError，实际 %v", err)
+	}
+	if err := client.UploadFile("a.txt", []byte("data")); !errors.As(err, &queued) {
+		t.Fatalf("期望返回 QueuedError，实际 %v", err)
+	}
+	item, _ := client.outbox.Status(queued.ItemID)
+	key := item.Header[IdempotencyKeyHeader]
+	if key == "" {
+		t.Fatal("发件箱条目应保留原幂等键")
+	}
+	client.Close()
+
+	// 模拟进程重启
+	transport.offline.Store(false)
+	restarted := NewHTTPClient(srv.URL, "your-api-key-here")
+	restarted.client.Transport = transport
+	outbox, err := restarted.EnableOutbox(path, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restarted.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for outbox.Depth() > 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if depth := outbox.Depth(); depth != 0 {
+		t.Fatalf("期望发件箱清空，实际剩余 %d", depth)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received) != 2 || !strings.HasPrefix(received[0], api.V2.Path(api.PathUsers)+" ") ||
+		received[1] != api.V2.Path(api.PathUpload)+" "+key {
+		t.Errorf("重放顺序或幂等键不正确: %v", received)
+	}
+	if st, _ := outbox.Status(queued.ItemID); st.Status != OutboxDelivered {
+		t.Errorf("期望状态 %s，实际 %s", OutboxDelivered, st.Status)
+	}
+}
+
+// TestOutboxMarksClientErrorsFailed 测试客户端错误标记为失败且不阻塞后续条目
+func TestOutboxMarksClientErrorsFailed(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == api.V2.Path(api.PathUpload) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	transport := &flakyTransport{next: http.DefaultTransport}
+	transport.offline.Store(true)
+	client := NewHTTPClient(srv.URL, "your-api-key-here")
+	client.client.Transport = transport
+	outbox, err := client.EnableOutbox(filepath.Join(t.TempDir(), "outbox.log"), OutboxConfig{
+		RetryInterval: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	client.UploadFile("bad.txt", []byte("data"))
+	client.CreateUser(&User{Name: "赵六"})
+	transport.offline.Store(false)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for outbox.Depth() > 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	items := outbox.Items()
+	if len(items) != 2 || items[0].Status != OutboxFailed || items[1].Status != OutboxDelivered {
+		t.Errorf("条目状态不正确: %+v", items)
+	}
+}
+
+// TestOutboxConflictDoesNotBlock 测试409冲突标记为失败，不会阻塞后面的条目
+func TestOutboxConflictDoesNotBlock(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var user User
+		json.NewDecoder(r.Body).Decode(&user)
+		if user.Email == "zhangsan@example.com" {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	transport := &flakyTransport{next: http.DefaultTransport}
+	transport.offline.Store(true)
+	client := NewHTTPClient(srv.URL, "your-api-key-here")
+	client.client.Transport = transport
+	outbox, err := client.EnableOutbox(filepath.Join(t.TempDir(), "outbox.log"), OutboxConfig{
+		RetryInterval: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	client.CreateUser(&User{Name: "张三", Email: "zhangsan@example.com"})
+	client.CreateUser(&User{Name: "赵六", Email: "zhaoliu@example.com"})
+	transport.offline.Store(false)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for outbox.Depth() > 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	items := outbox.Items()
+	if len(items) != 2 || items[0].Status != OutboxFailed || items[1].Status != OutboxDelivered {
+		t.Errorf("条目状态不正确: %+v", items)
+	}
+}
+
+// TestOutboxProtectsPasswords 测试包含密码的请求只在配置密钥时加密写入，文件中没有明文
+func TestOutboxProtectsPasswords(t *testing.T) {
+	transport := &flakyTransport{next: http.DefaultTransport}
+	transport.offline.Store(true)
+	user := &User{Name: "赵六", Email: "zhaoliu@example.com", Password: "s3cret-pass"}
+
+	path := filepath.Join(t.TempDir(), "outbox.log")
+	client := NewHTTPClient("http://127.0.0.1:1", "your-api-key-here")
+	client.client.Transport = transport
+	if _, err := client.EnableOutbox(path, OutboxConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := client.CreateUser(user)
+	var queued *QueuedError
+	if err == nil || errors.As(err, &queued) {
+		t.Errorf("未配置密钥时包含密码的请求不应写入发件箱，实际 %v", err)
+	}
+	client.Close()
+
+	key := []byte("0123456789abcdef0123456789abcdef")
+	client = NewHTTPClient("http://127.0.0.1:1", "your-api-key-here")
+	client.client.Transport = transport
+	if _, err := client.EnableOutbox(path, OutboxConfig{EncryptionKey: key}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.CreateUser(user); !errors.As(err, &queued) {
+		t.Fatalf("配置密钥后期望写入发件箱，实际 %v", err)
+	}
+	client.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "s3cret-pass") {
+		t.Error("发件箱文件中不应出现明文密码")
+	}
+
+	restarted := NewHTTPClient("http://127.0.0.1:1", "your-api-key-here")
+	restarted.client.Transport = transport
+	outbox, err := restarted.EnableOutbox(path, OutboxConfig{EncryptionKey: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	item, _ := outbox.Status(queued.ItemID)
+	restarted.Close()
+	if !strings.Contains(string(item.Body), "s3cret-pass") {
+		t.Errorf("重启后期望解密出原请求体，实际 %s", item.Body)
+	}
+	if _, err := NewHTTPClient("http://127.0.0.1:1", "k").EnableOutbox(path, OutboxConfig{}); err == nil {
+		t.Error("没有密钥时期望无法恢复加密的条目")
+	}
+}
+
+// TestOutboxQueuesBehindBacklog 测试发件箱不为空时新请求排在后面，按调用顺序送达
+func TestOutboxQueuesBehindBacklog(t *testing.T) {
+	var mu sync.Mutex
+	var received []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		received = append(received, r.URL.Path)
+		mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	transport := &flakyTransport{next: http.DefaultTransport}
+	transport.offline.Store(true)
+	client := NewHTTPClient(srv.URL, "your-api-key-here")
+	client.client.Transport = transport
+	outbox, err := client.EnableOutbox(filepath.Join(t.TempDir(), "outbox.log"), OutboxConfig{
+		RetryInterval:    time.Hour,
+		MaxRetryInterval: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	client.UploadFile("a.txt", []byte("data"))
+	for outbox.Items()[0].Attempts == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	transport.offline.Store(false)
+
+	_, err = client.CreateUser(&User{Name: "赵六", Email: "zhaoliu@example.com"})
+	var queued *QueuedError
+	if !errors.As(err, &queued) || !errors.Is(err, ErrOutboxBacklog) {
+		t.Fatalf("发件箱不为空时期望排队，实际 %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for outbox.Depth() > 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{api.V2.Path(api.PathUpload), api.V2.Path(api.PathUsers)}
+	if strings.Join(received, ",") != strings.Join(want, ",") {
+		t.Errorf("期望按调用顺序送达 %v，实际 %v", want, received)
+	}
+}
+
+// TestOutboxCompactsWhileRunning 测试运行中送达后压缩记录文件，内存中保留最近完成的条目
+func TestOutboxCompactsWhileRunning(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	transport := &flakyTransport{next: http.DefaultTransport}
+	transport.offline.Store(true)
+	client := NewHTTPClient(srv.URL, "your-api-key-here")
+	client.client.Transport = transport
+	path := filepath.Join(t.TempDir(), "outbox.log")
+	outbox, err := client.EnableOutbox(path, OutboxConfig{RetryInterval: 10 * time.Millisecond, MaxRetryInterval: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	for i := 0; i < 3; i++ {
+		client.UploadFile("a.txt", []byte("data"))
+	}
+	transport.offline.Store(false)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		data, _ := os.ReadFile(path)
+		if outbox.Depth() == 0 && len(data) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("期望送达后压缩记录文件，剩余 %d 条未送达，文件内容 %s", outbox.Depth(), data)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if items := outbox.Items(); len(items) != 3 || items[2].Status != OutboxDelivered {
+		t.Errorf("期望内存中保留已送达的条目，实际 %+v", items)
+	}
+}
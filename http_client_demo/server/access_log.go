@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+// RequestIDHeader 请求ID头，客户端传入时沿用，否则由服务端生成
+const RequestIDHeader = "X-Request-ID"
+
+// 客户端传入的请求ID最大长度，超长或含非法字符时重新生成
+const maxRequestIDLength = 128
+
+// requestInfo 在中间件之间传递的请求信息，由路由和认证中间件逐步填写，
+// 请求结束时写入访问日志
+type requestInfo struct {
+	id    string
+	route string
+	keyID string
+	// userID 登录会话认证时的用户ID
+	userID int
+}
+
+// requestInfoContextKey 请求上下文中保存 requestInfo 的键
+type requestInfoContextKey struct{}
+
+// requestInfoFromContext 取出访问日志中间件保存的请求信息，不经过中间件时返回nil
+func requestInfoFromContext(ctx context.Context) *requestInfo {
+	info, _ := ctx.Value(requestInfoContextKey{}).(*requestInfo)
+	return info
+}
+
+// statusRecorder 记录响应状态码和字节数
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += n
+	return n, err
+}
+
+// Flush 支持流式响应
+func (rec *statusRecorder) Flush() {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	http.NewResponseController(rec.ResponseWriter).Flush()
+}
+
+// Unwrap 供 http.ResponseController 访问底层连接
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// validRequestID 只接受可打印ASCII字符组成的请求ID，防止日志注入
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID 生成随机请求ID
+func newRequestID() string {
+	id, err := randomHex(8)
+	if err != nil {
+		return "unknown"
+	}
+	return id
+}
+
+// withRoute 记录请求匹配到的路由模式
+func withRoute(pattern string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if info := requestInfoFromContext(r.Context()); info != nil {
+			info.route = pattern
+		}
+		next(w, r)
+	}
+}
+
+// accessLog 为每个请求分配或沿用请求ID，记录请求指标，并在请求结束后输出一行JSON访问日志。
+// 2xx响应的日志按 AccessLogSampleRate 采样，其余响应全部记录
+func (s *SimpleServer) accessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		info := &requestInfo{id: id}
+		rec := &statusRecorder{ResponseWriter: w}
+		func() {
+			s.metrics.inFlight.add(1)
+			defer s.metrics.inFlight.add(-1)
+			next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), requestInfoContextKey{}, info)))
+		}()
+
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		elapsed := time.Since(start)
+		s.metrics.observeRequest(r.Method, info.route, status, elapsed)
+		level := slog.LevelInfo
+		switch {
+		case status >= 500:
+			level = slog.LevelError
+		case status >= 400:
+			level = slog.LevelWarn
+		case status < 300 && !sampled(s.config.AccessLogSampleRate):
+			return
+		}
+
+		s.logger.LogAttrs(r.Context(), level, "access",
+			slog.String("request_id", id),
+			slog.String("method", r.Method),
+			slog.String("route", info.route),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", status),
+			slog.Int("bytes", rec.bytes),
+			slog.Float64("latency_ms", float64(elapsed.Microseconds())/1000),
+			slog.String("client", clientIP(r)),
+			slog.String("key_id", info.keyID),
+			slog.Int("user_id", info.userID),
+		)
+	})
+}
+
+// sampled 按比例决定是否记录，rate>=1 全部记录，rate<=0 全部不记录
+func sampled(rate float64) bool {
+	if rate >= 1 {
+		return true
+	}
+	return rate > 0 && rand.Float64() < rate
+}
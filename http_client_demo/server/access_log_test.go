@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newLoggedTestServer 创建把访问日志写到缓冲区的服务器
+func newLoggedTestServer(sampleRate float64) (*SimpleServer, *bytes.Buffer) {
+	var buf bytes.Buffer
+	config := DefaultServerConfig("0")
+	config.Logger = slog.New(slog.NewJSONHandler(&buf, nil))
+	config.AccessLogSampleRate = sampleRate
+	s := NewSimpleServerWithConfig(config, NewMemoryUserStore())
+	s.initTestData()
+	return s, &buf
+}
+
+// decodeLogLines 解析JSON格式的日志行
+func decodeLogLines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+	var lines []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("日志不是JSON: %q", line)
+		}
+		lines = append(lines, entry)
+	}
+	return lines
+}
+
+// TestAccessLogFields 测试访问日志包含路由、状态码、Key ID 等字段，并沿用客户端的请求ID
+func TestAccessLogFields(t *testing.T) {
+	s, buf := newLoggedTestServer(1)
+
+	req := httptest.NewRequest(http.MethodGet, "/v2/users/1", nil)
+	req.Header.Set("Authorization", "Bearer your-api-key-here")
+	req.Header.Set(RequestIDHeader, "req-123")
+	rec := httptest.NewRecorder()
+	s.httpServer.Handler.ServeHTTP(rec, req)
+
+	if got := rec.Header().Get(RequestIDHeader); got != "req-123" {
+		t.Errorf("期望回传请求ID req-123，实际 %q", got)
+	}
+
+	lines := decodeLogLines(t, buf)
+	if len(lines) != 1 {
+		t.Fatalf("期望 1 行日志，实际 %d", len(lines))
+	}
+	entry := lines[0]
+	expected := map[string]interface{}{
+		"request_id": "req-123",
+		"method":     "GET",
+		"route":      "GET /v2/users/{id}",
+		"status":     float64(200),
+		"client":     "192.0.2.1",
+		"bytes":      float64(rec.Body.Len()),
+	}
+	for field, value := range expected {
+		if entry[field] != value {
+			t.Errorf("字段 %s 期望 %v，实际 %v", field, value, entry[field])
+		}
+	}
+	if keyID, _ := entry["key_id"].(string); !strings.HasPrefix(keyID, "key_") {
+		t.Errorf("期望记录Key ID，实际 %v", entry["key_id"])
+	}
+}
+
+// TestAccessLogRequestIDInErrors 测试错误响应体中带有请求ID，非法的请求ID会被替换
+func TestAccessLogRequestIDInErrors(t *testing.T) {
+	s, _ := newLoggedTestServer(1)
+
+	req := httptest.NewRequest(http.MethodGet, "/v2/users/99", nil)
+	req.Header.Set("Authorization", "Bearer your-api-key-here")
+	req.Header.Set(RequestIDHeader, "bad id\n")
+	rec := httptest.NewRecorder()
+	s.httpServer.Handler.ServeHTTP(rec, req)
+
+	id := rec.Header().Get(RequestIDHeader)
+	if id == "" || id == "bad id\n" {
+		t.Fatalf("非法的请求ID应被替换，实际 %q", id)
+	}
+	var resp APIResponse
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if resp.RequestID != id {
+		t.Errorf("错误响应中期望请求ID %q，实际 %q", id, resp.RequestID)
+	}
+}
+
+// TestAccessLogSampling 测试2xx响应按比例采样，错误响应总是记录
+func TestAccessLogSampling(t *testing.T) {
+	s, buf := newLoggedTestServer(0)
+
+	for _, path := range []string{"/v2/users/1", "/v2/users/99", "/missing"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer your-api-key-here")
+		s.httpServer.Handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	lines := decodeLogLines(t, buf)
+	if len(lines) != 2 {
+		t.Fatalf("采样率为0时期望只记录 2 个错误响应，实际 %d", len(lines))
+	}
+	for _, entry := range lines {
+		if entry["level"] != "WARN" {
+			t.Errorf("4xx响应期望 WARN 级别，实际 %v", entry["level"])
+		}
+	}
+}
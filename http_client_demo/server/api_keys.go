@@ -0,0 +1,472 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scope API Key 的权限范围
+type Scope string
+
+const (
+	// ScopeUsersRead 查询用户
+	ScopeUsersRead Scope = "users:read"
+	// ScopeUsersWrite 创建、更新、删除用户
+	ScopeUsersWrite Scope = "users:write"
+	// ScopeUpload 上传文件
+	ScopeUpload Scope = "upload"
+	// ScopeAdmin 管理API Key和用户角色
+	ScopeAdmin Scope = "admin"
+)
+
+// knownScopes 所有合法的权限范围
+var knownScopes = []Scope{ScopeUsersRead, ScopeUsersWrite, ScopeUpload, ScopeAdmin}
+
+// API Key 明文前缀，便于在日志和配置中识别
+const apiKeyPrefix = "sk_"
+
+var (
+	// ErrAPIKeyInvalid 不存在的API Key
+	ErrAPIKeyInvalid = errors.New("无效的API Key")
+	// ErrAPIKeyExpired API Key已过期
+	ErrAPIKeyExpired = errors.New("API Key已过期")
+	// ErrAPIKeyRevoked API Key已吊销
+	ErrAPIKeyRevoked = errors.New("API Key已吊销")
+	// ErrAPIKeyNotFound 按ID找不到API Key
+	ErrAPIKeyNotFound = errors.New("API Key不存在")
+)
+
+// APIKey 一个API Key的元数据。服务端只保存明文的SHA-256哈希，明文只在创建时返回一次
+type APIKey struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Scopes    []Scope    `json:"scopes"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	// RotatedTo 轮换后的新Key ID，旧Key在重叠期结束前仍然可用
+	RotatedTo string `json:"rotated_to,omitempty"`
+
+	hash [sha256.Size]byte
+}
+
+// HasScope 判断Key是否拥有指定权限
+func (k *APIKey) HasScope(scope Scope) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// check 检查Key在指定时间是否可用
+func (k *APIKey) check(now time.Time) error {
+	if k.RevokedAt != nil {
+		return ErrAPIKeyRevoked
+	}
+	if k.ExpiresAt != nil && !now.Before(*k.ExpiresAt) {
+		return ErrAPIKeyExpired
+	}
+	return nil
+}
+
+// BootstrapAPIKey 服务器启动时导入的API Key
+type BootstrapAPIKey struct {
+	Name   string
+	Key    string // 明文，导入后只保留哈希
+	Scopes []Scope
+}
+
+// apiKeyStore 保存API Key，按明文哈希查找
+type apiKeyStore struct {
+	mu     sync.RWMutex
+	byID   map[string]*APIKey
+	byHash map[[sha256.Size]byte]*APIKey
+	now    func() time.Time
+}
+
+// newAPIKeyStore 创建新的API Key存储
+func newAPIKeyStore() *apiKeyStore {
+	return &apiKeyStore{
+		byID:   make(map[string]*APIKey),
+		byHash: make(map[[sha256.Size]byte]*APIKey),
+		now:    time.Now,
+	}
+}
+
+// validateScopes 检查权限范围是否合法
+func validateScopes(scopes []Scope) error {
+	if len(scopes) == 0 {
+		return fmt.Errorf("至少需要一个权限范围")
+	}
+	for _, scope := range scopes {
+		known := false
+		for _, s := range knownScopes {
+			if s == scope {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("未知的权限范围: %s", scope)
+		}
+	}
+	return nil
+}
+
+// randomHex 生成n字节的随机数并编码为十六进制
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// add 保存一个明文Key，调用方需持有写锁
+func (st *apiKeyStore) add(name, secret string, scopes []Scope, expiresAt *time.Time) (*APIKey, error) {
+	hash := sha256.Sum256([]byte(secret))
+	if _, exists := st.byHash[hash]; exists {
+		return nil, fmt.Errorf("API Key已存在")
+	}
+	id, err := randomHex(8)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &APIKey{
+		ID:        "key_" + id,
+		Name:      name,
+		Scopes:    append([]Scope(nil), scopes...),
+		CreatedAt: st.now(),
+		ExpiresAt: expiresAt,
+		hash:      hash,
+	}
+	st.byID[key.ID] = key
+	st.byHash[hash] = key
+	return key, nil
+}
+
+// importKey 导入已知明文的Key，例如演示用的固定Key
+func (st *apiKeyStore) importKey(bootstrap BootstrapAPIKey) (*APIKey, error) {
+	if err := validateScopes(bootstrap.Scopes); err != nil {
+		return nil, err
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	key, err := st.add(bootstrap.Name, bootstrap.Key, bootstrap.Scopes, nil)
+	if err != nil {
+		return nil, err
+	}
+	copied := *key
+	return &copied, nil
+}
+
+// create 生成新Key，ttl为0表示永不过期，返回元数据和明文
+func (st *apiKeyStore) create(name string, scopes []Scope, ttl time.Duration) (*APIKey, string, error) {
+	if err := validateScopes(scopes); err != nil {
+		return nil, "", err
+	}
+	random, err := randomHex(24)
+	if err != nil {
+		return nil, "", err
+	}
+	secret := apiKeyPrefix + random
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	var expiresAt *time.Time
+	if ttl > 0 {
+		t := st.now().Add(ttl)
+		expiresAt = &t
+	}
+	key, err := st.add(name, secret, scopes, expiresAt)
+	if err != nil {
+		return nil, "", err
+	}
+	copied := *key
+	return &copied, secret, nil
+}
+
+// authenticate 按明文查找Key并检查是否过期或吊销
+func (st *apiKeyStore) authenticate(secret string) (*APIKey, error) {
+	hash := sha256.Sum256([]byte(secret))
+
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	key, ok := st.byHash[hash]
+	if !ok {
+		return nil, ErrAPIKeyInvalid
+	}
+	if err := key.check(st.now()); err != nil {
+		return nil, err
+	}
+	copied := *key
+	return &copied, nil
+}
+
+// rotate 为指定Key生成一个权限相同的新Key，旧Key在overlap之后失效，
+// 客户端可以在重叠期内切换到新Key而不中断服务
+func (st *apiKeyStore) rotate(id string, overlap time.Duration) (*APIKey, string, error) {
+	random, err := randomHex(24)
+	if err != nil {
+		return nil, "", err
+	}
+	secret := apiKeyPrefix + random
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	old, ok := st.byID[id]
+	if !ok {
+		return nil, "", ErrAPIKeyNotFound
+	}
+	now := st.now()
+	if err := old.check(now); err != nil {
+		return nil, "", err
+	}
+
+	// 新Key保持与旧Key相同的有效时长
+	var expiresAt *time.Time
+	if old.ExpiresAt != nil {
+		t := now.Add(old.ExpiresAt.Sub(old.CreatedAt))
+		expiresAt = &t
+	}
+	key, err := st.add(old.Name, secret, old.Scopes, expiresAt)
+	if err != nil {
+		return nil, "", err
+	}
+
+	end := now.Add(overlap)
+	if old.ExpiresAt == nil || end.Before(*old.ExpiresAt) {
+		old.ExpiresAt = &end
+	}
+	old.RotatedTo = key.ID
+
+	copied := *key
+	return &copied, secret, nil
+}
+
+// revoke 立即吊销Key
+func (st *apiKeyStore) revoke(id string) (*APIKey, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	key, ok := st.byID[id]
+	if !ok {
+		return nil, ErrAPIKeyNotFound
+	}
+	if key.RevokedAt == nil {
+		now := st.now()
+		key.RevokedAt = &now
+	}
+	copied := *key
+	return &copied, nil
+}
+
+// list 按创建时间返回所有Key的元数据
+func (st *apiKeyStore) list() []*APIKey {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	keys := make([]*APIKey, 0, len(st.byID))
+	for _, key := range st.byID {
+		copied := *key
+		keys = append(keys, &copied)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].CreatedAt.Equal(keys[j].CreatedAt) {
+			return keys[i].ID < keys[j].ID
+		}
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+	return keys
+}
+
+// bearerToken 从 Authorization 头中取出 Bearer 令牌
+func bearerToken(header string) (string, bool) {
+	token, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok || token == "" {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// 轮换时旧Key默认的重叠期
+const defaultKeyRotationOverlap = 24 * time.Hour
+
+// createAPIKeyRequest 创建Key的请求体
+type createAPIKeyRequest struct {
+	Name   string  `json:"name"`
+	Scopes []Scope `json:"scopes"`
+	// TTL 有效时长，例如 "720h"，为空表示永不过期
+	TTL string `json:"ttl,omitempty"`
+}
+
+// rotateAPIKeyRequest 轮换Key的请求体
+type rotateAPIKeyRequest struct {
+	// Overlap 旧Key继续可用的时长，例如 "1h"，默认24小时
+	Overlap string `json:"overlap,omitempty"`
+}
+
+// apiKeyWithSecret 创建或轮换Key的响应，明文只返回这一次
+type apiKeyWithSecret struct {
+	*APIKey
+	Secret string `json:"secret"`
+}
+
+// parseOptionalDuration 解析可选的时长参数
+func parseOptionalDuration(value string, fallback time.Duration) (time.Duration, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("无效的时长: %s", value)
+	}
+	return d, nil
+}
+
+// 创建API Key
+func (s *SimpleServer) createAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req createAPIKeyRequest
+	if err := s.decodeJSON(w, r, &req); err != nil {
+		s.sendDecodeError(w, r, err)
+		return
+	}
+	if req.Name == "" {
+		s.sendResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "Key名称不能为空",
+		})
+		return
+	}
+	ttl, err := parseOptionalDuration(req.TTL, 0)
+	if err != nil {
+		s.sendResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	key, secret, err := s.apiKeys.create(req.Name, req.Scopes, ttl)
+	if err != nil {
+		s.sendResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+	s.audit(r, AuditKeyCreate, "api-keys/"+key.ID, nil, key)
+
+	s.sendResponse(w, http.StatusCreated, APIResponse{
+		Success: true,
+		Message: "创建API Key成功",
+		Data:    apiKeyWithSecret{APIKey: key, Secret: secret},
+	})
+}
+
+// 列出API Key，不包含明文
+func (s *SimpleServer) listAPIKeys(w http.ResponseWriter, r *http.Request) {
+	s.sendResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "获取API Key列表成功",
+		Data:    s.apiKeys.list(),
+	})
+}
+
+// 轮换API Key
+func (s *SimpleServer) rotateAPIKey(w http.ResponseWriter, r *http.Request) {
+	// 请求体可以为空，此时使用默认重叠期
+	var req rotateAPIKeyRequest
+	if err := s.decodeJSON(w, r, &req); err != nil && err != io.EOF {
+		s.sendDecodeError(w, r, err)
+		return
+	}
+	overlap, err := parseOptionalDuration(req.Overlap, defaultKeyRotationOverlap)
+	if err != nil {
+		s.sendResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	key, secret, err := s.apiKeys.rotate(r.PathValue("id"), overlap)
+	if err != nil {
+		s.sendAPIKeyError(w, err)
+		return
+	}
+	s.audit(r, AuditKeyRotate, "api-keys/"+r.PathValue("id"), nil, map[string]string{"rotated_to": key.ID})
+
+	s.sendResponse(w, http.StatusCreated, APIResponse{
+		Success: true,
+		Message: "轮换API Key成功",
+		Data:    apiKeyWithSecret{APIKey: key, Secret: secret},
+	})
+}
+
+// 吊销API Key
+func (s *SimpleServer) revokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	key, err := s.apiKeys.revoke(r.PathValue("id"))
+	if err != nil {
+		s.sendAPIKeyError(w, err)
+		return
+	}
+	s.audit(r, AuditKeyRevoke, "api-keys/"+key.ID, nil, map[string]interface{}{"revoked_at": key.RevokedAt})
+
+	s.sendResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "吊销API Key成功",
+		Data:    key,
+	})
+}
+
+// sendAPIKeyError 把Key管理错误转换为响应
+func (s *SimpleServer) sendAPIKeyError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, ErrAPIKeyNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrAPIKeyExpired), errors.Is(err, ErrAPIKeyRevoked):
+		status = http.StatusConflict
+	}
+	s.sendResponse(w, status, APIResponse{
+		Success: false,
+		Message: err.Error(),
+	})
+}
+
+// requireScope 校验 Authorization 头中的API Key或会话令牌并检查权限范围，
+// 通过后把调用方保存到请求上下文
+func (s *SimpleServer) requireScope(scope Scope, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok := s.authenticateRequest(w, r)
+		if !ok {
+			return
+		}
+		if !p.HasScope(scope) {
+			s.sendResponse(w, http.StatusForbidden, APIResponse{
+				Success: false,
+				Message: forbiddenReason(p, scope),
+			})
+			return
+		}
+
+		next(w, r.WithContext(context.WithValue(r.Context(), principalContextKey{}, p)))
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testAdminKey = "test-admin-key"
+
+// newAdminTestServer 创建带管理员Key的服务器
+func newAdminTestServer() *SimpleServer {
+	config := DefaultServerConfig("0")
+	config.APIKeys = append(config.APIKeys, BootstrapAPIKey{
+		Name:   "admin",
+		Key:    testAdminKey,
+		Scopes: []Scope{ScopeAdmin},
+	})
+	s := NewSimpleServerWithConfig(config, NewMemoryUserStore())
+	s.initTestData()
+	return s
+}
+
+// serveWithKey 使用指定Key发送请求
+func serveWithKey(s *SimpleServer, key, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+key)
+	rec := httptest.NewRecorder()
+	s.mux.ServeHTTP(rec, req)
+	return rec
+}
+
+// decodeCreatedKey 解析创建或轮换Key的响应
+func decodeCreatedKey(t *testing.T, rec *httptest.ResponseRecorder) (id, secret string) {
+	t.Helper()
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("期望状态码 %d，实际 %d: %s", http.StatusCreated, rec.Code, rec.Body.String())
+	}
+	var resp struct {
+		Data struct {
+			ID     string `json:"id"`
+			Secret string `json:"secret"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp.Data.ID, resp.Data.Secret
+}
+
+// TestAPIKeyStoredAsHash 测试存储中只保存哈希，列表接口不返回明文
+func TestAPIKeyStoredAsHash(t *testing.T) {
+	st := newAPIKeyStore()
+	key, secret, err := st.create("ci", []Scope{ScopeUsersRead}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(secret, apiKeyPrefix) {
+		t.Errorf("期望明文以 %s 开头，实际 %s", apiKeyPrefix, secret)
+	}
+
+	data, _ := json.Marshal(st.list())
+	if strings.Contains(string(data), secret) {
+		t.Error("Key列表中不应包含明文")
+	}
+	if got, err := st.authenticate(secret); err != nil || got.ID != key.ID {
+		t.Errorf("使用明文认证失败: %v", err)
+	}
+	if _, err := st.authenticate(secret + "x"); err != ErrAPIKeyInvalid {
+		t.Errorf("期望 ErrAPIKeyInvalid，实际 %v", err)
+	}
+}
+
+// TestAPIKeyExpiryAndRotation 测试过期以及轮换后的重叠期
+func TestAPIKeyExpiryAndRotation(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	st := newAPIKeyStore()
+	st.now = func() time.Time { return now }
+
+	old, oldSecret, _ := st.create("ci", []Scope{ScopeUsersRead}, 30*24*time.Hour)
+	rotated, newSecret, err := st.rotate(old.ID, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated.ExpiresAt == nil || !rotated.ExpiresAt.Equal(now.Add(30*24*time.Hour)) {
+		t.Errorf("新Key应保持相同的有效时长，实际 %v", rotated.ExpiresAt)
+	}
+
+	// 重叠期内新旧Key都可用
+	now = now.Add(30 * time.Minute)
+	if _, err := st.authenticate(oldSecret); err != nil {
+		t.Errorf("重叠期内旧Key应可用: %v", err)
+	}
+	if _, err := st.authenticate(newSecret); err != nil {
+		t.Errorf("新Key应可用: %v", err)
+	}
+
+	// 重叠期结束后旧Key过期
+	now = now.Add(time.Hour)
+	if _, err := st.authenticate(oldSecret); err != ErrAPIKeyExpired {
+		t.Errorf("期望 ErrAPIKeyExpired，实际 %v", err)
+	}
+	if _, _, err := st.rotate(old.ID, time.Hour); err != ErrAPIKeyExpired {
+		t.Errorf("过期的Key不能轮换，实际 %v", err)
+	}
+
+	// 新Key到期后也失效
+	now = now.Add(30 * 24 * time.Hour)
+	if _, err := st.authenticate(newSecret); err != ErrAPIKeyExpired {
+		t.Errorf("期望 ErrAPIKeyExpired，实际 %v", err)
+	}
+}
+
+// TestAPIKeyScopes 测试按路由检查权限范围
+func TestAPIKeyScopes(t *testing.T) {
+	s := newAdminTestServer()
+
+	rec := serveWithKey(s, testAdminKey, "POST", "/admin/keys", `{"name":"只读","scopes":["users:read"]}`)
+	_, readOnly := decodeCreatedKey(t, rec)
+
+	if rec := serveWithKey(s, readOnly, "GET", "/v2/users/1", ""); rec.Code != http.StatusOK {
+		t.Errorf("只读Key查询用户，期望状态码 200，实际 %d", rec.Code)
+	}
+	if rec := serveWithKey(s, readOnly, "POST", "/v2/users", `{"name":"赵六","email":"zhaoliu@example.com"}`); rec.Code != http.StatusForbidden {
+		t.Errorf("只读Key创建用户，期望状态码 403，实际 %d", rec.Code)
+	}
+	if rec := serveWithKey(s, readOnly, "POST", "/v2/upload", "data"); rec.Code != http.StatusForbidden {
+		t.Errorf("只读Key上传文件，期望状态码 403，实际 %d", rec.Code)
+	}
+	if rec := serveWithKey(s, "your-api-key-here", "GET", "/admin/keys", ""); rec.Code != http.StatusForbidden {
+		t.Errorf("普通Key访问管理接口，期望状态码 403，实际 %d", rec.Code)
+	}
+	if rec := serveWithKey(s, testAdminKey, "POST", "/admin/keys", `{"name":"x","scopes":["root"]}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("未知权限范围，期望状态码 400，实际 %d", rec.Code)
+	}
+}
+
+// TestAPIKeyAdminRotateAndRevoke 测试通过管理接口轮换和吊销Key
+func TestAPIKeyAdminRotateAndRevoke(t *testing.T) {
+	s := newAdminTestServer()
+
+	id, oldSecret := decodeCreatedKey(t, serveWithKey(s, testAdminKey, "POST", "/admin/keys",
+		`{"name":"ci","scopes":["users:read"],"ttl":"720h"}`))
+	newID, newSecret := decodeCreatedKey(t, serveWithKey(s, testAdminKey, "POST", "/admin/keys/"+id+"/rotate",
+		`{"overlap":"1h"}`))
+	if newID == id || newSecret == oldSecret {
+		t.Fatal("轮换应生成新的Key")
+	}
+
+	for _, secret := range []string{oldSecret, newSecret} {
+		if rec := serveWithKey(s, secret, "GET", "/v2/users/1", ""); rec.Code != http.StatusOK {
+			t.Errorf("重叠期内期望状态码 200，实际 %d", rec.Code)
+		}
+	}
+
+	if rec := serveWithKey(s, testAdminKey, "DELETE", "/admin/keys/"+newID, ""); rec.Code != http.StatusOK {
+		t.Fatalf("吊销Key失败，状态码 %d", rec.Code)
+	}
+	rec := serveWithKey(s, newSecret, "GET", "/v2/users/1", "")
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("吊销后期望状态码 401，实际 %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), ErrAPIKeyRevoked.Error()) {
+		t.Errorf("响应应说明Key已吊销: %s", rec.Body.String())
+	}
+
+	if rec := serveWithKey(s, testAdminKey, "DELETE", "/admin/keys/key_missing", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("吊销不存在的Key，期望状态码 404，实际 %d", rec.Code)
+	}
+
+	var list struct {
+		Data []APIKey `json:"data"`
+	}
+	json.Unmarshal(serveWithKey(s, testAdminKey, "GET", "/admin/keys", "").Body.Bytes(), &list)
+	if len(list.Data) != 4 {
+		t.Errorf("期望 4 个Key，实际 %d", len(list.Data))
+	}
+}
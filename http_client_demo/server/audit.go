@@ -0,0 +1,528 @@
+package main
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuditAction 审计记录的操作类型
+type AuditAction string
+
+const (
+	AuditUserCreate       AuditAction = "user.create"
+	AuditUserUpdate       AuditAction = "user.update"
+	AuditUserDelete       AuditAction = "user.delete"
+	AuditUserRole         AuditAction = "user.role"
+	AuditLogin            AuditAction = "login"
+	AuditLoginFailed      AuditAction = "login.failed"
+	AuditLogout           AuditAction = "logout"
+	AuditFileUpload       AuditAction = "file.upload"
+	AuditFileDelete       AuditAction = "file.delete"
+	AuditKeyCreate        AuditAction = "key.create"
+	AuditKeyRotate        AuditAction = "key.rotate"
+	AuditKeyRevoke        AuditAction = "key.revoke"
+	AuditWebhookCreate    AuditAction = "webhook.create"
+	AuditWebhookDelete    AuditAction = "webhook.delete"
+	AuditWebhookRedeliver AuditAction = "webhook.redeliver"
+)
+
+// GET /audit 默认和最多返回的记录数
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// DefaultMemoryAuditEntries 内存审计日志默认保留的记录数
+const DefaultMemoryAuditEntries = 10000
+
+// auditRedacted 密码等敏感字段在变更记录中的占位值，只记录是否修改
+const auditRedacted = "[REDACTED]"
+
+// AuditChange 一个字段修改前后的值，创建时 Before 为空，删除时 After 为空
+type AuditChange struct {
+	Field  string      `json:"field"`
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+}
+
+// AuditEntry 一条审计记录
+type AuditEntry struct {
+	// Seq 从1开始连续递增的序号
+	Seq  int64     `json:"seq"`
+	Time time.Time `json:"time"`
+	// Actor 操作者："key:<Key ID>"、"user:<用户ID>" 或 "anonymous"
+	Actor string `json:"actor"`
+	// Session 登录会话认证时的会话ID
+	Session   string      `json:"session,omitempty"`
+	RequestID string      `json:"request_id,omitempty"`
+	Action    AuditAction `json:"action"`
+	// Resource 被操作的资源，例如 "users/5"、"files/<ID>"、"api-keys/<ID>"
+	Resource string        `json:"resource"`
+	Changes  []AuditChange `json:"changes,omitempty"`
+	// PrevHash 前一条记录的哈希，第一条为空
+	PrevHash string `json:"prev_hash"`
+	// Hash 本条记录（不含 Hash 字段）的哈希，保存在日志行中，不参与自身的哈希计算
+	Hash string `json:"hash,omitempty"`
+}
+
+// auditRecord 日志文件中的一行。Entry 保留写入时的原始字节，校验时对原始字节计算哈希
+type auditRecord struct {
+	Hash  string          `json:"hash"`
+	Entry json.RawMessage `json:"entry"`
+}
+
+// AuditFilter 查询审计记录的条件，零值字段不参与过滤
+type AuditFilter struct {
+	Actor string
+	// Resource 资源名，以 "/" 结尾时按前缀匹配："users/" 匹配所有用户，"users/5" 只匹配该用户
+	Resource string
+	Action   AuditAction
+	Since    time.Time
+	Until    time.Time
+	// After 只返回序号大于该值的记录，用于分页
+	After int64
+	Limit int
+}
+
+// match 判断记录是否满足条件
+func (f AuditFilter) match(entry *AuditEntry) bool {
+	if f.Actor != "" && entry.Actor != f.Actor {
+		return false
+	}
+	if f.Resource != "" && entry.Resource != f.Resource &&
+		!(strings.HasSuffix(f.Resource, "/") && strings.HasPrefix(entry.Resource, f.Resource)) {
+		return false
+	}
+	if f.Action != "" && entry.Action != f.Action {
+		return false
+	}
+	if !f.Since.IsZero() && entry.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !entry.Time.Before(f.Until) {
+		return false
+	}
+	return entry.Seq > f.After
+}
+
+// AuditLog 只追加、带哈希链的审计日志。每条记录包含前一条记录的哈希，
+// 修改、删除或调换中间任何一条记录，之后的校验都会失败。
+// 配置了密钥时哈希为 HMAC-SHA256，不知道密钥的人无法重新计算整条链来掩盖修改；
+// 没有密钥时为 SHA-256，只能发现意外损坏和不重新计算哈希的修改。
+// 文件日志只在打开时校验一次，查询时不持有锁，只读取已完整写入的部分
+type AuditLog struct {
+	mu   sync.Mutex
+	path string
+	key  []byte
+	file *os.File // 为nil时只保存在内存中
+	size int64    // 文件中已完整写入的字节数
+	// entries 内存日志最近的记录，写满 capacity 条后从 next 处覆盖最早的记录
+	entries  []AuditEntry
+	next     int
+	capacity int
+	seq      int64
+	lastHash string
+}
+
+// NewMemoryAuditLog 创建只保存在内存中的审计日志，最多保留最近 capacity 条记录，
+// 为0时使用 DefaultMemoryAuditEntries。重启后丢失
+func NewMemoryAuditLog(capacity int) *AuditLog {
+	if capacity <= 0 {
+		capacity = DefaultMemoryAuditEntries
+	}
+	return &AuditLog{capacity: capacity}
+}
+
+// OpenAuditLog 打开或创建审计日志文件，校验已有记录的哈希链后在末尾继续追加。
+// 最后一行不完整时说明上次写入时发生了崩溃，截断后继续使用；
+// 中间的记录校验失败时返回错误，不会在被篡改的日志后面继续写入。
+// key 为计算哈希链的HMAC密钥，为空时使用不带密钥的SHA-256
+func OpenAuditLog(path string, key []byte) (*AuditLog, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("创建审计日志目录失败: %v", err)
+	}
+	l := &AuditLog{path: path, key: key}
+	if err := l.recover(); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("创建审计日志文件失败: %v", err)
+	}
+	l.file = f
+	return l, nil
+}
+
+// Close 关闭日志文件
+func (l *AuditLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// Append 填写序号、时间和哈希后追加一条记录，返回写入的记录
+func (l *AuditLog) Append(entry AuditEntry) (AuditEntry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry.Seq = l.seq + 1
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+	entry.PrevHash = l.lastHash
+	entry.Hash = ""
+	payload, err := json.Marshal(entry)
+	if err != nil {
+		return AuditEntry{}, fmt.Errorf("序列化审计记录失败: %v", err)
+	}
+	entry.Hash = auditHash(l.key, payload)
+
+	if l.path != "" {
+		if l.file == nil {
+			return AuditEntry{}, fmt.Errorf("审计日志已关闭")
+		}
+		line, err := json.Marshal(auditRecord{Hash: entry.Hash, Entry: payload})
+		if err != nil {
+			return AuditEntry{}, fmt.Errorf("序列化审计记录失败: %v", err)
+		}
+		line = append(line, '\n')
+		if _, err := l.file.Write(line); err != nil {
+			l.discardPartial()
+			return AuditEntry{}, fmt.Errorf("写入审计日志失败: %v", err)
+		}
+		if err := l.file.Sync(); err != nil {
+			l.discardPartial()
+			return AuditEntry{}, fmt.Errorf("同步审计日志失败: %v", err)
+		}
+		l.size += int64(len(line))
+	} else if len(l.entries) < l.capacity {
+		l.entries = append(l.entries, entry)
+	} else {
+		l.entries[l.next] = entry
+		l.next = (l.next + 1) % l.capacity
+	}
+
+	l.seq = entry.Seq
+	l.lastHash = entry.Hash
+	return entry, nil
+}
+
+// discardPartial 写入失败后截断到上一条完整记录，避免后续记录接在不完整的行后面。调用方需持有锁
+func (l *AuditLog) discardPartial() {
+	if err := l.file.Truncate(l.size); err != nil {
+		log.Printf("截断审计日志失败: %v", err)
+	}
+}
+
+// recover 从头校验哈希链，恢复最后的序号、哈希和已写入的字节数，只在打开时调用
+func (l *AuditLog) recover() error {
+	f, err := os.Open(l.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("读取审计日志失败: %v", err)
+	}
+	defer f.Close()
+
+	torn := false
+	err = readAuditLines(f, func(n int, line []byte) (bool, error) {
+		if line[len(line)-1] != '\n' {
+			torn = true
+			return false, nil
+		}
+		var record auditRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			return false, fmt.Errorf("审计日志第 %d 条格式错误: %v", n, err)
+		}
+		var entry AuditEntry
+		if auditHash(l.key, record.Entry) != record.Hash || json.Unmarshal(record.Entry, &entry) != nil {
+			return false, fmt.Errorf("审计日志第 %d 条校验失败，记录可能被篡改", n)
+		}
+		if entry.Seq != l.seq+1 || entry.PrevHash != l.lastHash {
+			return false, fmt.Errorf("审计日志第 %d 条校验失败，哈希链断开", n)
+		}
+		l.seq, l.lastHash = entry.Seq, record.Hash
+		l.size += int64(len(line))
+		return true, nil
+	})
+	if err != nil || !torn {
+		return err
+	}
+	log.Printf("审计日志末尾的记录不完整，截断到偏移 %d", l.size)
+	if err := os.Truncate(l.path, l.size); err != nil {
+		return fmt.Errorf("截断审计日志失败: %v", err)
+	}
+	return nil
+}
+
+// Query 按时间顺序返回满足条件的记录，最多 Limit 条。
+// 只在取得当前已写入的范围时持有锁，读取和过滤不阻塞写入
+func (l *AuditLog) Query(filter AuditFilter) ([]AuditEntry, error) {
+	l.mu.Lock()
+	path, size := l.path, l.size
+	var snapshot []AuditEntry
+	if path == "" {
+		snapshot = append(append(snapshot, l.entries[l.next:]...), l.entries[:l.next]...)
+	}
+	l.mu.Unlock()
+
+	entries := []AuditEntry{}
+	add := func(entry *AuditEntry) bool {
+		if filter.match(entry) {
+			entries = append(entries, *entry)
+		}
+		return filter.Limit <= 0 || len(entries) < filter.Limit
+	}
+
+	if path == "" {
+		for i := range snapshot {
+			if !add(&snapshot[i]) {
+				break
+			}
+		}
+		return entries, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取审计日志失败: %v", err)
+	}
+	defer f.Close()
+	err = readAuditLines(io.LimitReader(f, size), func(n int, line []byte) (bool, error) {
+		var record auditRecord
+		var entry AuditEntry
+		if json.Unmarshal(line, &record) != nil || json.Unmarshal(record.Entry, &entry) != nil {
+			return false, fmt.Errorf("审计日志第 %d 条格式错误", n)
+		}
+		entry.Hash = record.Hash
+		return add(&entry), nil
+	})
+	return entries, err
+}
+
+// readAuditLines 依次读取每一行交给 fn，fn 返回false时停止
+func readAuditLines(r io.Reader, fn func(n int, line []byte) (bool, error)) error {
+	reader := bufio.NewReader(r)
+	for n := 1; ; n++ {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			if ok, ferr := fn(n, line); ferr != nil || !ok {
+				return ferr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("读取审计日志失败: %v", err)
+		}
+	}
+}
+
+// auditHash 计算记录原始字节的哈希，记录中包含前一条的哈希，因此构成哈希链。
+// key 不为空时计算 HMAC-SHA256，否则计算 SHA-256
+func auditHash(key, payload []byte) string {
+	if len(key) == 0 {
+		sum := sha256.Sum256(payload)
+		return hex.EncodeToString(sum[:])
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// auditChanges 比较修改前后的对象，返回有变化的字段。
+// 对象按JSON字段比较；用户的密码哈希不出现在JSON中，单独比较并只记录是否修改
+func auditChanges(before, after interface{}) []AuditChange {
+	b, a := auditSnapshot(before), auditSnapshot(after)
+	fields := make([]string, 0, len(b)+len(a))
+	for field := range b {
+		fields = append(fields, field)
+	}
+	for field := range a {
+		if _, ok := b[field]; !ok {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+
+	var changes []AuditChange
+	for _, field := range fields {
+		if !reflect.DeepEqual(b[field], a[field]) {
+			changes = append(changes, AuditChange{Field: field, Before: b[field], After: a[field]})
+		}
+	}
+
+	beforeUser, _ := before.(*User)
+	afterUser, _ := after.(*User)
+	if passwordHashOf(beforeUser) != passwordHashOf(afterUser) {
+		changes = append(changes, AuditChange{
+			Field:  "password",
+			Before: redactedPassword(beforeUser),
+			After:  redactedPassword(afterUser),
+		})
+	}
+	return changes
+}
+
+// auditSnapshot 把对象转换为字段到值的映射，nil 返回空映射
+func auditSnapshot(v interface{}) map[string]interface{} {
+	if v == nil {
+		return nil
+	}
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Pointer && rv.IsNil() {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var m map[string]interface{}
+	if json.Unmarshal(data, &m) != nil {
+		return nil
+	}
+	return m
+}
+
+// passwordHashOf 返回用户的密码哈希，用户为nil时返回空
+func passwordHashOf(user *User) string {
+	if user == nil {
+		return ""
+	}
+	return user.PasswordHash
+}
+
+// redactedPassword 设置了密码时返回占位值，否则返回nil
+func redactedPassword(user *User) interface{} {
+	if passwordHashOf(user) == "" {
+		return nil
+	}
+	return auditRedacted
+}
+
+// audit 记录一次变更，操作者和请求ID从请求中取得。
+// 写入失败不影响已完成的操作，只记录日志
+func (s *SimpleServer) audit(r *http.Request, action AuditAction, resource string, before, after interface{}) {
+	s.appendAudit(s.auditEntry(r, action, resource, before, after))
+}
+
+// auditEntry 生成一条审计记录，操作者取自认证中间件保存的调用方，未认证时为 anonymous
+func (s *SimpleServer) auditEntry(r *http.Request, action AuditAction, resource string, before, after interface{}) AuditEntry {
+	entry := AuditEntry{
+		Actor:    "anonymous",
+		Action:   action,
+		Resource: resource,
+		Changes:  auditChanges(before, after),
+	}
+	if info := requestInfoFromContext(r.Context()); info != nil {
+		entry.RequestID = info.id
+	}
+	if p := principalFromContext(r.Context()); p != nil {
+		switch {
+		case p.Session != nil:
+			entry.Actor = "user:" + strconv.Itoa(p.Session.UserID)
+			entry.Session = p.Session.ID
+		case p.Key != nil:
+			entry.Actor = "key:" + p.Key.ID
+		}
+	}
+	return entry
+}
+
+// appendAudit 追加一条已填写操作者的记录
+func (s *SimpleServer) appendAudit(entry AuditEntry) {
+	if _, err := s.auditLog.Append(entry); err != nil {
+		log.Printf("写入审计日志失败（%s %s）: %v", entry.Action, entry.Resource, err)
+	}
+}
+
+// userResource 用户在审计记录中的资源名
+func userResource(id int) string {
+	return "users/" + strconv.Itoa(id)
+}
+
+// parseAuditTime 解析RFC 3339格式的查询参数，为空时返回零值
+func parseAuditTime(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("应为RFC 3339时间: %s", v)
+	}
+	return t, nil
+}
+
+// listAudit 查询审计记录，可按操作者、资源、操作类型和时间范围过滤
+func (s *SimpleServer) listAudit(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := AuditFilter{
+		Actor:    query.Get("actor"),
+		Resource: query.Get("resource"),
+		Action:   AuditAction(query.Get("action")),
+		Limit:    defaultAuditLimit,
+	}
+	badRequest := func(message string) {
+		s.sendResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: message,
+		})
+	}
+	var err error
+	if filter.Since, err = parseAuditTime(query.Get("since")); err != nil {
+		badRequest(fmt.Sprintf("无效的since: %v", err))
+		return
+	}
+	if filter.Until, err = parseAuditTime(query.Get("until")); err != nil {
+		badRequest(fmt.Sprintf("无效的until: %v", err))
+		return
+	}
+	if v := query.Get("after"); v != "" {
+		if filter.After, err = strconv.ParseInt(v, 10, 64); err != nil || filter.After < 0 {
+			badRequest("无效的after: " + v)
+			return
+		}
+	}
+	if v := query.Get("limit"); v != "" {
+		if filter.Limit, err = strconv.Atoi(v); err != nil || filter.Limit < 1 || filter.Limit > maxAuditLimit {
+			badRequest(fmt.Sprintf("limit 应为1到%d之间的整数", maxAuditLimit))
+			return
+		}
+	}
+
+	entries, err := s.auditLog.Query(filter)
+	if err != nil {
+		log.Printf("查询审计日志失败: %v", err)
+		s.sendResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+	s.sendResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "获取审计记录成功",
+		Data:    entries,
+	})
+}
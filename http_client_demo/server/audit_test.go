@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestAuditLogChain 测试重新打开后继续哈希链，最后一行不完整时截断，以及篡改、删除记录后无法打开
+func TestAuditLogChain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := OpenAuditLog(path, []byte("audit-key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var last AuditEntry
+	for _, resource := range []string{"users/1", "users/2", "users/3"} {
+		if last, err = l.Append(AuditEntry{Actor: "key:k1", Action: AuditUserCreate, Resource: resource}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l.Close()
+
+	l, err = OpenAuditLog(path, []byte("audit-key"))
+	if err != nil {
+		t.Fatalf("重新打开审计日志失败: %v", err)
+	}
+	entry, err := l.Append(AuditEntry{Actor: "key:k1", Action: AuditUserDelete, Resource: "users/1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Seq != 4 || entry.PrevHash != last.Hash {
+		t.Errorf("期望序号 4 且接在第3条之后，实际 %d %s", entry.Seq, entry.PrevHash)
+	}
+	if entries, err := l.Query(AuditFilter{After: 2, Limit: 1}); err != nil || len(entries) != 1 || entries[0].Hash != last.Hash {
+		t.Errorf("期望查询到第3条记录，实际 %+v %v", entries, err)
+	}
+	l.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(strings.TrimSuffix(string(data), "\n"), "\n")
+	// 最后一行写到一半时崩溃：截断后继续追加
+	if err := os.WriteFile(path, append(data, data[:len(lines[0])/2]...), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	l, err = OpenAuditLog(path, []byte("audit-key"))
+	if err != nil {
+		t.Fatalf("最后一行不完整时期望截断后打开，实际 %v", err)
+	}
+	if entry, err := l.Append(AuditEntry{Actor: "key:k1", Action: AuditUserDelete, Resource: "users/2"}); err != nil || entry.Seq != 5 {
+		t.Errorf("截断后期望继续写入序号 5，实际 %d %v", entry.Seq, err)
+	}
+	l.Close()
+	if _, err := OpenAuditLog(path, []byte("audit-key")); err != nil {
+		t.Errorf("截断后追加的日志期望校验通过，实际 %v", err)
+	}
+
+	if _, err := OpenAuditLog(path, []byte("other-key")); err == nil {
+		t.Error("使用其他密钥期望打开失败")
+	}
+
+	tampered := map[string]string{
+		"修改记录": strings.Replace(string(data), "users/2", "users/9", 1),
+		"删除记录": lines[0] + strings.Join(lines[2:], ""),
+		"调换记录": lines[1] + lines[0] + strings.Join(lines[2:], ""),
+	}
+	for name, content := range tampered {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := OpenAuditLog(path, []byte("audit-key")); err == nil {
+			t.Errorf("%s后期望打开失败", name)
+		}
+	}
+}
+
+// TestMemoryAuditLogCapacity 测试内存日志写满后丢弃最早的记录，序号继续递增
+func TestMemoryAuditLogCapacity(t *testing.T) {
+	l := NewMemoryAuditLog(3)
+	for i := 1; i <= 5; i++ {
+		if _, err := l.Append(AuditEntry{Actor: "key:k1", Action: AuditUserCreate, Resource: userResource(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := l.Query(AuditFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	seqs := make([]int64, len(entries))
+	for i, entry := range entries {
+		seqs[i] = entry.Seq
+	}
+	if !slices.Equal(seqs, []int64{3, 4, 5}) {
+		t.Errorf("期望保留序号 3、4、5，实际 %v", seqs)
+	}
+}
+
+// TestAuditChanges 测试只记录有变化的字段，密码只记录是否修改
+func TestAuditChanges(t *testing.T) {
+	before := &User{ID: 1, Name: "张三", Email: "zhangsan@example.com", PasswordHash: "old", Version: 1}
+	after := &User{ID: 1, Name: "张三丰", Email: "zhangsan@example.com", PasswordHash: "new", Version: 2}
+
+	got := map[string]AuditChange{}
+	for _, change := range auditChanges(before, after) {
+		got[change.Field] = change
+	}
+	if len(got) != 3 {
+		t.Errorf("期望 name、version、password 3个字段有变化，实际 %+v", got)
+	}
+	if got["name"].Before != "张三" || got["name"].After != "张三丰" {
+		t.Errorf("name 变化不正确: %+v", got["name"])
+	}
+	if got["password"].Before != auditRedacted || got["password"].After != auditRedacted {
+		t.Errorf("密码期望只记录占位值，实际 %+v", got["password"])
+	}
+
+	changes := auditChanges((*User)(nil), after)
+	for _, change := range changes {
+		if change.Before != nil {
+			t.Errorf("创建时 before 期望为空，实际 %+v", change)
+		}
+	}
+}
+
+// TestAuditEndpoint 测试变更操作写入审计日志，并按操作者、资源和时间过滤
+func TestAuditEndpoint(t *testing.T) {
+	s := newRoleTestServer(t)
+	admin := sessionFor(t, s, 1)
+	start := time.Now()
+
+	do := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("If-Match", "*")
+		rec := httptest.NewRecorder()
+		s.mux.ServeHTTP(rec, req)
+		return rec
+	}
+	query := func(params url.Values) []AuditEntry {
+		t.Helper()
+		rec := do(http.MethodGet, "/audit?"+params.Encode(), admin, "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("查询审计日志期望 200，实际 %d %s", rec.Code, rec.Body.String())
+		}
+		var resp struct {
+			Data []AuditEntry `json:"data"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return resp.Data
+	}
+
+	do(http.MethodPost, "/v2/users", "your-api-key-here", `{"name":"新用户","email":"new@example.com","password":"secret123"}`)
+	do(http.MethodPut, "/v2/users/4", "your-api-key-here", `{"name":"改名","email":"new@example.com"}`)
+	do(http.MethodPut, "/admin/users/4/role", admin, `{"role":"editor"}`)
+	do(http.MethodDelete, "/v2/users/4", admin, "")
+	// 只读请求和失败的请求不记录
+	do(http.MethodGet, "/v2/users/4", "your-api-key-here", "")
+	do(http.MethodPut, "/admin/users/99/role", admin, `{"role":"editor"}`)
+
+	entries := query(url.Values{"resource": {"users/4"}})
+	actions := make([]AuditAction, 0, len(entries))
+	for _, entry := range entries {
+		actions = append(actions, entry.Action)
+	}
+	want := []AuditAction{AuditUserCreate, AuditUserUpdate, AuditUserRole, AuditUserDelete}
+	if !slices.Equal(actions, want) {
+		t.Fatalf("期望操作 %v，实际 %v", want, actions)
+	}
+	if !strings.HasPrefix(entries[0].Actor, "key:") || entries[2].Actor != "user:1" || entries[2].Session == "" {
+		t.Errorf("操作者不正确: %s %s %s", entries[0].Actor, entries[2].Actor, entries[2].Session)
+	}
+	if len(entries[1].Changes) != 2 {
+		t.Errorf("更新期望记录 name 和 version 的变化，实际 %+v", entries[1].Changes)
+	}
+	if role := entries[2].Changes[0]; role.Field != "role" || role.Before != "viewer" || role.After != "editor" {
+		t.Errorf("修改角色的变化不正确: %+v", entries[2].Changes)
+	}
+	for i := 1; i < len(entries); i++ {
+		if entries[i].PrevHash != entries[i-1].Hash {
+			t.Errorf("第 %d 条记录没有接在前一条之后", entries[i].Seq)
+		}
+	}
+
+	if got := query(url.Values{"actor": {"user:1"}}); len(got) != 2 {
+		t.Errorf("按操作者过滤期望 2 条，实际 %d", len(got))
+	}
+	if got := query(url.Values{"since": {start.Add(-time.Second).Format(time.RFC3339)}, "limit": {"2"}}); len(got) != 2 || got[0].Seq != 1 {
+		t.Errorf("期望返回最早的 2 条，实际 %+v", got)
+	}
+	if got := query(url.Values{"since": {start.Add(time.Hour).Format(time.RFC3339)}}); len(got) != 0 {
+		t.Errorf("起始时间之后没有记录，实际 %d 条", len(got))
+	}
+	if got := query(url.Values{"after": {"3"}}); len(got) != 1 || got[0].Seq != 4 {
+		t.Errorf("期望只返回序号 4，实际 %+v", got)
+	}
+
+	for _, bad := range []string{"since=yesterday", "limit=0", "after=-1"} {
+		if rec := do(http.MethodGet, "/audit?"+bad, admin, ""); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s 期望 400，实际 %d", bad, rec.Code)
+		}
+	}
+	if rec := do(http.MethodGet, "/audit", "your-api-key-here", ""); rec.Code != http.StatusForbidden {
+		t.Errorf("没有管理权限期望 403，实际 %d", rec.Code)
+	}
+}
+
+// TestAuditLogin 测试登录成功、失败和注销的审计记录
+func TestAuditLogin(t *testing.T) {
+	s := newRoleTestServer(t)
+	user := &User{Name: "登录用户", Email: "login@example.com", Password: "password123"}
+	if err := s.hashUserPassword(user); err != nil {
+		t.Fatal(err)
+	}
+	created, err := s.store.Create(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	login := func(password string) *httptest.ResponseRecorder {
+		form := url.Values{"username": {"LOGIN@example.com"}, "password": {password}}
+		req := httptest.NewRequest(http.MethodPost, "/v2/login", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		s.mux.ServeHTTP(rec, req)
+		return rec
+	}
+	login("wrong")
+	rec := login("password123")
+	var resp struct {
+		Data string `json:"data"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	req := httptest.NewRequest(http.MethodPost, "/v2/logout", nil)
+	req.Header.Set("Authorization", "Bearer "+resp.Data)
+	s.mux.ServeHTTP(httptest.NewRecorder(), req)
+
+	entries, err := s.auditLog.Query(AuditFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("期望 3 条记录，实际 %+v", entries)
+	}
+	if e := entries[0]; e.Action != AuditLoginFailed || e.Actor != "anonymous" || e.Resource != "accounts/login@example.com" {
+		t.Errorf("登录失败记录不正确: %+v", e)
+	}
+	actor := "user:" + strconv.Itoa(created.ID)
+	if e := entries[1]; e.Action != AuditLogin || e.Actor != actor || e.Session == "" {
+		t.Errorf("登录记录不正确: %+v", e)
+	}
+	if e := entries[2]; e.Action != AuditLogout || e.Actor != actor || e.Session != entries[1].Session {
+		t.Errorf("注销记录不正确: %+v", e)
+	}
+}
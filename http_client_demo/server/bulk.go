@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"http_client_demo/api"
+)
+
+// 导入时单行的最大字节数
+const maxImportLineBytes = 64 << 10
+
+// DefaultMaxImportUsers 单次批量导入默认的最多用户数
+const DefaultMaxImportUsers = 10000
+
+// ImportResult 批量导入结果中的一行
+type ImportResult = api.ImportResult
+
+// pendingImport 原子模式下已校验、等待写入的一行
+type pendingImport struct {
+	result int // 在结果列表中的下标
+	user   *User
+}
+
+// importUsers 批量导入用户。请求体每行一个用户的JSON，响应每行一条结果，最后一行是汇总。
+// best-effort 模式边读边写并逐行返回结果；atomic 模式读完并全部校验通过（包括邮箱与本批
+// 其他行和已有用户都不重复）后才一次写入，写入中途出错时删除本次已创建的用户，不发布事件也不记录审计
+func (s *SimpleServer) importUsers(w http.ResponseWriter, r *http.Request) {
+	mode := api.ImportMode(r.URL.Query().Get("mode"))
+	if mode == "" {
+		mode = api.ImportBestEffort
+	}
+	if mode != api.ImportBestEffort && mode != api.ImportAtomic {
+		s.sendResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("未知导入模式: %s", mode),
+		})
+		return
+	}
+
+	// 导入可能持续较长时间，去掉读写超时；HTTP/1.1 下写出结果后仍需继续读取请求体
+	rc := http.NewResponseController(w)
+	for _, err := range []error{rc.SetReadDeadline(time.Time{}), rc.SetWriteDeadline(time.Time{}), rc.EnableFullDuplex()} {
+		if err != nil && !errors.Is(err, http.ErrNotSupported) {
+			s.sendResponse(w, http.StatusInternalServerError, APIResponse{
+				Success: false,
+				Message: fmt.Sprintf("准备导入失败: %v", err),
+			})
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", api.NDJSONContentType)
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	emit := func(result ImportResult) {
+		enc.Encode(result)
+		rc.Flush()
+	}
+
+	var results []ImportResult
+	var pending []pendingImport
+	emails := map[string]int{} // 原子模式下本批已出现的邮箱到行号
+	summary := ImportResult{Status: api.ImportDone}
+	record := func(result ImportResult) {
+		if result.Status == api.ImportFailed {
+			summary.Failed++
+		}
+		if mode == api.ImportAtomic {
+			results = append(results, result)
+		} else {
+			emit(result)
+		}
+	}
+
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(make([]byte, 0, 4096), maxImportLineBytes)
+	line, count := 0, 0
+	for scanner.Scan() {
+		line++
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		count++
+		if count > s.maxImportUsers() {
+			record(ImportResult{Line: line, Status: api.ImportFailed, Error: fmt.Sprintf("超过单次导入上限 %d 个用户", s.maxImportUsers())})
+			break
+		}
+
+		user, problem := s.parseImportLine(data)
+		if problem != nil {
+			record(importFailure(line, problem))
+			continue
+		}
+		if mode == api.ImportAtomic {
+			key := strings.ToLower(user.Email)
+			if first, ok := emails[key]; ok {
+				record(importEmailFailure(line, fmt.Sprintf("与第 %d 行的邮箱重复", first)))
+				continue
+			}
+			emails[key] = line
+			if existing, err := s.users.Search(UserQuery{Email: user.Email}); err == nil && len(existing) > 0 {
+				record(importEmailFailure(line, ErrEmailTaken.Error()))
+				continue
+			}
+			pending = append(pending, pendingImport{result: len(results), user: user})
+			record(ImportResult{Line: line, Status: api.ImportSkipped})
+			continue
+		}
+		created, err := s.store.Create(user)
+		if err != nil {
+			record(importStoreFailure(line, err))
+			continue
+		}
+		s.audit(r, AuditUserCreate, userResource(created.ID), nil, created)
+		summary.Created++
+		record(ImportResult{Line: line, Status: api.ImportCreated, User: created})
+	}
+	if err := scanner.Err(); err != nil {
+		reason := fmt.Sprintf("读取请求失败: %v", err)
+		if errors.Is(err, bufio.ErrTooLong) {
+			reason = fmt.Sprintf("单行不能超过 %d 字节", maxImportLineBytes)
+		}
+		record(ImportResult{Line: line + 1, Status: api.ImportFailed, Error: reason})
+	}
+
+	if mode == api.ImportAtomic {
+		if summary.Failed == 0 {
+			summary.Created, summary.Failed = s.commitImport(r, results, pending)
+		}
+		for _, result := range results {
+			emit(result)
+		}
+	}
+	emit(summary)
+}
+
+// maxImportUsers 返回单次导入的最多用户数，未配置时使用默认值
+func (s *SimpleServer) maxImportUsers() int {
+	if s.config.MaxImportUsers > 0 {
+		return s.config.MaxImportUsers
+	}
+	return DefaultMaxImportUsers
+}
+
+// parseImportLine 严格解析并校验一行，返回待创建的用户。与创建用户接口一样，新用户总是只读角色
+func (s *SimpleServer) parseImportLine(data []byte) (*User, *Problem) {
+	var user User
+	if err := decodeStrict(bytes.NewReader(data), &user); err != nil {
+		return nil, decodeProblem(err)
+	}
+	if err := user.Validate(); err != nil {
+		return nil, validationProblem(err)
+	}
+	if err := s.hashUserPassword(&user); err != nil {
+		log.Printf("计算密码哈希失败: %v", err)
+		return nil, &Problem{Title: "服务器内部错误", Status: http.StatusInternalServerError}
+	}
+	user.ID, user.Version, user.Role = 0, 0, api.RoleViewer
+	return &user, nil
+}
+
+// importFailure 把一行的错误详情转换为导入结果
+func importFailure(line int, problem *Problem) ImportResult {
+	reason := problem.Title
+	if problem.Detail != "" {
+		reason += ": " + problem.Detail
+	}
+	return ImportResult{Line: line, Status: api.ImportFailed, Error: reason, InvalidParams: problem.InvalidParams}
+}
+
+// importEmailFailure 邮箱重复的导入结果
+func importEmailFailure(line int, reason string) ImportResult {
+	return ImportResult{Line: line, Status: api.ImportFailed, Error: "请求数据校验失败",
+		InvalidParams: []api.InvalidParam{{Name: "email", Reason: reason}}}
+}
+
+// importStoreFailure 把写入存储的错误转换为导入结果，邮箱重复时指出 email 字段
+func importStoreFailure(line int, err error) ImportResult {
+	if errors.Is(err, ErrEmailTaken) {
+		return importEmailFailure(line, ErrEmailTaken.Error())
+	}
+	log.Printf("导入第 %d 行失败: %v", line, err)
+	return ImportResult{Line: line, Status: api.ImportFailed, Error: "服务器内部错误"}
+}
+
+// commitImport 原子模式下一次创建所有已校验的用户，结果写回 results。
+// 某一行写入失败时本次创建的用户全部回滚，该行标记为失败，其余行保持 skipped
+func (s *SimpleServer) commitImport(r *http.Request, results []ImportResult, pending []pendingImport) (created, failed int) {
+	users := make([]*User, len(pending))
+	for i, p := range pending {
+		users[i] = p.user
+	}
+	createdUsers, index, err := s.store.CreateAll(users)
+	if err != nil {
+		p := pending[index]
+		results[p.result] = importStoreFailure(results[p.result].Line, err)
+		return 0, 1
+	}
+	for i, user := range createdUsers {
+		s.audit(r, AuditUserCreate, userResource(user.ID), nil, user)
+		results[pending[i].result].Status = api.ImportCreated
+		results[pending[i].result].User = user
+	}
+	return len(createdUsers), 0
+}
+
+// exportUsers 按ID顺序导出所有用户，format 为 ndjson（默认）或 csv，
+// 没有 format 参数时根据 Accept 头选择。导出内容不包含密码
+func (s *SimpleServer) exportUsers(w http.ResponseWriter, r *http.Request) {
+	format := api.ExportFormat(r.URL.Query().Get("format"))
+	if format == "" {
+		format = api.ExportNDJSON
+		if strings.Contains(r.Header.Get("Accept"), "text/csv") {
+			format = api.ExportCSV
+		}
+	}
+	if format != api.ExportNDJSON && format != api.ExportCSV {
+		s.sendResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("未知导出格式: %s", format),
+		})
+		return
+	}
+
+	users, err := s.store.List()
+	if err != nil {
+		s.sendStoreError(w, err)
+		return
+	}
+
+	if format == api.ExportCSV {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="users.csv"`)
+		cw := csv.NewWriter(w)
+		cw.Write(api.UserCSVHeader)
+		for _, user := range users {
+			cw.Write([]string{strconv.Itoa(user.ID), user.Name, user.Email, string(user.Role), strconv.Itoa(user.Version)})
+		}
+		cw.Flush()
+		return
+	}
+
+	w.Header().Set("Content-Type", api.NDJSONContentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="users.ndjson"`)
+	enc := json.NewEncoder(w)
+	for _, user := range users {
+		if err := enc.Encode(user); err != nil {
+			return
+		}
+	}
+}
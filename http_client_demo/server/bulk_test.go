@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"http_client_demo/api"
+)
+
+// newImportTestServer 创建关闭限流、密码哈希迭代次数较少的服务器
+func newImportTestServer() *SimpleServer {
+	config := DefaultServerConfig("0")
+	config.RateLimit = RateLimitConfig{}
+	config.PasswordIterations = 1000
+	config.MaxImportUsers = 5
+	return NewSimpleServerWithConfig(config, NewMemoryUserStore())
+}
+
+// importLines 发送导入请求并解析每行结果
+func importLines(t *testing.T, s *SimpleServer, mode, body string) []ImportResult {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/v2/users:import?mode="+mode, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer your-api-key-here")
+	rec := httptest.NewRecorder()
+	s.mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != api.NDJSONContentType {
+		t.Fatalf("期望 200 NDJSON，实际 %d %s", rec.Code, rec.Body.String())
+	}
+	var results []ImportResult
+	dec := json.NewDecoder(rec.Body)
+	for dec.More() {
+		var result ImportResult
+		if err := dec.Decode(&result); err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+const importBody = `{"name":"赵六","email":"zhaoliu@example.com","password":"s3cret-pass"}
+
+{"name":"钱七","email":"qianqi"}
+{"name":"孙八","email":"sunba@example.com","role":"admin"}
+{"name":"李九","email":"lijiu@example.com","nickname":"九"}
+`
+
+// TestImportUsers 测试两种模式下的逐行结果、汇总和写入情况
+func TestImportUsers(t *testing.T) {
+	s := newImportTestServer()
+	results := importLines(t, s, "best-effort", importBody)
+	want := []struct {
+		line   int
+		status api.ImportStatus
+		field  string
+	}{
+		{1, api.ImportCreated, ""},
+		{3, api.ImportFailed, "email"},
+		{4, api.ImportCreated, ""},
+		{5, api.ImportFailed, "nickname"},
+	}
+	if len(results) != len(want)+1 {
+		t.Fatalf("期望 %d 行结果，实际 %+v", len(want)+1, results)
+	}
+	for i, w := range want {
+		got := results[i]
+		if got.Line != w.line || got.Status != w.status || (w.field != "" && (len(got.InvalidParams) != 1 || got.InvalidParams[0].Name != w.field)) {
+			t.Errorf("第 %d 条结果期望 行%d %s %s，实际 %+v", i, w.line, w.status, w.field, got)
+		}
+	}
+	if summary := results[len(results)-1]; summary.Status != api.ImportDone || summary.Created != 2 || summary.Failed != 2 {
+		t.Errorf("汇总不正确: %+v", summary)
+	}
+	users, _ := s.store.List()
+	if len(users) != 2 || users[1].Role != api.RoleViewer || users[0].PasswordHash == "" {
+		t.Errorf("期望创建两个只读用户并保存密码哈希，实际 %+v", users)
+	}
+
+	// 原子模式：有错误时一个都不创建
+	s = newImportTestServer()
+	results = importLines(t, s, "atomic", importBody)
+	if summary := results[len(results)-1]; summary.Created != 0 || summary.Failed != 2 ||
+		results[0].Status != api.ImportSkipped || results[1].Status != api.ImportFailed {
+		t.Errorf("原子模式结果不正确: %+v", results)
+	}
+	if users, _ := s.store.List(); len(users) != 0 {
+		t.Errorf("原子模式出错时不应创建用户，实际 %d 个", len(users))
+	}
+	// 原子模式写入前检查邮箱与本批其他行重复
+	results = importLines(t, s, "atomic", `{"name":"赵六","email":"zhaoliu@example.com"}`+"\n"+`{"name":"赵六","email":"ZhaoLiu@example.com"}`)
+	if results[1].Status != api.ImportFailed || len(results[1].InvalidParams) != 1 || !strings.Contains(results[1].InvalidParams[0].Reason, "第 1 行") {
+		t.Errorf("本批邮箱重复期望第2行失败，实际 %+v", results)
+	}
+	results = importLines(t, s, "atomic", `{"name":"赵六","email":"zhaoliu@example.com"}`+"\n"+`{"name":"孙八","email":"sunba@example.com"}`)
+	if summary := results[len(results)-1]; summary.Created != 2 || results[0].User == nil || results[0].User.ID != 1 {
+		t.Errorf("原子模式全部正确时期望全部创建，实际 %+v", results)
+	}
+
+	// 邮箱不区分大小写唯一，原子模式在写入前检查
+	for _, mode := range []string{"best-effort", "atomic"} {
+		results = importLines(t, s, mode, `{"name":"赵六","email":"ZHAOLIU@example.com"}`)
+		if results[0].Status != api.ImportFailed || len(results[0].InvalidParams) != 1 || results[0].InvalidParams[0].Name != "email" {
+			t.Errorf("%s 模式重复邮箱期望 email 字段出错，实际 %+v", mode, results[0])
+		}
+	}
+
+	// 超过上限
+	var lines strings.Builder
+	for i := 0; i < 6; i++ {
+		fmt.Fprintf(&lines, `{"name":"周十","email":"zhoushi%d@example.com"}`+"\n", i)
+	}
+	results = importLines(t, s, "best-effort", lines.String())
+	if summary := results[len(results)-1]; summary.Created != 5 || summary.Failed != 1 || !strings.Contains(results[5].Error, "上限") {
+		t.Errorf("超过上限期望创建5个并报错，实际 %+v", results)
+	}
+
+	// 未配置上限时使用默认值
+	s.config.MaxImportUsers = 0
+	results = importLines(t, s, "best-effort", `{"name":"吴一","email":"wuyi@example.com"}`)
+	if summary := results[len(results)-1]; summary.Created != 1 {
+		t.Errorf("上限为0时期望使用默认上限，实际 %+v", results)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/v2/users:import?mode=all", strings.NewReader(""))
+	req.Header.Set("Authorization", "Bearer your-api-key-here")
+	rec := httptest.NewRecorder()
+	s.mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("未知模式期望 400，实际 %d", rec.Code)
+	}
+}
+
+// failingCreateStore 创建指定邮箱的用户时返回错误，模拟写入中途失败
+type failingCreateStore struct {
+	UserStore
+	email string
+}
+
+// Create 邮箱匹配时失败，否则交给内部存储
+func (st failingCreateStore) Create(user *User) (*User, error) {
+	if user.Email == st.email {
+		return nil, errors.New("磁盘已满")
+	}
+	return st.UserStore.Create(user)
+}
+
+// TestImportAtomicRollback 测试原子模式写入中途失败时回滚，不发布事件也不记录审计
+func TestImportAtomicRollback(t *testing.T) {
+	config := DefaultServerConfig("0")
+	config.RateLimit = RateLimitConfig{}
+	s := NewSimpleServerWithConfig(config, failingCreateStore{NewMemoryUserStore(), "sunba@example.com"})
+	var events []UserEvent
+	s.events.subscribe(func(event UserEvent) {
+		events = append(events, event)
+	})
+
+	results := importLines(t, s, "atomic", `{"name":"赵六","email":"zhaoliu@example.com"}`+"\n"+`{"name":"孙八","email":"sunba@example.com"}`)
+	if results[0].Status != api.ImportSkipped || results[1].Status != api.ImportFailed || results[2].Created != 0 {
+		t.Errorf("期望第1行跳过、第2行失败，实际 %+v", results)
+	}
+	if users, _ := s.store.List(); len(users) != 0 {
+		t.Errorf("期望回滚后没有用户，实际 %d 个", len(users))
+	}
+	if len(events) != 0 {
+		t.Errorf("回滚的用户不应发布事件，实际 %+v", events)
+	}
+	if entries, _ := s.auditLog.Query(AuditFilter{}); len(entries) != 0 {
+		t.Errorf("回滚的用户不应记录审计，实际 %+v", entries)
+	}
+}
+
+// TestImportUsersStreams 测试请求体还没发送完时已经能读到前面行的结果
+func TestImportUsersStreams(t *testing.T) {
+	config := DefaultServerConfig("0")
+	config.RateLimit = RateLimitConfig{}
+	s := startTestServerWithConfig(t, config)
+
+	body, writer := io.Pipe()
+	req, _ := http.NewRequest(http.MethodPost, "http://"+s.Addr()+"/v2/users:import", body)
+	req.Header.Set("Authorization", "Bearer your-api-key-here")
+	req.Header.Set("Content-Type", api.NDJSONContentType)
+	go writer.Write([]byte(`{"name":"赵六","email":"zhaoliu@example.com"}` + "\n"))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	reader := bufio.NewReader(resp.Body)
+	first, err := reader.ReadString('\n')
+	if err != nil || !strings.Contains(first, `"status":"created"`) {
+		t.Fatalf("期望先收到第一行的结果，实际 %q %v", first, err)
+	}
+
+	writer.Write([]byte(`{"name":"钱七","email":"qianqi@example.com"}` + "\n"))
+	writer.Close()
+	rest, _ := io.ReadAll(reader)
+	if !strings.Contains(string(rest), `"line":2,"status":"created"`) || !strings.Contains(string(rest), `"created":2`) {
+		t.Errorf("期望第二行结果和汇总，实际 %s", rest)
+	}
+}
+
+// TestExportUsers 测试NDJSON和CSV导出，以及按 Accept 头选择格式
+func TestExportUsers(t *testing.T) {
+	s := newImportTestServer()
+	s.store.Create(&User{Name: "赵六", Email: "zhaoliu@example.com", PasswordHash: "hash", Role: api.RoleEditor})
+	s.store.Create(&User{Name: "钱,七", Email: "qianqi@example.com", Role: api.RoleViewer})
+
+	export := func(query, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/v2/users:export"+query, nil)
+		req.Header.Set("Authorization", "Bearer your-api-key-here")
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		rec := httptest.NewRecorder()
+		s.mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := export("", "")
+	lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+	if rec.Header().Get("Content-Type") != api.NDJSONContentType || len(lines) != 2 || strings.Contains(rec.Body.String(), "hash") {
+		t.Errorf("NDJSON导出不正确: %s", rec.Body.String())
+	}
+	var user User
+	if err := json.Unmarshal([]byte(lines[1]), &user); err != nil || user.Name != "钱,七" {
+		t.Errorf("期望第二行为钱,七，实际 %s %v", lines[1], err)
+	}
+
+	for _, rec := range []*httptest.ResponseRecorder{export("?format=csv", ""), export("", "text/csv")} {
+		records, err := csv.NewReader(rec.Body).ReadAll()
+		if err != nil || len(records) != 3 || strings.Join(records[0], ",") != "id,name,email,role,version" ||
+			records[1][3] != "editor" || records[2][1] != "钱,七" {
+			t.Errorf("CSV导出不正确: %v %v", records, err)
+		}
+	}
+
+	if rec := export("?format=xml", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("未知格式期望 400，实际 %d", rec.Code)
+	}
+}
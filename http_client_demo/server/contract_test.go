@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"http_client_demo/api"
+)
+
+// newContractServer 创建契约测试用的服务器，上传文件保存在测试临时目录
+func newContractServer(t *testing.T) *SimpleServer {
+	config := DefaultServerConfig("0")
+	config.UploadDir = t.TempDir()
+	s := NewSimpleServerWithConfig(config, NewMemoryUserStore())
+	s.initTestData()
+	return s
+}
+
+// contractRequest 为路由构造一个合法的请求，文件相关路由先上传一个文件作为路径参数
+func contractRequest(s *SimpleServer, v api.Version, route api.Route) *http.Request {
+	var param interface{} = 1
+	if route.Path == api.PathFile {
+		store, _ := s.uploads.get(s.config.UploadDir)
+		info, _ := store.Save("test.txt", "text/plain", strings.NewReader("data"))
+		param = info.ID
+	}
+	path := v.Path(route.Path, param)
+
+	var req *http.Request
+	switch route.Path {
+	case api.PathLogin:
+		req = httptest.NewRequest(route.Method, path, strings.NewReader("username=zhangsan@example.com&password=password123"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	case api.PathUpload:
+		req = httptest.NewRequest(route.Method, path, strings.NewReader("data"))
+		req.Header.Set("X-Filename", "test.txt")
+	case api.PathEncryptedUsers:
+		req = httptest.NewRequest(route.Method, path, strings.NewReader(`{"encrypted_data":"abc"}`))
+	case api.PathEvents:
+		// 事件流是长连接，用已取消的上下文让它写完响应头后立即返回
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		req = httptest.NewRequestWithContext(ctx, route.Method, path, nil)
+	default:
+		req = httptest.NewRequest(route.Method, path, strings.NewReader(`{"name":"赵六","email":"zhaoliu@example.com"}`))
+	}
+	req.Header.Set("Authorization", "Bearer your-api-key-here")
+	return req
+}
+
+// TestContractRoutesServed 测试 api 包声明的每条路由服务端都已实现
+func TestContractRoutesServed(t *testing.T) {
+	for _, v := range api.Versions {
+		for _, route := range api.Routes(v) {
+			s := newContractServer(t)
+
+			rec := httptest.NewRecorder()
+			s.mux.ServeHTTP(rec, contractRequest(s, v, route))
+
+			if rec.Code == http.StatusNotFound || rec.Code == http.StatusMethodNotAllowed ||
+				rec.Code == http.StatusMovedPermanently || rec.Code >= http.StatusInternalServerError {
+				t.Errorf("%s %s %s: 服务端未实现，状态码 %d", v, route.Method, route.Path, rec.Code)
+			}
+			// 下载接口返回文件内容，事件流返回 text/event-stream，批量导入导出返回NDJSON，其余接口返回JSON
+			if route.Method == "GET" && route.Path == api.PathFile {
+				continue
+			}
+			if route.Path == api.PathEvents {
+				if ct := rec.Header().Get("Content-Type"); ct != "text/event-stream" {
+					t.Errorf("%s %s: 期望事件流响应，实际 %q", v, route.Path, ct)
+				}
+				continue
+			}
+			if route.Path == api.PathUsersImport || route.Path == api.PathUsersExport {
+				if ct := rec.Header().Get("Content-Type"); ct != api.NDJSONContentType {
+					t.Errorf("%s %s: 期望NDJSON响应，实际 %q", v, route.Path, ct)
+				}
+				continue
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("%s %s %s: 期望JSON响应，实际 %q", v, route.Method, route.Path, ct)
+			}
+		}
+	}
+}
+
+// TestContractRoutesNotLeaked 测试服务端不会在旧版本中提供新版本才声明的路由
+func TestContractRoutesNotLeaked(t *testing.T) {
+	latest := api.Versions[len(api.Versions)-1]
+	for _, v := range api.Versions {
+		for _, route := range api.Routes(latest) {
+			if v.Supports(route.Since) {
+				continue
+			}
+			s := newContractServer(t)
+
+			rec := httptest.NewRecorder()
+			s.mux.ServeHTTP(rec, contractRequest(s, v, route))
+			if rec.Code != http.StatusNotFound && rec.Code != http.StatusMethodNotAllowed {
+				t.Errorf("%s %s %s: 该版本不应提供此路由，状态码 %d", v, route.Method, route.Path, rec.Code)
+			}
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"http_client_demo/api"
+)
+
+// EventStreamConfig GET /events 事件流配置
+type EventStreamConfig struct {
+	// ReplaySize 保留最近多少个事件，用于客户端断线后按 Last-Event-ID 补发
+	ReplaySize int
+	// Heartbeat 没有事件时发送心跳注释的间隔，防止代理关闭空闲连接，也让客户端发现断线
+	Heartbeat time.Duration
+	// ClientBuffer 每个连接缓冲的事件数，客户端读取太慢、缓冲满时断开连接，由客户端重连补发
+	ClientBuffer int
+}
+
+// DefaultEventStreamConfig 返回默认配置：保留最近1000个事件，每15秒一次心跳
+func DefaultEventStreamConfig() EventStreamConfig {
+	return EventStreamConfig{
+		ReplaySize:   1000,
+		Heartbeat:    15 * time.Second,
+		ClientBuffer: 64,
+	}
+}
+
+// 客户端断线后重连前等待的时间，通过 retry 字段告诉 EventSource
+const eventStreamRetry = 3 * time.Second
+
+// eventStream 保存最近的事件用于断线续传，并把新事件推送给已连接的客户端
+type eventStream struct {
+	config EventStreamConfig
+
+	mu      sync.Mutex
+	recent  []UserEvent
+	lastID  int64
+	clients map[chan UserEvent]struct{}
+	done    chan struct{}
+	closed  bool
+}
+
+// newEventStream 创建事件流，零值配置项使用默认值
+func newEventStream(config EventStreamConfig) *eventStream {
+	defaults := DefaultEventStreamConfig()
+	if config.ReplaySize <= 0 {
+		config.ReplaySize = defaults.ReplaySize
+	}
+	if config.Heartbeat <= 0 {
+		config.Heartbeat = defaults.Heartbeat
+	}
+	if config.ClientBuffer <= 0 {
+		config.ClientBuffer = defaults.ClientBuffer
+	}
+	return &eventStream{
+		config:  config,
+		clients: make(map[chan UserEvent]struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// publish 保存事件并推送给所有客户端，作为事件总线的订阅者调用，不会阻塞
+func (st *eventStream) publish(event UserEvent) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.recent = append(st.recent, event)
+	if len(st.recent) > st.config.ReplaySize {
+		st.recent = st.recent[len(st.recent)-st.config.ReplaySize:]
+	}
+	st.lastID = event.ID
+
+	for ch := range st.clients {
+		select {
+		case ch <- event:
+		default:
+			// 缓冲已满，断开这个客户端，重连后按 Last-Event-ID 补发
+			delete(st.clients, ch)
+			close(ch)
+		}
+	}
+}
+
+// subscribe 注册一个客户端。resume 为true时返回 lastID 之后需要补发的事件；
+// 这些事件已不在缓冲区（或 lastID 来自服务器重启之前）时只返回一个 api.StreamReset 事件，
+// 其ID为当前位置，客户端应重新获取全量数据。
+// 查找补发事件和注册在同一把锁内，补发和推送之间不会漏掉或重复事件
+func (st *eventStream) subscribe(lastID int64, resume bool) (replay []UserEvent, ch chan UserEvent, err error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if st.closed {
+		return nil, nil, errors.New("服务器正在关闭")
+	}
+	if resume && lastID != st.lastID {
+		oldest := st.lastID + 1
+		if len(st.recent) > 0 {
+			oldest = st.recent[0].ID
+		}
+		if lastID > st.lastID || lastID < oldest-1 {
+			replay = []UserEvent{{ID: st.lastID, Type: api.StreamReset, Time: time.Now()}}
+		} else {
+			for _, event := range st.recent {
+				if event.ID > lastID {
+					replay = append(replay, event)
+				}
+			}
+		}
+	}
+
+	ch = make(chan UserEvent, st.config.ClientBuffer)
+	st.clients[ch] = struct{}{}
+	return replay, ch, nil
+}
+
+// unsubscribe 注销客户端
+func (st *eventStream) unsubscribe(ch chan UserEvent) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if _, ok := st.clients[ch]; ok {
+		delete(st.clients, ch)
+		close(ch)
+	}
+}
+
+// close 结束所有连接，服务器关闭时调用，否则 Shutdown 会一直等待事件流结束
+func (st *eventStream) close() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if !st.closed {
+		st.closed = true
+		close(st.done)
+	}
+}
+
+// writeEvent 按 SSE 格式写入一个事件
+func writeEvent(w http.ResponseWriter, event UserEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+	return err
+}
+
+// handleEvents 以 Server-Sent Events 推送用户变更事件。
+// 请求带 Last-Event-ID 时先补发之后的事件；没有事件时定期发送心跳注释
+func (s *SimpleServer) handleEvents(w http.ResponseWriter, r *http.Request) {
+	var lastID int64
+	header := r.Header.Get("Last-Event-ID")
+	resume := header != ""
+	if resume {
+		id, err := strconv.ParseInt(header, 10, 64)
+		if err != nil || id < 0 {
+			s.sendResponse(w, http.StatusBadRequest, APIResponse{
+				Success: false,
+				Message: "无效的Last-Event-ID",
+			})
+			return
+		}
+		lastID = id
+	}
+
+	// 事件流是长连接，去掉服务器的写超时
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		s.sendResponse(w, http.StatusInternalServerError, APIResponse{
+			Success: false,
+			Message: fmt.Sprintf("设置写超时失败: %v", err),
+		})
+		return
+	}
+
+	replay, ch, err := s.streams.subscribe(lastID, resume)
+	if err != nil {
+		s.sendResponse(w, http.StatusServiceUnavailable, APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+	defer s.streams.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", eventStreamRetry.Milliseconds())
+
+	for _, event := range replay {
+		if err := writeEvent(w, event); err != nil {
+			return
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(s.streams.config.Heartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.streams.done:
+			return
+		case event, ok := <-ch:
+			if !ok {
+				return
+			}
+			if err := writeEvent(w, event); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
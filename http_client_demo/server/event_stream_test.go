@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"http_client_demo/api"
+)
+
+// sseFrame 事件流中以空行结束的一段
+type sseFrame struct {
+	id, event, comment string
+	data               UserEvent
+}
+
+// openEventStream 连接 /v2/events，lastID 非空时带上 Last-Event-ID
+func openEventStream(t *testing.T, s *SimpleServer, lastID string) (*http.Response, *bufio.Reader) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, "http://"+s.Addr()+"/v2/events", nil)
+	req.Header.Set("Authorization", "Bearer your-api-key-here")
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("连接事件流失败: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp, bufio.NewReader(resp.Body)
+}
+
+// readFrame 读取下一段
+func readFrame(t *testing.T, r *bufio.Reader) sseFrame {
+	t.Helper()
+	var frame sseFrame
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("读取事件流失败: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return frame
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "":
+			frame.comment = value
+		case "id":
+			frame.id = value
+		case "event":
+			frame.event = value
+		case "data":
+			if err := json.Unmarshal([]byte(value), &frame.data); err != nil {
+				t.Fatalf("解析事件失败: %v", err)
+			}
+		}
+	}
+}
+
+// TestEventStream 测试实时推送、心跳、按 Last-Event-ID 补发，以及缓冲区外的ID触发重置
+func TestEventStream(t *testing.T) {
+	config := DefaultServerConfig("0")
+	config.RateLimit = RateLimitConfig{}
+	// 写超时比心跳间隔长，连接仍然不会被服务器断开
+	config.WriteTimeout = 200 * time.Millisecond
+	config.Events = EventStreamConfig{ReplaySize: 2, Heartbeat: 50 * time.Millisecond}
+	s := startTestServerWithConfig(t, config)
+
+	resp, r := openEventStream(t, s, "")
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("期望 200 text/event-stream，实际 %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	readFrame(t, r) // retry
+
+	user, err := s.store.Create(&User{Name: "赵六", Email: "zhaoliu@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 服务器启动时写入了演示数据，事件ID从之后开始
+	frame := readFrame(t, r)
+	first := frame.data.ID
+	if frame.id != strconv.FormatInt(first, 10) || frame.event != string(api.UserCreated) || frame.data.User == nil || frame.data.User.Name != "赵六" {
+		t.Errorf("期望创建事件，实际 %+v", frame)
+	}
+
+	time.Sleep(300 * time.Millisecond)
+	if frame := readFrame(t, r); frame.comment != "heartbeat" {
+		t.Errorf("期望心跳，实际 %+v", frame)
+	}
+	resp.Body.Close()
+
+	// 断线期间的事件在重连时补发
+	user.Name = "赵六六"
+	if _, err := s.store.Update(user); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.store.Delete(user.ID, 0); err != nil {
+		t.Fatal(err)
+	}
+	_, r = openEventStream(t, s, strconv.FormatInt(first, 10))
+	readFrame(t, r)
+	for _, want := range []api.UserEventType{api.UserUpdated, api.UserDeleted} {
+		if frame := readFrame(t, r); frame.event != string(want) {
+			t.Errorf("期望补发 %s，实际 %+v", want, frame)
+		}
+	}
+
+	// 缓冲区只保留最近2个事件，first 之后的事件已不完整；未来的ID来自重启前的服务器
+	if _, err := s.store.Create(&User{Name: "钱七", Email: "qianqi@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, lastID := range []int64{first, first + 99} {
+		_, r := openEventStream(t, s, strconv.FormatInt(lastID, 10))
+		readFrame(t, r)
+		if frame := readFrame(t, r); frame.event != string(api.StreamReset) || frame.data.ID != first+3 {
+			t.Errorf("Last-Event-ID %d 期望重置到 %d，实际 %+v", lastID, first+3, frame)
+		}
+	}
+
+	if resp, _ := openEventStream(t, s, "abc"); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("无效的Last-Event-ID期望 400，实际 %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"http_client_demo/api"
+)
+
+// UserEvent 用户变更事件
+type UserEvent = api.UserEvent
+
+// eventBus 为用户事件分配递增ID并按发生顺序分发给订阅者。
+// 订阅者在发布者的锁内被调用，不能阻塞
+type eventBus struct {
+	now func() time.Time
+
+	mu          sync.Mutex
+	lastID      int64
+	subscribers []func(UserEvent)
+}
+
+// newEventBus 创建事件总线
+func newEventBus() *eventBus {
+	return &eventBus{now: time.Now}
+}
+
+// subscribe 注册订阅者，之后发布的事件都会传给它
+func (b *eventBus) subscribe(fn func(UserEvent)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscribers = append(b.subscribers, fn)
+}
+
+// publish 发布一个用户事件
+func (b *eventBus) publish(typ api.UserEventType, user *User) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastID++
+	event := UserEvent{ID: b.lastID, Type: typ, Time: b.now(), User: user}
+	for _, fn := range b.subscribers {
+		fn(event)
+	}
+}
+
+// eventUserStore 在写操作成功后发布用户事件的存储装饰器。
+// 写操作串行执行，事件顺序与写入存储的顺序一致
+type eventUserStore struct {
+	inner  UserStore
+	events *eventBus
+
+	mu sync.Mutex
+}
+
+// newEventUserStore 为用户存储添加事件发布
+func newEventUserStore(inner UserStore, events *eventBus) *eventUserStore {
+	return &eventUserStore{inner: inner, events: events}
+}
+
+// Get 获取用户
+func (st *eventUserStore) Get(id int) (*User, error) {
+	return st.inner.Get(id)
+}
+
+// List 列出所有用户
+func (st *eventUserStore) List() ([]*User, error) {
+	return st.inner.List()
+}
+
+// Create 创建用户并发布 user.created
+func (st *eventUserStore) Create(user *User) (*User, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	created, err := st.inner.Create(user)
+	if err != nil {
+		return nil, err
+	}
+	copied := *created
+	st.events.publish(api.UserCreated, &copied)
+	return created, nil
+}
+
+// CreateAll 依次创建多个用户，全部成功后才发布 user.created。
+// 某个用户创建失败时删除本次已创建的用户，不发布任何事件，返回失败用户的下标和错误
+func (st *eventUserStore) CreateAll(users []*User) ([]*User, int, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	created := make([]*User, 0, len(users))
+	for i, user := range users {
+		c, err := st.inner.Create(user)
+		if err != nil {
+			for _, u := range created {
+				if err := st.inner.Delete(u.ID, 0); err != nil {
+					log.Printf("回滚用户 %d 失败: %v", u.ID, err)
+				}
+			}
+			return nil, i, err
+		}
+		created = append(created, c)
+	}
+	for _, user := range created {
+		copied := *user
+		st.events.publish(api.UserCreated, &copied)
+	}
+	return created, 0, nil
+}
+
+// Update 更新用户并发布 user.updated
+func (st *eventUserStore) Update(user *User) (*User, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	updated, err := st.inner.Update(user)
+	if err != nil {
+		return nil, err
+	}
+	copied := *updated
+	st.events.publish(api.UserUpdated, &copied)
+	return updated, nil
+}
+
+// Delete 删除用户并发布 user.deleted，事件中是删除前的用户
+func (st *eventUserStore) Delete(id int, version int) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	user, err := st.inner.Get(id)
+	if err != nil {
+		return err
+	}
+	if err := st.inner.Delete(id, version); err != nil {
+		return err
+	}
+	st.events.publish(api.UserDeleted, user)
+	return nil
+}
+
+// Ping 检查内部存储是否可用
+func (st *eventUserStore) Ping() error {
+	if p, ok := st.inner.(pinger); ok {
+		return p.Ping()
+	}
+	return nil
+}
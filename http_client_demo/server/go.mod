@@ -1,3 +1,7 @@
 module server
 
-go 1.21
+go 1.24
+
+require http_client_demo/api v0.0.0
+
+replace http_client_demo/api => ../api
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+)
+
+// pinger 可以检查自身是否可用的存储，例如已关闭的文件存储不可用
+type pinger interface {
+	Ping() error
+}
+
+// 存活检查：进程能处理请求即返回200
+func (s *SimpleServer) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	s.sendResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "ok",
+	})
+}
+
+// 就绪检查：存储可用且服务器没有在关闭时返回200，否则返回503，负载均衡据此摘除实例
+func (s *SimpleServer) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]string{"store": "ok", "server": "ok"}
+	ready := true
+
+	if err := s.store.Ping(); err != nil {
+		checks["store"] = err.Error()
+		ready = false
+	}
+	if s.shuttingDown.Load() {
+		checks["server"] = "正在关闭"
+		ready = false
+	}
+
+	if !ready {
+		s.sendResponse(w, http.StatusServiceUnavailable, APIResponse{
+			Success: false,
+			Message: "服务未就绪",
+			Data:    checks,
+		})
+		return
+	}
+	s.sendResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "服务已就绪",
+		Data:    checks,
+	})
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestReadyz 测试存储关闭或服务器关闭后就绪检查返回503
+func TestReadyz(t *testing.T) {
+	st, err := OpenFileUserStore(FileStoreConfig{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewSimpleServerWithStore("0", st)
+
+	probe := func(path string) int {
+		rec := httptest.NewRecorder()
+		s.mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code
+	}
+
+	if code := probe("/readyz"); code != http.StatusOK {
+		t.Errorf("期望就绪，实际状态码 %d", code)
+	}
+	st.Close()
+	if code := probe("/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("存储关闭后期望状态码 503，实际 %d", code)
+	}
+	if code := probe("/healthz"); code != http.StatusOK {
+		t.Errorf("存活检查不依赖存储，期望状态码 200，实际 %d", code)
+	}
+
+	s = NewSimpleServer("0")
+	s.Shutdown(context.Background())
+	if code := probe("/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("关闭中期望状态码 503，实际 %d", code)
+	}
+}
+
+// TestMetrics 测试指标以 Prometheus 文本格式输出请求数、耗时、用户数和上传字节数
+func TestMetrics(t *testing.T) {
+	config := DefaultServerConfig("0")
+	config.Logger = slog.New(slog.NewJSONHandler(io.Discard, nil))
+	config.UploadDir = t.TempDir()
+	s := NewSimpleServerWithConfig(config, NewMemoryUserStore())
+	s.initTestData()
+	handler := s.httpServer.Handler
+
+	send := func(method, path, body string) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer your-api-key-here")
+		req.Header.Set("X-Filename", "a.txt")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	send("GET", "/v2/users/1", "")
+	send("GET", "/v2/users/2", "")
+	send("GET", "/v2/users/99", "")
+	send("POST", "/v2/upload", "hello")
+	send("BREW", "/coffee", "")
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("期望文本格式，实际 %q", ct)
+	}
+
+	body := rec.Body.String()
+	for _, line := range []string{
+		"# TYPE http_requests_total counter",
+		`http_requests_total{method="GET",route="/v2/users/{id}",status="200"} 2`,
+		`http_requests_total{method="GET",route="/v2/users/{id}",status="404"} 1`,
+		`http_requests_total{method="OTHER",route="unmatched",status="404"} 1`,
+		"# TYPE http_request_duration_seconds histogram",
+		`http_request_duration_seconds_count{method="GET",route="/v2/users/{id}"} 3`,
+		`http_request_duration_seconds_bucket{method="GET",route="/v2/users/{id}",le="+Inf"} 3`,
+		"http_requests_in_flight 1",
+		"upload_bytes_total 5",
+		"users 3",
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("指标中缺少 %q\n%s", line, body)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// IdempotencyKeyHeader 幂等键请求头
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// 幂等键的最大长度
+const maxIdempotencyKeyLength = 255
+
+// 处理中的幂等键最长保留时间，超过后视为处理已中断，允许客户端重试
+const idempotencyPendingTimeout = 5 * time.Minute
+
+// idempotencyRecord 一个幂等键对应的已保存响应。
+// 处理中的记录 expiresAt 为处理超时时间，完成后为响应的过期时间
+type idempotencyRecord struct {
+	fingerprint string
+	done        bool
+	status      int
+	header      http.Header
+	body        []byte
+	expiresAt   time.Time
+}
+
+// idempotencyStore 保存幂等键到响应的映射，过期后自动清理
+type idempotencyStore struct {
+	mu        sync.Mutex
+	records   map[string]*idempotencyRecord
+	ttl       time.Duration
+	lastSweep time.Time
+}
+
+// newIdempotencyStore 创建新的幂等键存储
+func newIdempotencyStore(ttl time.Duration) *idempotencyStore {
+	return &idempotencyStore{
+		records: make(map[string]*idempotencyRecord),
+		ttl:     ttl,
+	}
+}
+
+// begin 查找或占用一个幂等键。
+// 占用成功时返回新记录 owner，之后用它调用 finish 或 abandon；
+// 返回已有记录时 owner 为nil，调用方根据记录决定重放还是拒绝。
+func (st *idempotencyStore) begin(key, fingerprint string, now time.Time) (rec idempotencyRecord, owner *idempotencyRecord) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	// 每分钟最多清理一次过期记录
+	if now.Sub(st.lastSweep) > time.Minute {
+		for k, r := range st.records {
+			if now.After(r.expiresAt) {
+				delete(st.records, k)
+			}
+		}
+		st.lastSweep = now
+	}
+
+	if existing, ok := st.records[key]; ok && now.Before(existing.expiresAt) {
+		return *existing, nil
+	}
+
+	owner = &idempotencyRecord{fingerprint: fingerprint, expiresAt: now.Add(idempotencyPendingTimeout)}
+	st.records[key] = owner
+	return idempotencyRecord{}, owner
+}
+
+// finish 保存处理结果，供后续相同请求重放。
+// 处理超时后幂等键已被其他请求占用时不覆盖
+func (st *idempotencyStore) finish(key string, owner *idempotencyRecord, status int, header http.Header, body []byte, now time.Time) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	rec, ok := st.records[key]
+	if !ok || rec != owner {
+		return
+	}
+	rec.done = true
+	rec.status = status
+	rec.header = header
+	rec.body = body
+	rec.expiresAt = now.Add(st.ttl)
+}
+
+// abandon 释放未完成的幂等键，允许客户端重试
+func (st *idempotencyStore) abandon(key string, owner *idempotencyRecord) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.records[key] == owner {
+		delete(st.records, key)
+	}
+}
+
+// idempotencyRecorder 在写出响应的同时记录状态码和响应体
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rw *idempotencyRecorder) WriteHeader(statusCode int) {
+	if rw.status == 0 {
+		rw.status = statusCode
+	}
+	rw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (rw *idempotencyRecorder) Write(p []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	rw.body.Write(p)
+	return rw.ResponseWriter.Write(p)
+}
+
+// idempotent 为POST请求提供幂等保护：
+// 相同幂等键和相同请求体会重放首次的响应，相同幂等键但请求体不同返回422
+func (s *SimpleServer) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyKeyHeader)
+		if r.Method != http.MethodPost || key == "" {
+			next(w, r)
+			return
+		}
+
+		if len(key) > maxIdempotencyKeyLength {
+			s.sendResponse(w, http.StatusBadRequest, APIResponse{
+				Success: false,
+				Message: "幂等键过长",
+			})
+			return
+		}
+
+		// 请求体需要完整读入内存计算指纹，先按JSON请求体的上限截断
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, s.maxBodyBytes()))
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				s.sendProblem(w, r, decodeProblem(err))
+				return
+			}
+			s.sendResponse(w, http.StatusBadRequest, APIResponse{
+				Success: false,
+				Message: "读取请求数据失败",
+			})
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		// 幂等键按调用方隔离，避免不同调用方之间相互重放
+		scope := sha256.Sum256([]byte(r.Header.Get("Authorization")))
+		storeKey := hex.EncodeToString(scope[:8]) + ":" + key
+
+		sum := sha256.New()
+		io.WriteString(sum, r.Method+" "+r.URL.Path+"\n")
+		sum.Write(body)
+		fingerprint := hex.EncodeToString(sum.Sum(nil))
+
+		rec, owner := s.idempotency.begin(storeKey, fingerprint, time.Now())
+		if owner == nil {
+			switch {
+			case rec.fingerprint != fingerprint:
+				s.sendResponse(w, http.StatusUnprocessableEntity, APIResponse{
+					Success: false,
+					Message: "幂等键已被用于不同的请求",
+				})
+			case !rec.done:
+				s.sendResponse(w, http.StatusConflict, APIResponse{
+					Success: false,
+					Message: "相同幂等键的请求正在处理中",
+				})
+			default:
+				// 外层中间件已为本次请求设置的头（请求ID、限流额度）保持不变
+				for k, v := range rec.header {
+					if _, exists := w.Header()[k]; !exists {
+						w.Header()[k] = v
+					}
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(rec.status)
+				w.Write(rec.body)
+			}
+			return
+		}
+
+		// 处理函数panic或返回服务端错误时释放幂等键，允许客户端使用同一幂等键重试
+		completed := false
+		defer func() {
+			if !completed {
+				s.idempotency.abandon(storeKey, owner)
+			}
+		}()
+
+		recorder := &idempotencyRecorder{ResponseWriter: w}
+		next(recorder, r)
+
+		if recorder.status == 0 || recorder.status >= http.StatusInternalServerError {
+			return
+		}
+		completed = true
+		s.idempotency.finish(storeKey, owner, recorder.status, w.Header().Clone(), recorder.body.Bytes(), time.Now())
+	}
+}
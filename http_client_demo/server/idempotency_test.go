@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"http_client_demo/api"
+)
+
+func postWithKey(handler http.HandlerFunc, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer your-api-key-here")
+	req.Header.Set(IdempotencyKeyHeader, key)
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+// TestIdempotentReplay 测试相同幂等键重放首次响应且不会重复创建用户
+func TestIdempotentReplay(t *testing.T) {
+	s := NewSimpleServer("0")
+	handler := s.idempotent(s.createUser)
+	body := `{"name":"赵六","email":"zhaoliu@example.com"}`
+
+	first := postWithKey(handler, "key-1", body)
+	if first.Code != http.StatusCreated {
+		t.Fatalf("期望状态码 %d，实际 %d", http.StatusCreated, first.Code)
+	}
+	second := postWithKey(handler, "key-1", body)
+	if second.Code != http.StatusCreated {
+		t.Fatalf("期望重放状态码 %d，实际 %d", http.StatusCreated, second.Code)
+	}
+	if second.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("重放的响应应带有 Idempotent-Replayed 头")
+	}
+	if first.Body.String() != second.Body.String() {
+		t.Errorf("重放响应不一致: %s != %s", first.Body.String(), second.Body.String())
+	}
+	if users, _ := s.store.List(); len(users) != 1 {
+		t.Errorf("期望只创建 1 个用户，实际 %d", len(users))
+	}
+
+	var resp APIResponse
+	if err := json.Unmarshal(second.Body.Bytes(), &resp); err != nil || !resp.Success {
+		t.Errorf("重放响应无法解析: %v", err)
+	}
+}
+
+// TestIdempotentKeyReuseWithDifferentBody 测试幂等键用于不同请求体时返回422
+func TestIdempotentKeyReuseWithDifferentBody(t *testing.T) {
+	s := NewSimpleServer("0")
+	handler := s.idempotent(s.createUser)
+
+	postWithKey(handler, "key-2", `{"name":"赵六","email":"zhaoliu@example.com"}`)
+	rec := postWithKey(handler, "key-2", `{"name":"钱七","email":"qianqi@example.com"}`)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("期望状态码 %d，实际 %d", http.StatusUnprocessableEntity, rec.Code)
+	}
+}
+
+// TestIdempotentWithoutKey 测试未携带幂等键的请求不受影响
+func TestIdempotentWithoutKey(t *testing.T) {
+	s := NewSimpleServer("0")
+	handler := s.idempotent(s.createUser)
+
+	postWithKey(handler, "", `{"name":"赵六","email":"zhaoliu@example.com"}`)
+	postWithKey(handler, "", `{"name":"赵六","email":"zhaoliu2@example.com"}`)
+	if users, _ := s.store.List(); len(users) != 2 {
+		t.Errorf("期望创建 2 个用户，实际 %d", len(users))
+	}
+}
+
+// TestIdempotentBodyLimit 测试带幂等键的请求同样受请求体大小限制，超出时返回413且不占用幂等键
+func TestIdempotentBodyLimit(t *testing.T) {
+	config := DefaultServerConfig("0")
+	config.MaxBodyBytes = 128
+	s := NewSimpleServerWithConfig(config, NewMemoryUserStore())
+	handler := s.idempotent(s.createUser)
+
+	rec := postWithKey(handler, "key-3", `{"name":"`+strings.Repeat("六", 100)+`","email":"zhaoliu@example.com"}`)
+	if rec.Code != http.StatusRequestEntityTooLarge || rec.Header().Get("Content-Type") != api.ProblemContentType {
+		t.Fatalf("期望 413 problem+json，实际 %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if rec := postWithKey(handler, "key-3", `{"name":"赵六","email":"zhaoliu@example.com"}`); rec.Code != http.StatusCreated {
+		t.Errorf("超出限制的请求不应占用幂等键，期望 201，实际 %d", rec.Code)
+	}
+}
+
+// TestIdempotentPanicReleasesKey 测试处理函数panic后释放幂等键，重试不会一直返回409
+func TestIdempotentPanicReleasesKey(t *testing.T) {
+	s := NewSimpleServer("0")
+	panicking := s.idempotent(func(w http.ResponseWriter, r *http.Request) {
+		panic("处理失败")
+	})
+	func() {
+		defer func() { recover() }()
+		postWithKey(panicking, "key-panic", `{"name":"赵六","email":"zhaoliu@example.com"}`)
+	}()
+
+	rec := postWithKey(s.idempotent(s.createUser), "key-panic", `{"name":"赵六","email":"zhaoliu@example.com"}`)
+	if rec.Code != http.StatusCreated {
+		t.Errorf("panic后重试期望 201，实际 %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+// TestIdempotencyPendingTimeout 测试处理中的幂等键超时后可以被重新占用，原请求完成时不覆盖新记录
+func TestIdempotencyPendingTimeout(t *testing.T) {
+	st := newIdempotencyStore(time.Hour)
+	now := time.Now()
+	_, stale := st.begin("k", "f", now)
+	if _, owner := st.begin("k", "f", now.Add(time.Second)); owner != nil {
+		t.Fatal("处理中的幂等键期望不能被占用")
+	}
+
+	later := now.Add(idempotencyPendingTimeout + time.Second)
+	_, owner := st.begin("k", "f", later)
+	if owner == nil {
+		t.Fatal("处理超时后期望可以重新占用")
+	}
+	st.finish("k", stale, http.StatusCreated, nil, nil, later)
+	st.abandon("k", stale)
+	if rec, o := st.begin("k", "f", later); o != nil || rec.done {
+		t.Errorf("原请求不应覆盖或释放新的记录，实际 %+v", rec)
+	}
+
+	// 清理时同样删除超时的处理中记录
+	st.begin("other", "f", later)
+	st.begin("x", "f", later.Add(idempotencyPendingTimeout+2*time.Minute))
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if _, ok := st.records["other"]; ok {
+		t.Error("超时的处理中记录期望被清理")
+	}
+}
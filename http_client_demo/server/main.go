@@ -1,12 +1,90 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 )
 
 func main() {
+	port := flag.String("port", "8080", "监听端口")
+	dataDir := flag.String("data-dir", "", "用户数据目录，为空时使用内存存储，重启后数据丢失")
+	uploadDir := flag.String("upload-dir", "", "上传文件保存目录，为空时使用临时目录，关闭服务器时删除")
+	maxUploadMB := flag.Int64("max-upload-mb", DefaultMaxUploadBytes>>20, "单个上传文件的最大大小（MB）")
+	logSampleRate := flag.Float64("log-sample-rate", 1, "2xx响应访问日志的采样比例，0到1之间")
+	passwordIterations := flag.Int("password-iterations", DefaultPasswordIterations, "密码哈希的PBKDF2迭代次数，调整后旧密码在下次登录时重新计算")
+	sessionTTL := flag.Duration("session-ttl", DefaultSessionTTL, "登录会话的有效期")
+	auditLogPath := flag.String("audit-log", "", "审计日志文件，为空时只保存在内存中，重启后丢失")
+	auditKey := flag.String("audit-key", os.Getenv("AUDIT_LOG_KEY"), "审计日志哈希链的HMAC密钥，默认读取环境变量 AUDIT_LOG_KEY")
+	adminKey := flag.String("admin-key", os.Getenv("ADMIN_API_KEY"), "管理员API Key，用于调用 /admin/keys 接口，默认读取环境变量 ADMIN_API_KEY")
+	flag.Parse()
+
+	config := DefaultServerConfig(*port)
+	config.AccessLogSampleRate = *logSampleRate
+	config.UploadDir = *uploadDir
+	config.MaxUploadBytes = *maxUploadMB << 20
+	config.PasswordIterations = *passwordIterations
+	config.SessionTTL = *sessionTTL
+	if *adminKey != "" {
+		config.APIKeys = append(config.APIKeys, BootstrapAPIKey{
+			Name:   "admin",
+			Key:    *adminKey,
+			Scopes: []Scope{ScopeAdmin},
+		})
+	} else {
+		log.Println("未配置管理员API Key，管理接口只能由 admin 角色的登录会话调用")
+	}
+
+	// 出错时先由 run 中的 defer 关闭已打开的存储和审计日志，再以非0状态退出
+	if err := run(config, *dataDir, *auditLogPath, *auditKey); err != nil {
+		log.Printf("服务器异常退出: %v", err)
+		os.Exit(1)
+	}
+	log.Println("服务器已关闭")
+}
+
+// run 打开用户存储和审计日志并运行服务器，直到收到 SIGINT/SIGTERM 后优雅关闭。
+// 返回前关闭已打开的存储和审计日志
+func run(config ServerConfig, dataDir, auditLogPath, auditKey string) error {
+	var store UserStore = NewMemoryUserStore()
+	if dataDir != "" {
+		fileStore, err := OpenFileUserStore(FileStoreConfig{Dir: dataDir, Sync: SyncAlways})
+		if err != nil {
+			return fmt.Errorf("打开用户存储失败: %v", err)
+		}
+		defer func() {
+			if err := fileStore.Close(); err != nil {
+				log.Printf("关闭用户存储失败: %v", err)
+			}
+		}()
+		store = fileStore
+	}
+
+	if auditLogPath != "" {
+		if auditKey == "" {
+			log.Println("未配置审计日志密钥，哈希链只能发现意外损坏，能写入日志文件的人可以重新计算哈希")
+		}
+		auditLog, err := OpenAuditLog(auditLogPath, []byte(auditKey))
+		if err != nil {
+			return fmt.Errorf("打开审计日志失败: %v", err)
+		}
+		defer func() {
+			if err := auditLog.Close(); err != nil {
+				log.Printf("关闭审计日志失败: %v", err)
+			}
+		}()
+		config.AuditLog = auditLog
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	// 启动模拟服务器
-	server := NewSimpleServer("8080")
+	server := NewSimpleServerWithConfig(config, store)
 	log.Println("启动HTTP模拟服务器...")
-	server.Start()
+	return server.Run(ctx)
 }
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultLatencyBuckets 请求耗时直方图的桶上界（秒）
+var defaultLatencyBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// metric 可以输出为 Prometheus 文本格式的指标
+type metric interface {
+	write(w io.Writer)
+}
+
+// metricsRegistry 极简的指标注册表，按注册顺序输出 Prometheus 文本格式
+type metricsRegistry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+func (reg *metricsRegistry) register(m metric) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.metrics = append(reg.metrics, m)
+}
+
+// writeTo 输出所有指标
+func (reg *metricsRegistry) writeTo(w io.Writer) {
+	reg.mu.Lock()
+	metrics := append([]metric(nil), reg.metrics...)
+	reg.mu.Unlock()
+
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+// labelKey 把标签值编码为map的键
+func labelKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// formatLabels 生成 {name="value",...}，extra 追加在最后（直方图的 le）
+func formatLabels(names, values []string, extra ...string) string {
+	if len(names) == 0 && len(extra) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(names)+1)
+	for i, name := range names {
+		parts = append(parts, name+`="`+escapeLabelValue(values[i])+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		parts = append(parts, extra[i]+`="`+escapeLabelValue(extra[i+1])+`"`)
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// escapeLabelValue 按文本格式转义标签值中的反斜杠、双引号和换行
+func escapeLabelValue(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+// formatFloat 按 Prometheus 的习惯输出数值
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// sortedKeys 按字典序返回键，保证输出稳定
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// valueVec 带标签的计数器或仪表盘
+type valueVec struct {
+	name, help, kind string
+	labels           []string
+
+	mu     sync.Mutex
+	values map[string]float64
+	label  map[string][]string
+}
+
+// newCounterVec 创建只增不减的计数器
+func (reg *metricsRegistry) newCounterVec(name, help string, labels ...string) *valueVec {
+	return reg.newValueVec(name, help, "counter", labels)
+}
+
+// newGaugeVec 创建可增可减的仪表盘
+func (reg *metricsRegistry) newGaugeVec(name, help string, labels ...string) *valueVec {
+	return reg.newValueVec(name, help, "gauge", labels)
+}
+
+func (reg *metricsRegistry) newValueVec(name, help, kind string, labels []string) *valueVec {
+	v := &valueVec{
+		name:   name,
+		help:   help,
+		kind:   kind,
+		labels: labels,
+		values: make(map[string]float64),
+		label:  make(map[string][]string),
+	}
+	reg.register(v)
+	return v
+}
+
+// add 给指定标签值的序列加上delta
+func (v *valueVec) add(delta float64, labelValues ...string) {
+	key := labelKey(labelValues)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if _, ok := v.label[key]; !ok {
+		v.label[key] = append([]string(nil), labelValues...)
+	}
+	v.values[key] += delta
+}
+
+func (v *valueVec) write(w io.Writer) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.name, v.help, v.name, v.kind)
+	if len(v.labels) == 0 && len(v.values) == 0 {
+		fmt.Fprintf(w, "%s 0\n", v.name)
+		return
+	}
+	for _, key := range sortedKeys(v.values) {
+		fmt.Fprintf(w, "%s%s %s\n", v.name, formatLabels(v.labels, v.label[key]), formatFloat(v.values[key]))
+	}
+}
+
+// gaugeFunc 输出时才计算的仪表盘，例如当前用户数
+type gaugeFunc struct {
+	name, help string
+	fn         func() float64
+}
+
+// newGaugeFunc 创建输出时调用fn取值的仪表盘
+func (reg *metricsRegistry) newGaugeFunc(name, help string, fn func() float64) {
+	reg.register(&gaugeFunc{name: name, help: help, fn: fn})
+}
+
+func (g *gaugeFunc) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", g.name, g.help, g.name, g.name, formatFloat(g.fn()))
+}
+
+// histogram 一个标签组合的直方图数据，counts 为各个桶的非累计计数
+type histogram struct {
+	labels []string
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// histogramVec 带标签的直方图
+type histogramVec struct {
+	name, help string
+	labels     []string
+	buckets    []float64
+
+	mu   sync.Mutex
+	data map[string]*histogram
+}
+
+// newHistogramVec 创建直方图，buckets 为升序的桶上界
+func (reg *metricsRegistry) newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	h := &histogramVec{
+		name:    name,
+		help:    help,
+		labels:  labels,
+		buckets: buckets,
+		data:    make(map[string]*histogram),
+	}
+	reg.register(h)
+	return h
+}
+
+// observe 记录一次观测值
+func (h *histogramVec) observe(value float64, labelValues ...string) {
+	key := labelKey(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	data, ok := h.data[key]
+	if !ok {
+		data = &histogram{
+			labels: append([]string(nil), labelValues...),
+			counts: make([]uint64, len(h.buckets)),
+		}
+		h.data[key] = data
+	}
+	if i := sort.SearchFloat64s(h.buckets, value); i < len(h.buckets) {
+		data.counts[i]++
+	}
+	data.count++
+	data.sum += value
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for _, key := range sortedKeys(h.data) {
+		data := h.data[key]
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += data.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, data.labels, "le", formatFloat(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, data.labels, "le", "+Inf"), data.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, data.labels), formatFloat(data.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, data.labels), data.count)
+	}
+}
+
+// serverMetrics SimpleServer 输出的指标
+type serverMetrics struct {
+	registry        metricsRegistry
+	requests        *valueVec
+	requestDuration *histogramVec
+	inFlight        *valueVec
+	uploadBytes     *valueVec
+}
+
+// newServerMetrics 注册服务器指标，users 返回当前用户数
+func newServerMetrics(users func() float64) *serverMetrics {
+	m := &serverMetrics{}
+	m.requests = m.registry.newCounterVec("http_requests_total", "按路由和状态码统计的请求数", "method", "route", "status")
+	m.requestDuration = m.registry.newHistogramVec("http_request_duration_seconds", "请求处理耗时", defaultLatencyBuckets, "method", "route")
+	m.inFlight = m.registry.newGaugeVec("http_requests_in_flight", "正在处理的请求数")
+	m.uploadBytes = m.registry.newCounterVec("upload_bytes_total", "累计上传的字节数")
+	m.registry.newGaugeFunc("users", "当前用户数", users)
+	return m
+}
+
+// observeRequest 记录一个已完成的请求。route 为 "METHOD 模式"，标签中只保留模式；
+// 未匹配路由的请求归为 unmatched、非标准方法归为 OTHER，避免客户端随意制造大量序列
+func (m *serverMetrics) observeRequest(method, route string, status int, elapsed time.Duration) {
+	if route == "" {
+		route = "unmatched"
+	} else if _, path, ok := strings.Cut(route, " "); ok {
+		route = path
+	}
+	if !slices.Contains(standardMethods, method) {
+		method = "OTHER"
+	}
+	m.requests.add(1, method, route, strconv.Itoa(status))
+	m.requestDuration.observe(elapsed.Seconds(), method, route)
+}
+
+// 输出 Prometheus 文本格式的指标
+func (s *SimpleServer) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	s.metrics.registry.writeTo(w)
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"http_client_demo/api"
+)
+
+// openAPIVersion 生成的文档遵循的 OpenAPI 版本
+const openAPIVersion = "3.1.0"
+
+// 文档中的认证方式名称：API Key 和登录会话都通过 Bearer 令牌传递
+const (
+	bearerScheme  = "apiKey"
+	sessionScheme = "session"
+)
+
+// openAPIDocument 根据已注册路由的元数据生成 OpenAPI 文档，
+// 请求和响应的结构通过反射Go类型得到，结构体放在 components.schemas 中按名称引用
+func (s *SimpleServer) openAPIDocument() map[string]interface{} {
+	gen := &schemaGenerator{schemas: make(map[string]interface{})}
+	paths := make(map[string]map[string]interface{})
+
+	for _, route := range s.routes {
+		op := map[string]interface{}{
+			"summary":     route.Summary,
+			"operationId": operationID(route.Method, route.Pattern),
+			"tags":        []string{route.Tag},
+			"responses":   gen.responses(route.routeSpec),
+		}
+		if params := openAPIParams(route); len(params) > 0 {
+			op["parameters"] = params
+		}
+		if route.Request != nil {
+			contentType := route.RequestType
+			if contentType == "" {
+				contentType = "application/json"
+			}
+			op["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					contentType: map[string]interface{}{"schema": gen.schema(reflect.TypeOf(route.Request))},
+				},
+			}
+		}
+		if security := openAPISecurity(route.routeSpec); len(security) > 0 {
+			op["security"] = security
+		}
+
+		if paths[route.Pattern] == nil {
+			paths[route.Pattern] = make(map[string]interface{})
+		}
+		paths[route.Pattern][strings.ToLower(route.Method)] = op
+	}
+
+	return map[string]interface{}{
+		"openapi": openAPIVersion,
+		"info": map[string]interface{}{
+			"title":   "SimpleServer API",
+			"version": string(api.Versions[len(api.Versions)-1]),
+			"description": "不带版本前缀的路径等同于 /v1。除文件下载、指标和本文档外，响应都使用 APIResponse 包装；" +
+				"JSON请求体格式错误或字段校验失败时返回 application/problem+json（RFC 7807）",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": gen.schemas,
+			"securitySchemes": map[string]interface{}{
+				bearerScheme: map[string]interface{}{
+					"type":        "http",
+					"scheme":      "bearer",
+					"description": "Authorization: Bearer <API Key>",
+				},
+				sessionScheme: map[string]interface{}{
+					"type":        "http",
+					"scheme":      "bearer",
+					"description": "Authorization: Bearer <登录返回的会话令牌>",
+				},
+			},
+		},
+	}
+}
+
+// handleOpenAPI 返回 OpenAPI 文档
+func (s *SimpleServer) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(s.openAPIDocument())
+}
+
+// openAPISecurity 返回路由接受的认证方式，任意一种满足即可。
+// 3.1 允许非OAuth认证方式列出角色，这里列出需要的权限范围，登录会话只在有角色拥有该权限时列出
+func openAPISecurity(spec routeSpec) []map[string][]string {
+	var security []map[string][]string
+	if spec.Scope != "" {
+		security = append(security, map[string][]string{bearerScheme: {string(spec.Scope)}})
+		if len(rolesWithScope(spec.Scope)) > 0 {
+			security = append(security, map[string][]string{sessionScheme: {string(spec.Scope)}})
+		}
+	}
+	if spec.Session {
+		security = append(security, map[string][]string{sessionScheme: {}})
+	}
+	return security
+}
+
+// openAPIParams 返回路径参数、元数据中声明的参数，以及幂等路由的 Idempotency-Key 请求头
+func openAPIParams(route registeredRoute) []map[string]interface{} {
+	var params []map[string]interface{}
+	for _, seg := range strings.Split(route.Pattern, "/") {
+		if name, ok := strings.CutPrefix(seg, "{"); ok {
+			params = append(params, map[string]interface{}{
+				"name":     strings.TrimSuffix(name, "}"),
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]interface{}{"type": "string"},
+			})
+		}
+	}
+
+	declared := route.Params
+	if route.Idempotent {
+		declared = append(slices.Clone(declared), routeParam{"header", "Idempotency-Key", "重复请求返回第一次的响应", false})
+	}
+	for _, p := range declared {
+		params = append(params, map[string]interface{}{
+			"name":        p.Name,
+			"in":          p.In,
+			"description": p.Description,
+			"required":    p.Required,
+			"schema":      map[string]interface{}{"type": "string"},
+		})
+	}
+	return params
+}
+
+// operationID 由方法和路径生成唯一的操作ID，例如 get_v2_users_id
+func operationID(method, pattern string) string {
+	id := strings.ToLower(method) + strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return '_'
+	}, pattern)
+	for strings.Contains(id, "__") {
+		id = strings.ReplaceAll(id, "__", "_")
+	}
+	return strings.TrimSuffix(id, "_")
+}
+
+// schemaGenerator 把Go类型转换为 JSON Schema，结构体按类型名登记到 schemas
+type schemaGenerator struct {
+	schemas map[string]interface{}
+}
+
+// responses 生成路由的响应说明：2xx 使用元数据中的响应类型，其余状态码返回 APIResponse 错误；
+// JSON请求体格式错误或校验失败时返回 Problem，请求体过大返回413
+func (g *schemaGenerator) responses(spec routeSpec) map[string]interface{} {
+	statuses := slices.Clone(spec.Status)
+	jsonBody := spec.Request != nil && spec.RequestType == ""
+	if jsonBody {
+		for _, status := range []int{http.StatusBadRequest, http.StatusRequestEntityTooLarge} {
+			if !slices.Contains(statuses, status) {
+				statuses = append(statuses, status)
+			}
+		}
+	}
+	if spec.Scope != "" {
+		statuses = append(statuses, http.StatusUnauthorized, http.StatusForbidden)
+	}
+	if spec.Session {
+		statuses = append(statuses, http.StatusUnauthorized)
+	}
+	statuses = append(statuses, http.StatusTooManyRequests)
+
+	envelope := g.schema(reflect.TypeOf(APIResponse{}))
+	responses := make(map[string]interface{}, len(statuses))
+	for _, status := range statuses {
+		var content map[string]interface{}
+		switch {
+		case jsonBody && status == http.StatusRequestEntityTooLarge:
+			content = map[string]interface{}{api.ProblemContentType: map[string]interface{}{"schema": g.schema(reflect.TypeOf(Problem{}))}}
+		case jsonBody && status == http.StatusBadRequest:
+			content = map[string]interface{}{
+				api.ProblemContentType: map[string]interface{}{"schema": g.schema(reflect.TypeOf(Problem{}))},
+				"application/json":     map[string]interface{}{"schema": envelope},
+			}
+		case status >= 300:
+			content = map[string]interface{}{"application/json": map[string]interface{}{"schema": envelope}}
+		case spec.RawResponse == "application/json":
+			content = map[string]interface{}{spec.RawResponse: map[string]interface{}{"schema": map[string]interface{}{"type": "object"}}}
+		case spec.RawResponse != "":
+			content = map[string]interface{}{spec.RawResponse: map[string]interface{}{"schema": map[string]interface{}{"type": "string"}}}
+		case spec.Response != nil:
+			content = map[string]interface{}{"application/json": map[string]interface{}{"schema": map[string]interface{}{
+				"allOf": []interface{}{envelope, map[string]interface{}{
+					"type":       "object",
+					"properties": map[string]interface{}{"data": g.schema(reflect.TypeOf(spec.Response))},
+				}},
+			}}}
+		default:
+			content = map[string]interface{}{"application/json": map[string]interface{}{"schema": envelope}}
+		}
+		responses[strconv.Itoa(status)] = map[string]interface{}{
+			"description": http.StatusText(status),
+			"content":     content,
+		}
+	}
+	return responses
+}
+
+// schema 返回类型对应的 JSON Schema，结构体返回 $ref 引用
+func (g *schemaGenerator) schema(t reflect.Type) map[string]interface{} {
+	switch t {
+	case reflect.TypeOf(time.Time{}):
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case reflect.TypeOf(Scope("")):
+		return map[string]interface{}{"type": "string", "enum": knownScopes}
+	case reflect.TypeOf(Role("")):
+		return map[string]interface{}{"type": "string", "enum": api.Roles}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return g.schema(t.Elem())
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "contentMediaType": "application/octet-stream"}
+		}
+		return map[string]interface{}{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		name := schemaName(t)
+		if _, ok := g.schemas[name]; !ok {
+			// 先占位，防止自引用的结构体无限递归
+			g.schemas[name] = nil
+			g.schemas[name] = g.structSchema(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	}
+	// interface{} 等任意类型
+	return map[string]interface{}{}
+}
+
+// structSchema 按 json 标签生成结构体的属性，没有标签的嵌入结构体展开到外层
+func (g *schemaGenerator) structSchema(t reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+	g.addFields(t, properties)
+	return map[string]interface{}{"type": "object", "properties": properties}
+}
+
+// addFields 把结构体的导出字段加入属性表
+func (g *schemaGenerator) addFields(t reflect.Type, properties map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				g.addFields(ft, properties)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		properties[name] = g.schema(f.Type)
+	}
+}
+
+// schemaName 文档中的结构体名称，未导出的类型名首字母转为大写
+func schemaName(t reflect.Type) string {
+	name := []rune(t.Name())
+	name[0] = unicode.ToUpper(name[0])
+	return string(name)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"http_client_demo/api"
+)
+
+// openAPIOperation 测试关心的操作字段
+type openAPIOperation struct {
+	Summary   string                     `json:"summary"`
+	Responses map[string]json.RawMessage `json:"responses"`
+	Security  []map[string][]string      `json:"security"`
+}
+
+// fetchOpenAPI 获取并解析文档，同时返回原始JSON
+func fetchOpenAPI(t *testing.T, s *SimpleServer) (map[string]map[string]openAPIOperation, string) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	s.mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("期望状态码 200，实际 %d", rec.Code)
+	}
+	var doc struct {
+		OpenAPI string                                 `json:"openapi"`
+		Paths   map[string]map[string]openAPIOperation `json:"paths"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("文档不是合法的JSON: %v", err)
+	}
+	if doc.OpenAPI != openAPIVersion {
+		t.Errorf("期望 openapi %s，实际 %s", openAPIVersion, doc.OpenAPI)
+	}
+	return doc.Paths, rec.Body.String()
+}
+
+// TestOpenAPICoversRoutes 测试每条注册的路由都出现在文档中，文档中也没有未注册的路由
+func TestOpenAPICoversRoutes(t *testing.T) {
+	s := NewSimpleServer("0")
+	paths, _ := fetchOpenAPI(t, s)
+
+	expected := make(map[string]bool)
+	for _, v := range api.Versions {
+		for _, route := range api.Routes(v) {
+			expected[route.Method+" "+v.Prefix()+route.Path] = true
+			if v == api.V1 {
+				expected[route.Method+" "+route.Path] = true
+			}
+		}
+	}
+	for _, route := range s.serverRoutes() {
+		expected[route.Method+" "+route.Path] = true
+	}
+
+	for key := range expected {
+		method, path, _ := strings.Cut(key, " ")
+		op, ok := paths[path][strings.ToLower(method)]
+		if !ok {
+			t.Errorf("路由 %s 缺少文档", key)
+			continue
+		}
+		if op.Summary == "" || len(op.Responses) == 0 {
+			t.Errorf("路由 %s 的文档缺少说明或响应", key)
+		}
+	}
+
+	params := regexp.MustCompile(`\{[^}]+\}`)
+	for path, ops := range paths {
+		for method := range ops {
+			req := httptest.NewRequest(strings.ToUpper(method), params.ReplaceAllString(path, "1"), nil)
+			if _, pattern := s.mux.Handler(req); pattern != strings.ToUpper(method)+" "+path {
+				t.Errorf("文档中的 %s %s 没有对应的路由，实际匹配 %q", method, path, pattern)
+			}
+		}
+	}
+}
+
+// TestOpenAPISchemas 测试权限、状态码和反射生成的结构体定义
+func TestOpenAPISchemas(t *testing.T) {
+	s := NewSimpleServer("0")
+	paths, raw := fetchOpenAPI(t, s)
+
+	put := paths["/v2/users/{id}"]["put"]
+	if len(put.Security) != 2 || put.Security[0][bearerScheme][0] != string(ScopeUsersWrite) ||
+		put.Security[1][sessionScheme][0] != string(ScopeUsersWrite) {
+		t.Errorf("PUT /v2/users/{id} 权限不正确: %v", put.Security)
+	}
+	for _, status := range []string{"200", "400", "401", "403", "412", "413", "428", "429"} {
+		if _, ok := put.Responses[status]; !ok {
+			t.Errorf("PUT /v2/users/{id} 缺少 %s 响应", status)
+		}
+	}
+	if login := paths["/v2/login"]["post"]; login.Security != nil {
+		t.Errorf("登录接口不需要认证，实际 %v", login.Security)
+	}
+	if me := paths["/v2/me"]["get"]; len(me.Security) != 1 || me.Security[0][sessionScheme] == nil {
+		t.Errorf("GET /v2/me 只接受登录会话，实际 %v", me.Security)
+	}
+	if keys := paths["/admin/keys"]["get"]; len(keys.Security) != 2 || keys.Security[1][sessionScheme][0] != string(ScopeAdmin) {
+		t.Errorf("管理接口期望接受admin角色的登录会话，实际 %v", keys.Security)
+	}
+
+	var doc struct {
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]json.RawMessage `json:"properties"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	json.Unmarshal([]byte(raw), &doc)
+	for name, fields := range map[string][]string{
+		"User":             {"id", "name", "email", "version"},
+		"FileInfo":         {"id", "size", "uploaded_at"},
+		"ApiKeyWithSecret": {"id", "scopes", "secret"},
+		"Problem":          {"type", "title", "status", "invalid_params"},
+	} {
+		schema, ok := doc.Components.Schemas[name]
+		if !ok {
+			t.Errorf("缺少结构体 %s", name)
+			continue
+		}
+		for _, field := range fields {
+			if _, ok := schema.Properties[field]; !ok {
+				t.Errorf("%s 缺少字段 %s", name, field)
+			}
+		}
+	}
+
+	// 所有引用都指向已定义的结构体
+	for _, m := range regexp.MustCompile(`"\$ref": "#/components/schemas/([^"]+)"`).FindAllStringSubmatch(raw, -1) {
+		if _, ok := doc.Components.Schemas[m[1]]; !ok {
+			t.Errorf("引用了未定义的结构体 %s", m[1])
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultPasswordIterations PBKDF2-HMAC-SHA256 的默认迭代次数
+	DefaultPasswordIterations = 600_000
+	// 密码哈希格式：pbkdf2-sha256$迭代次数$盐$哈希，盐和哈希使用不带填充的base64
+	passwordHashScheme = "pbkdf2-sha256"
+	passwordSaltSize   = 16
+	passwordKeySize    = 32
+)
+
+// hashPassword 使用随机盐和指定迭代次数计算密码哈希
+func hashPassword(password string, iterations int) (string, error) {
+	salt := make([]byte, passwordSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("生成盐失败: %v", err)
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, iterations, passwordKeySize)
+	if err != nil {
+		return "", fmt.Errorf("计算密码哈希失败: %v", err)
+	}
+	return strings.Join([]string{
+		passwordHashScheme,
+		strconv.Itoa(iterations),
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	}, "$"), nil
+}
+
+// verifyPassword 以恒定时间比较密码和哈希。
+// 哈希使用的迭代次数与 iterations 不同时 rehash 为true，调用方应在登录成功后重新计算哈希
+func verifyPassword(password, encoded string, iterations int) (ok, rehash bool) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 4 || parts[0] != passwordHashScheme {
+		return false, false
+	}
+	n, err := strconv.Atoi(parts[1])
+	if err != nil || n <= 0 {
+		return false, false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false, false
+	}
+	expected, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false, false
+	}
+
+	key, err := pbkdf2.Key(sha256.New, password, salt, n, len(expected))
+	if err != nil {
+		return false, false
+	}
+	ok = subtle.ConstantTimeCompare(key, expected) == 1
+	return ok, ok && n != iterations
+}
+
+// cachedPasswordHashes 相同密码和迭代次数的哈希在进程内缓存
+var cachedPasswordHashes sync.Map
+
+// cachedPasswordHash 返回缓存的密码哈希，只用于测试数据和不存在账户的计时填充，
+// 避免每创建一个服务器或每次登录失败都重新计算
+func cachedPasswordHash(password string, iterations int) string {
+	key := strconv.Itoa(iterations) + "$" + password
+	if hash, ok := cachedPasswordHashes.Load(key); ok {
+		return hash.(string)
+	}
+	hash, err := hashPassword(password, iterations)
+	if err != nil {
+		return ""
+	}
+	cachedPasswordHashes.Store(key, hash)
+	return hash
+}
+
+// loginAttempts 某个账户连续登录失败的记录
+type loginAttempts struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// loginGuard 按账户记录连续登录失败次数，达到上限后锁定一段时间。
+// 不存在的账户同样计数，避免通过是否锁定判断账户是否存在
+type loginGuard struct {
+	maxFailures int
+	lockout     time.Duration
+	now         func() time.Time
+
+	mu        sync.Mutex
+	accounts  map[string]*loginAttempts
+	lastSweep time.Time
+}
+
+// newLoginGuard 创建登录失败计数器，maxFailures 为0时不锁定
+func newLoginGuard(maxFailures int, lockout time.Duration) *loginGuard {
+	return &loginGuard{
+		maxFailures: maxFailures,
+		lockout:     lockout,
+		now:         time.Now,
+		accounts:    make(map[string]*loginAttempts),
+	}
+}
+
+// locked 返回账户剩余的锁定时长
+func (g *loginGuard) locked(account string) (time.Duration, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	a, ok := g.accounts[account]
+	if !ok {
+		return 0, false
+	}
+	remaining := a.lockedUntil.Sub(g.now())
+	return remaining, remaining > 0
+}
+
+// fail 记录一次登录失败，达到上限时锁定账户并重新计数
+func (g *loginGuard) fail(account string) {
+	if g.maxFailures <= 0 {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.now()
+	g.sweep(now)
+	a, ok := g.accounts[account]
+	if !ok {
+		a = &loginAttempts{}
+		g.accounts[account] = a
+	}
+	a.failures++
+	a.lastFailure = now
+	if a.failures >= g.maxFailures {
+		a.lockedUntil = now.Add(g.lockout)
+		a.failures = 0
+	}
+}
+
+// succeed 登录成功后清除失败记录
+func (g *loginGuard) succeed(account string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.accounts, account)
+}
+
+// sweep 每分钟最多一次，清理锁定已过期且一个锁定周期内没有再失败的账户，调用方需持有锁
+func (g *loginGuard) sweep(now time.Time) {
+	if now.Sub(g.lastSweep) < time.Minute {
+		return
+	}
+	g.lastSweep = now
+	for account, a := range g.accounts {
+		if now.After(a.lockedUntil) && now.Sub(a.lastFailure) > g.lockout {
+			delete(g.accounts, account)
+		}
+	}
+}
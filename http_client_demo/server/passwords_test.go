@@ -0,0 +1,115 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestPasswordHash 测试哈希带随机盐、校验正确密码，以及迭代次数变化时提示重新计算
+func TestPasswordHash(t *testing.T) {
+	a, _ := hashPassword("password123", 1000)
+	b, _ := hashPassword("password123", 1000)
+	if a == b || !strings.HasPrefix(a, "pbkdf2-sha256$1000$") {
+		t.Errorf("哈希格式不正确或没有随机盐: %s %s", a, b)
+	}
+
+	if ok, rehash := verifyPassword("password123", a, 1000); !ok || rehash {
+		t.Errorf("正确密码期望通过且不需要重新计算，实际 %v %v", ok, rehash)
+	}
+	if ok, _ := verifyPassword("password124", a, 1000); ok {
+		t.Error("错误密码不应通过")
+	}
+	if ok, rehash := verifyPassword("password123", a, 2000); !ok || !rehash {
+		t.Errorf("迭代次数变化后期望通过且需要重新计算，实际 %v %v", ok, rehash)
+	}
+	for _, encoded := range []string{"", "password123", "md5$1$a$b", "pbkdf2-sha256$x$a$b"} {
+		if ok, _ := verifyPassword("password123", encoded, 1000); ok {
+			t.Errorf("格式错误的哈希 %q 不应通过", encoded)
+		}
+	}
+}
+
+// TestLoginLockout 测试密码只以哈希保存、登录校验存储中的密码，以及连续失败后锁定账户
+func TestLoginLockout(t *testing.T) {
+	config := DefaultServerConfig("0")
+	config.RateLimit = RateLimitConfig{}
+	config.PasswordIterations = 1000
+	config.MaxLoginFailures = 3
+	s := NewSimpleServerWithConfig(config, NewMemoryUserStore())
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	s.logins.now = clock.Now
+
+	req := httptest.NewRequest(http.MethodPost, "/v2/users",
+		strings.NewReader(`{"name":"赵六","email":"ZhaoLiu@example.com","password":"s3cret-pass"}`))
+	req.Header.Set("Authorization", "Bearer your-api-key-here")
+	rec := httptest.NewRecorder()
+	s.mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated || strings.Contains(rec.Body.String(), "s3cret-pass") ||
+		strings.Contains(rec.Body.String(), "password") {
+		t.Fatalf("创建用户的响应不应包含密码: %d %s", rec.Code, rec.Body.String())
+	}
+	stored, _ := s.store.Get(1)
+	if stored.Password != "" || !strings.HasPrefix(stored.PasswordHash, "pbkdf2-sha256$1000$") {
+		t.Fatalf("存储中应只有密码哈希: %+v", stored)
+	}
+
+	login := func(username, password string) *httptest.ResponseRecorder {
+		form := url.Values{"username": {username}, "password": {password}}
+		req := httptest.NewRequest(http.MethodPost, "/v2/login", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		s.mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := login("zhaoliu@example.com", "s3cret-pass"); rec.Code != http.StatusOK {
+		t.Fatalf("正确密码期望状态码 200，实际 %d", rec.Code)
+	}
+	for i := 0; i < 3; i++ {
+		if rec := login("zhaoliu@example.com", "wrong-pass"); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("错误密码期望状态码 401，实际 %d", rec.Code)
+		}
+	}
+	rec = login("zhaoliu@example.com", "s3cret-pass")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "900" {
+		t.Errorf("锁定后期望 429 和 Retry-After 900，实际 %d %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	// 不存在的账户同样会被锁定
+	for i := 0; i < 3; i++ {
+		login("nobody@example.com", "wrong-pass")
+	}
+	if rec := login("nobody@example.com", "wrong-pass"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("不存在的账户期望同样锁定，实际状态码 %d", rec.Code)
+	}
+
+	clock.now = clock.now.Add(15 * time.Minute)
+	if rec := login("zhaoliu@example.com", "s3cret-pass"); rec.Code != http.StatusOK {
+		t.Errorf("锁定结束后期望状态码 200，实际 %d", rec.Code)
+	}
+
+	// 调整迭代次数后，登录成功时用新的迭代次数重新计算哈希，不发布用户事件
+	var events []UserEvent
+	s.events.subscribe(func(e UserEvent) { events = append(events, e) })
+	s.config.PasswordIterations = 2000
+	login("zhaoliu@example.com", "s3cret-pass")
+	if stored, _ := s.store.Get(1); !strings.HasPrefix(stored.PasswordHash, "pbkdf2-sha256$2000$") {
+		t.Errorf("期望重新计算哈希，实际 %s", stored.PasswordHash)
+	}
+	if len(events) != 0 {
+		t.Errorf("重新计算哈希期望不发布事件，实际 %+v", events)
+	}
+
+	// 更新用户时不提交密码则保留原密码
+	update := httptest.NewRequest(http.MethodPut, "/v2/users/1", strings.NewReader(`{"name":"赵六六","email":"zhaoliu@example.com"}`))
+	update.Header.Set("Authorization", "Bearer your-api-key-here")
+	update.Header.Set("If-Match", "*")
+	s.mux.ServeHTTP(httptest.NewRecorder(), update)
+	if rec := login("zhaoliu@example.com", "s3cret-pass"); rec.Code != http.StatusOK {
+		t.Errorf("更新用户后原密码期望仍然有效，实际状态码 %d", rec.Code)
+	}
+}
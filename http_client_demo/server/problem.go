@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"http_client_demo/api"
+)
+
+// Problem RFC 7807 错误详情
+type Problem = api.Problem
+
+// DefaultMaxBodyBytes JSON请求体的默认最大字节数
+const DefaultMaxBodyBytes = 1 << 20
+
+// maxBodyBytes 返回配置的JSON请求体上限，未配置时使用默认值
+func (s *SimpleServer) maxBodyBytes() int64 {
+	if s.config.MaxBodyBytes > 0 {
+		return s.config.MaxBodyBytes
+	}
+	return DefaultMaxBodyBytes
+}
+
+// decodeJSON 严格解析JSON请求体：超过 MaxBodyBytes、包含未知字段或多余内容时返回错误。
+// 请求体为空时返回 io.EOF，允许空请求体的接口可以单独处理
+func (s *SimpleServer) decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	return decodeStrict(http.MaxBytesReader(w, r.Body, s.maxBodyBytes()), v)
+}
+
+// decodeStrict 从 r 中解析恰好一个JSON值，不接受未知字段
+func decodeStrict(r io.Reader, v interface{}) error {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return err
+		}
+		return errors.New("请求体只能包含一个JSON值")
+	}
+	return nil
+}
+
+// sendDecodeError 把 decodeJSON 的错误转换为 problem+json 响应
+func (s *SimpleServer) sendDecodeError(w http.ResponseWriter, r *http.Request, err error) {
+	s.sendProblem(w, r, decodeProblem(err))
+}
+
+// sendValidationError 把字段校验错误转换为 problem+json 响应，每个出错的字段单独列出
+func (s *SimpleServer) sendValidationError(w http.ResponseWriter, r *http.Request, err error) {
+	s.sendProblem(w, r, validationProblem(err))
+}
+
+// decodeProblem 把解析错误转换为错误详情
+func decodeProblem(err error) *Problem {
+	problem := &Problem{Type: api.ProblemMalformed, Title: "请求数据格式错误", Status: http.StatusBadRequest}
+
+	var tooLarge *http.MaxBytesError
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &tooLarge):
+		problem.Type = api.ProblemTooLarge
+		problem.Title = "请求体过大"
+		problem.Status = http.StatusRequestEntityTooLarge
+		problem.Detail = fmt.Sprintf("请求体不能超过 %d 字节", tooLarge.Limit)
+	case errors.Is(err, io.EOF):
+		problem.Detail = "请求体不能为空"
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		problem.Detail = "JSON不完整"
+	case errors.As(err, &syntaxErr):
+		problem.Detail = fmt.Sprintf("JSON语法错误（第 %d 字节）", syntaxErr.Offset)
+	case errors.As(err, &typeErr):
+		problem.InvalidParams = []api.InvalidParam{{
+			Name:   typeErr.Field,
+			Reason: fmt.Sprintf("类型应为 %s，实际为 %s", typeErr.Type, typeErr.Value),
+		}}
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// encoding/json 没有为未知字段定义错误类型，只能从错误信息中取出字段名
+		name := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		problem.InvalidParams = []api.InvalidParam{{Name: name, Reason: "未知字段"}}
+	default:
+		problem.Detail = err.Error()
+	}
+	return problem
+}
+
+// validationProblem 把字段校验错误转换为错误详情
+func validationProblem(err error) *Problem {
+	problem := &Problem{Type: api.ProblemValidation, Title: "请求数据校验失败", Status: http.StatusBadRequest}
+	var fields api.FieldErrors
+	if errors.As(err, &fields) {
+		problem.InvalidParams = fields
+	} else {
+		problem.Detail = err.Error()
+	}
+	return problem
+}
+
+// sendProblem 以 application/problem+json 发送错误详情，回显请求路径和请求ID
+func (s *SimpleServer) sendProblem(w http.ResponseWriter, r *http.Request, problem *Problem) {
+	if problem.Instance == "" {
+		problem.Instance = r.URL.Path
+	}
+	if problem.RequestID == "" {
+		problem.RequestID = w.Header().Get(RequestIDHeader)
+	}
+	w.Header().Set("Content-Type", api.ProblemContentType)
+	w.WriteHeader(problem.Status)
+	json.NewEncoder(w).Encode(problem)
+}
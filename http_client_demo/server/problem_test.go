@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"http_client_demo/api"
+)
+
+// TestProblemResponses 测试请求体格式错误、过大和字段校验失败时返回 problem+json 并列出出错字段
+func TestProblemResponses(t *testing.T) {
+	config := DefaultServerConfig("0")
+	config.RateLimit = RateLimitConfig{}
+	config.MaxBodyBytes = 256
+	s := NewSimpleServerWithConfig(config, NewMemoryUserStore())
+
+	tests := []struct {
+		name    string
+		body    string
+		status  int
+		typ     string
+		field   string
+		message string
+	}{
+		{"语法错误", `{"name":`, http.StatusBadRequest, api.ProblemMalformed, "", "JSON不完整"},
+		{"空请求体", ``, http.StatusBadRequest, api.ProblemMalformed, "", "请求体不能为空"},
+		{"未知字段", `{"name":"赵六","email":"zhaoliu@example.com","nickname":"六"}`, http.StatusBadRequest, api.ProblemMalformed, "nickname", "未知字段"},
+		{"类型错误", `{"name":6,"email":"zhaoliu@example.com"}`, http.StatusBadRequest, api.ProblemMalformed, "name", "类型应为 string"},
+		{"多余内容", `{"name":"赵六","email":"zhaoliu@example.com"} {}`, http.StatusBadRequest, api.ProblemMalformed, "", "只能包含一个JSON值"},
+		{"请求体过大", `{"name":"` + strings.Repeat("六", 100) + `"}`, http.StatusRequestEntityTooLarge, api.ProblemTooLarge, "", "256 字节"},
+		{"邮箱格式", `{"name":"赵六","email":"zhaoliu"}`, http.StatusBadRequest, api.ProblemValidation, "email", "邮箱格式不正确"},
+		{"密码策略", `{"name":"赵六","email":"zhaoliu@example.com","password":"password"}`, http.StatusBadRequest, api.ProblemValidation, "password", "字母和数字"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/v2/users", strings.NewReader(tt.body))
+		req.Header.Set("Authorization", "Bearer your-api-key-here")
+		rec := httptest.NewRecorder()
+		s.mux.ServeHTTP(rec, req)
+
+		var problem Problem
+		json.Unmarshal(rec.Body.Bytes(), &problem)
+		if rec.Code != tt.status || rec.Header().Get("Content-Type") != api.ProblemContentType ||
+			problem.Type != tt.typ || problem.Status != tt.status || problem.Instance != "/v2/users" {
+			t.Errorf("%s: 期望 %d %s，实际 %d %s %s", tt.name, tt.status, tt.typ, rec.Code, rec.Header().Get("Content-Type"), rec.Body.String())
+			continue
+		}
+		reason := problem.Detail
+		if tt.field != "" {
+			reason = problem.Field(tt.field)
+		}
+		if !strings.Contains(reason, tt.message) {
+			t.Errorf("%s: 期望说明包含 %q，实际 %s", tt.name, tt.message, rec.Body.String())
+		}
+	}
+
+	// 多个字段同时出错时全部列出
+	req := httptest.NewRequest(http.MethodPost, "/v2/users", strings.NewReader(`{"name":"","email":"bad","password":"1"}`))
+	req.Header.Set("Authorization", "Bearer your-api-key-here")
+	rec := httptest.NewRecorder()
+	s.mux.ServeHTTP(rec, req)
+	var problem Problem
+	json.Unmarshal(rec.Body.Bytes(), &problem)
+	if len(problem.InvalidParams) != 3 {
+		t.Errorf("期望三个字段出错，实际 %s", rec.Body.String())
+	}
+
+	// 没有配置上限时使用默认值
+	config.MaxBodyBytes = 0
+	s = NewSimpleServerWithConfig(config, NewMemoryUserStore())
+	req = httptest.NewRequest(http.MethodPost, "/v2/users", strings.NewReader(`{"name":"赵六","email":"zhaoliu@example.com"}`))
+	req.Header.Set("Authorization", "Bearer your-api-key-here")
+	rec = httptest.NewRecorder()
+	s.mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Errorf("MaxBodyBytes 为0时期望使用默认上限，实际 %d %s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimitAlgorithm 限流算法
+type RateLimitAlgorithm int
+
+const (
+	// TokenBucket 令牌桶：允许突发 Limit 个请求，之后按 Limit/Window 的速率恢复
+	TokenBucket RateLimitAlgorithm = iota
+	// SlidingWindow 滑动窗口：任意 Window 时长内最多 Limit 个请求，
+	// 用当前窗口和上一个窗口的计数加权估算，不保存每个请求的时间
+	SlidingWindow
+)
+
+// RateLimit 一条限流规则，Limit 为0表示不限流
+type RateLimit struct {
+	Algorithm RateLimitAlgorithm
+	Limit     int
+	Window    time.Duration
+}
+
+// RateLimitConfig 限流配置。每个客户端在每条路由上有独立的额度，
+// 客户端按API Key区分，没有有效Key的请求按来源IP区分
+type RateLimitConfig struct {
+	// Default 没有单独配置的路由使用的规则
+	Default RateLimit
+	// Routes 按路由单独配置的规则，键为不带版本前缀的 "METHOD 路径"，例如 "POST /login"
+	Routes map[string]RateLimit
+	// IdleTTL 客户端超过这个时长没有请求时清理其限流状态，默认10分钟
+	IdleTTL time.Duration
+}
+
+// DefaultRateLimitConfig 返回默认限流配置：一般接口每秒50个请求，
+// 登录每分钟5次以防止暴力破解，上传每分钟10次
+func DefaultRateLimitConfig() RateLimitConfig {
+	return RateLimitConfig{
+		Default: RateLimit{Algorithm: TokenBucket, Limit: 50, Window: time.Second},
+		Routes: map[string]RateLimit{
+			"POST /login":  {Algorithm: SlidingWindow, Limit: 5, Window: time.Minute},
+			"POST /upload": {Algorithm: TokenBucket, Limit: 10, Window: time.Minute},
+		},
+		IdleTTL: 10 * time.Minute,
+	}
+}
+
+// rule 返回路由适用的限流规则
+func (c RateLimitConfig) rule(route string) RateLimit {
+	if rule, ok := c.Routes[route]; ok {
+		return rule
+	}
+	return c.Default
+}
+
+// limiterState 一个客户端在一条路由上的限流状态
+type limiterState struct {
+	// 令牌桶
+	tokens     float64
+	lastRefill time.Time
+
+	// 滑动窗口
+	windowStart time.Time
+	current     int
+	previous    int
+
+	lastSeen time.Time
+}
+
+// rateLimitResult 一次限流判断的结果
+type rateLimitResult struct {
+	allowed    bool
+	limit      int
+	remaining  int
+	reset      time.Duration // 额度完全恢复（令牌桶）或当前窗口结束（滑动窗口）的时间
+	retryAfter time.Duration // 被拒绝时，距离下一个请求可以通过的时间
+}
+
+// rateLimiter 按 路由+客户端 保存限流状态，空闲的状态定期清理
+type rateLimiter struct {
+	mu        sync.Mutex
+	config    RateLimitConfig
+	states    map[string]*limiterState
+	now       func() time.Time
+	lastSweep time.Time
+}
+
+// newRateLimiter 创建限流器
+func newRateLimiter(config RateLimitConfig) *rateLimiter {
+	if config.IdleTTL <= 0 {
+		config.IdleTTL = 10 * time.Minute
+	}
+	return &rateLimiter{
+		config: config,
+		states: make(map[string]*limiterState),
+		now:    time.Now,
+	}
+}
+
+// allow 判断客户端在路由上的请求是否放行，规则不限流时返回 ok=false
+func (l *rateLimiter) allow(route, client string) (result rateLimitResult, ok bool) {
+	rule := l.config.rule(route)
+	if rule.Limit <= 0 || rule.Window <= 0 {
+		return rateLimitResult{}, false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	key := route + "|" + client
+	state, exists := l.states[key]
+	if !exists {
+		state = &limiterState{
+			tokens:      float64(rule.Limit),
+			lastRefill:  now,
+			windowStart: now.Truncate(rule.Window),
+		}
+		l.states[key] = state
+	}
+	state.lastSeen = now
+
+	if rule.Algorithm == SlidingWindow {
+		return state.slidingWindow(rule, now), true
+	}
+	return state.tokenBucket(rule, now), true
+}
+
+// sweep 每分钟最多清理一次空闲的限流状态，调用方需持有锁
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	for key, state := range l.states {
+		if now.Sub(state.lastSeen) > l.config.IdleTTL {
+			delete(l.states, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// size 返回当前保存的限流状态数量
+func (l *rateLimiter) size() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.states)
+}
+
+// tokenBucket 按经过的时间补充令牌，有令牌时消耗一个并放行
+func (st *limiterState) tokenBucket(rule RateLimit, now time.Time) rateLimitResult {
+	capacity := float64(rule.Limit)
+	perToken := rule.Window / time.Duration(rule.Limit)
+
+	elapsed := now.Sub(st.lastRefill)
+	st.tokens = math.Min(capacity, st.tokens+float64(elapsed)/float64(perToken))
+	st.lastRefill = now
+
+	result := rateLimitResult{limit: rule.Limit}
+	if st.tokens >= 1 {
+		st.tokens--
+		result.allowed = true
+	} else {
+		result.retryAfter = time.Duration((1 - st.tokens) * float64(perToken))
+	}
+	result.remaining = int(st.tokens)
+	result.reset = time.Duration((capacity - st.tokens) * float64(perToken))
+	return result
+}
+
+// slidingWindow 用上一个窗口计数按剩余比例加权，加上当前窗口计数估算最近 Window 内的请求数
+func (st *limiterState) slidingWindow(rule RateLimit, now time.Time) rateLimitResult {
+	start := now.Truncate(rule.Window)
+	if !start.Equal(st.windowStart) {
+		if start.Sub(st.windowStart) == rule.Window {
+			st.previous = st.current
+		} else {
+			st.previous = 0
+		}
+		st.current = 0
+		st.windowStart = start
+	}
+
+	windowEnd := start.Add(rule.Window)
+	weight := 1 - float64(now.Sub(start))/float64(rule.Window)
+	estimate := float64(st.previous)*weight + float64(st.current)
+
+	result := rateLimitResult{limit: rule.Limit, reset: windowEnd.Sub(now)}
+	if estimate+1 <= float64(rule.Limit) {
+		st.current++
+		result.allowed = true
+		result.remaining = int(float64(rule.Limit) - estimate - 1)
+		return result
+	}
+
+	// 当前窗口已用完额度时要等到下个窗口，否则等上一个窗口的权重降到足够低
+	if st.current+1 > rule.Limit || st.previous == 0 {
+		result.retryAfter = result.reset
+	} else {
+		needed := float64(rule.Limit-st.current-1) / float64(st.previous)
+		at := start.Add(time.Duration((1 - needed) * float64(rule.Window)))
+		result.retryAfter = at.Sub(now)
+	}
+	return result
+}
+
+// rateLimitClient 返回限流使用的客户端标识：有效的API Key按Key ID区分，
+// 登录会话按用户区分，同一用户的多个会话共用额度，
+// 否则按来源IP区分，避免随意更换无效Key绕过限流
+func (s *SimpleServer) rateLimitClient(r *http.Request) string {
+	if token, ok := bearerToken(r.Header.Get("Authorization")); ok {
+		if p, err := s.authenticate(token); err == nil {
+			if p.Session != nil {
+				return "user:" + strconv.Itoa(p.Session.UserID)
+			}
+			return "key:" + p.Key.ID
+		}
+	}
+	return "ip:" + clientIP(r)
+}
+
+// clientIP 返回请求的来源IP。服务器没有部署在可信代理之后，不读取 X-Forwarded-For
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// rateLimited 按路由的限流规则限制请求，超出额度时返回429
+func (s *SimpleServer) rateLimited(route string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		result, limited := s.limiter.allow(route, s.rateLimitClient(r))
+		if !limited {
+			next(w, r)
+			return
+		}
+
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(result.limit))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(result.remaining))
+		w.Header().Set("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(result.reset)))
+		if !result.allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(max(1, ceilSeconds(result.retryAfter))))
+			s.sendResponse(w, http.StatusTooManyRequests, APIResponse{
+				Success: false,
+				Message: "请求过于频繁，请稍后重试",
+			})
+			return
+		}
+		next(w, r)
+	}
+}
+
+// ceilSeconds 把时长向上取整为秒
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
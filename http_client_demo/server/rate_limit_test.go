@@ -0,0 +1,159 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClock 测试用的可控时钟
+type fakeClock struct{ now time.Time }
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+// TestTokenBucket 测试令牌桶允许突发并按速率恢复
+func TestTokenBucket(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	l := newRateLimiter(RateLimitConfig{
+		Default: RateLimit{Algorithm: TokenBucket, Limit: 3, Window: 3 * time.Second},
+	})
+	l.now = clock.Now
+
+	for i := 0; i < 3; i++ {
+		if result, _ := l.allow("GET /users/{id}", "a"); !result.allowed || result.remaining != 2-i {
+			t.Fatalf("第 %d 个请求应放行且剩余 %d，实际 %+v", i+1, 2-i, result)
+		}
+	}
+	result, _ := l.allow("GET /users/{id}", "a")
+	if result.allowed {
+		t.Fatal("额度用完后应拒绝")
+	}
+	if result.retryAfter != time.Second {
+		t.Errorf("期望1秒后重试，实际 %v", result.retryAfter)
+	}
+
+	// 其他客户端不受影响
+	if result, _ := l.allow("GET /users/{id}", "b"); !result.allowed {
+		t.Error("不同客户端的额度应相互独立")
+	}
+
+	clock.now = clock.now.Add(time.Second)
+	if result, _ := l.allow("GET /users/{id}", "a"); !result.allowed {
+		t.Error("恢复一个令牌后应放行")
+	}
+}
+
+// TestSlidingWindow 测试滑动窗口按上一个窗口的权重限流
+func TestSlidingWindow(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	l := newRateLimiter(RateLimitConfig{
+		Routes: map[string]RateLimit{
+			"POST /login": {Algorithm: SlidingWindow, Limit: 4, Window: time.Minute},
+		},
+	})
+	l.now = clock.Now
+
+	if _, ok := l.allow("GET /users/{id}", "a"); ok {
+		t.Error("没有配置规则的路由不应限流")
+	}
+
+	for i := 0; i < 4; i++ {
+		if result, _ := l.allow("POST /login", "a"); !result.allowed {
+			t.Fatalf("第 %d 个请求应放行", i+1)
+		}
+	}
+	if result, _ := l.allow("POST /login", "a"); result.allowed {
+		t.Fatal("窗口内额度用完后应拒绝")
+	}
+
+	// 进入下一个窗口的前半段，上一个窗口还占一半权重：4*0.5=2，还能再放行2个
+	clock.now = clock.now.Add(90 * time.Second)
+	for i := 0; i < 2; i++ {
+		if result, _ := l.allow("POST /login", "a"); !result.allowed {
+			t.Fatalf("下一个窗口第 %d 个请求应放行", i+1)
+		}
+	}
+	result, _ := l.allow("POST /login", "a")
+	if result.allowed {
+		t.Fatal("加权估算超过额度后应拒绝")
+	}
+	// 需要上一个窗口的权重降到 (4-2-1)/4，即窗口开始后45秒，距现在15秒
+	if result.retryAfter != 15*time.Second {
+		t.Errorf("期望15秒后重试，实际 %v", result.retryAfter)
+	}
+}
+
+// TestRateLimiterEvictsIdleState 测试空闲的限流状态会被清理
+func TestRateLimiterEvictsIdleState(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	l := newRateLimiter(RateLimitConfig{
+		Default: RateLimit{Algorithm: TokenBucket, Limit: 10, Window: time.Second},
+		IdleTTL: 5 * time.Minute,
+	})
+	l.now = clock.Now
+
+	for _, client := range []string{"a", "b", "c"} {
+		l.allow("GET /users/{id}", client)
+	}
+	clock.now = clock.now.Add(3 * time.Minute)
+	l.allow("GET /users/{id}", "a")
+
+	clock.now = clock.now.Add(3 * time.Minute)
+	l.allow("GET /users/{id}", "d")
+	if n := l.size(); n != 2 {
+		t.Errorf("期望清理空闲客户端后剩 2 个状态，实际 %d", n)
+	}
+}
+
+// TestRateLimitMiddleware 测试超出额度时返回429和限流响应头
+func TestRateLimitMiddleware(t *testing.T) {
+	config := DefaultServerConfig("0")
+	config.RateLimit = RateLimitConfig{
+		Default: RateLimit{Algorithm: TokenBucket, Limit: 2, Window: time.Minute},
+	}
+	s := NewSimpleServerWithConfig(config, NewMemoryUserStore())
+	s.initTestData()
+
+	send := func(path, auth, remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", auth)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		s.mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// 同一个Key从不同IP、不同版本访问共用额度
+	send("/v1/users/1", "Bearer your-api-key-here", "10.0.0.1:1000")
+	rec := send("/v2/users/1", "Bearer your-api-key-here", "10.0.0.2:1000")
+	if rec.Code != http.StatusOK || rec.Header().Get("X-RateLimit-Remaining") != "0" {
+		t.Fatalf("期望放行且剩余 0，实际状态码 %d，剩余 %q", rec.Code, rec.Header().Get("X-RateLimit-Remaining"))
+	}
+	if rec.Header().Get("X-RateLimit-Limit") != "2" || rec.Header().Get("X-RateLimit-Reset") != "60" {
+		t.Errorf("限流响应头不正确: %v", rec.Header())
+	}
+
+	rec = send("/v2/users/1", "Bearer your-api-key-here", "10.0.0.3:1000")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("期望状态码 429，实际 %d", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") != "30" {
+		t.Errorf("期望 Retry-After 为 30，实际 %q", rec.Header().Get("Retry-After"))
+	}
+	if !strings.Contains(rec.Header().Get("Content-Type"), "application/json") {
+		t.Error("429响应应为JSON")
+	}
+
+	// 无效Key按IP限流，更换无效Key不能绕过
+	for i, auth := range []string{"Bearer bad-1", "Bearer bad-2", "Bearer bad-3"} {
+		rec := send("/v2/users/1", auth, "10.0.0.9:1000")
+		if i < 2 && rec.Code != http.StatusUnauthorized {
+			t.Errorf("第 %d 个无效Key请求期望状态码 401，实际 %d", i+1, rec.Code)
+		}
+		if i == 2 && rec.Code != http.StatusTooManyRequests {
+			t.Errorf("同一IP超出额度后期望状态码 429，实际 %d", rec.Code)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"http_client_demo/api"
+)
+
+// Role 用户角色，与 api 包共用同一个定义
+type Role = api.Role
+
+// rolePolicy 每个角色拥有的权限范围。每条路由需要的权限由 routeSpec.Scope 声明，
+// 两张表合起来决定登录用户能否调用某条路由
+var rolePolicy = map[Role][]Scope{
+	api.RoleAdmin:  {ScopeUsersRead, ScopeUsersWrite, ScopeUpload, ScopeAdmin},
+	api.RoleEditor: {ScopeUsersRead, ScopeUsersWrite, ScopeUpload},
+	api.RoleViewer: {ScopeUsersRead},
+}
+
+// roleScopes 返回角色拥有的权限，没有角色的旧数据按 viewer 处理
+func roleScopes(role Role) []Scope {
+	if role == "" {
+		role = api.RoleViewer
+	}
+	return rolePolicy[role]
+}
+
+// rolesWithScope 按权限从高到低返回拥有指定权限的角色
+func rolesWithScope(scope Scope) []Role {
+	var roles []Role
+	for _, role := range api.Roles {
+		if slices.Contains(rolePolicy[role], scope) {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// forbiddenReason 403响应中说明缺少的权限，登录用户同时给出哪些角色可以调用
+func forbiddenReason(p *principal, scope Scope) string {
+	if p.Session == nil {
+		return fmt.Sprintf("API Key缺少权限: %s", scope)
+	}
+	roles := make([]string, 0, len(api.Roles))
+	for _, role := range rolesWithScope(scope) {
+		roles = append(roles, string(role))
+	}
+	return fmt.Sprintf("角色 %s 缺少权限 %s，需要以下角色之一: %s", p.Role, scope, strings.Join(roles, "、"))
+}
+
+// roleRank 角色的权限等级，数值越大权限越高，没有角色的旧数据按 viewer 处理
+func roleRank(role Role) int {
+	if role == "" {
+		role = api.RoleViewer
+	}
+	return len(api.Roles) - slices.Index(api.Roles, role)
+}
+
+// effectiveRole 调用方在修改用户时的角色：登录会话为用户的角色，
+// API Key 拥有 admin 权限时视为管理员，否则视为编辑
+func (p *principal) effectiveRole() Role {
+	if p.Session != nil {
+		return p.Role
+	}
+	if p.HasScope(ScopeAdmin) {
+		return api.RoleAdmin
+	}
+	return api.RoleEditor
+}
+
+// userChangeForbidden 检查调用方能否修改或删除目标用户，返回拒绝原因，允许时返回空字符串。
+// 不能修改权限高于自己的用户；修改其他用户的密码需要管理员角色
+func userChangeForbidden(p *principal, target *User, passwordChanged bool) string {
+	role := p.effectiveRole()
+	if roleRank(target.Role) > roleRank(role) {
+		return fmt.Sprintf("角色 %s 不能修改或删除 %s 用户", role, target.Role)
+	}
+	self := p.Session != nil && p.Session.UserID == target.ID
+	if passwordChanged && !self && role != api.RoleAdmin {
+		return "只能修改自己的密码，修改其他用户的密码需要 admin 角色"
+	}
+	return ""
+}
+
+// setUserRoleRequest 修改用户角色的请求体
+type setUserRoleRequest struct {
+	Role Role `json:"role"`
+}
+
+// setUserRole 管理员修改用户角色，角色在每次请求时读取，对该用户已登录的会话立即生效
+func (s *SimpleServer) setUserRole(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		s.sendResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "无效的用户ID",
+		})
+		return
+	}
+
+	var req setUserRoleRequest
+	if err := s.decodeJSON(w, r, &req); err != nil {
+		s.sendDecodeError(w, r, err)
+		return
+	}
+	if !req.Role.Valid() {
+		s.sendValidationError(w, r, api.FieldErrors{{Name: "role", Reason: fmt.Sprintf("未知角色: %s", req.Role)}})
+		return
+	}
+
+	user, err := s.store.Get(id)
+	if err != nil {
+		s.sendStoreError(w, err)
+		return
+	}
+	// 只修改角色，基于刚读取的版本写回，期间用户被修改时返回412
+	before := *user
+	user.Role = req.Role
+	updated, err := s.store.Update(user)
+	if err != nil {
+		s.sendStoreError(w, err)
+		return
+	}
+	s.audit(r, AuditUserRole, userResource(id), &before, updated)
+
+	w.Header().Set("ETag", api.UserETag(updated.Version))
+	s.sendResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "修改用户角色成功",
+		Data:    updated,
+	})
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"http_client_demo/api"
+)
+
+// newRoleTestServer 创建关闭限流的服务器，依次创建 admin、editor、viewer 三个用户，ID为1、2、3
+func newRoleTestServer(t *testing.T) *SimpleServer {
+	t.Helper()
+	config := DefaultServerConfig("0")
+	config.RateLimit = RateLimitConfig{}
+	s := NewSimpleServerWithConfig(config, NewMemoryUserStore())
+	for _, role := range api.Roles {
+		if _, err := s.store.Create(&User{Name: string(role), Email: string(role) + "@example.com", Role: role}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+// sessionFor 为用户创建会话并返回令牌
+func sessionFor(t *testing.T, s *SimpleServer, userID int) string {
+	t.Helper()
+	_, token, err := s.sessions.create(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// TestRolePolicyMatrix 测试每条需要权限的路由对每个角色、API Key和匿名请求的授权结果
+func TestRolePolicyMatrix(t *testing.T) {
+	s := newRoleTestServer(t)
+	userIDs := map[Role]int{api.RoleAdmin: 1, api.RoleEditor: 2, api.RoleViewer: 3}
+
+	// 每条路由（不带版本前缀）最低需要的角色
+	minRole := map[string]Role{
+		"POST /users":                                 api.RoleEditor,
+		"GET /users":                                  api.RoleViewer,
+		"GET /events":                                 api.RoleViewer,
+		"POST /users:import":                          api.RoleEditor,
+		"GET /users:export":                           api.RoleViewer,
+		"GET /users/{id}":                             api.RoleViewer,
+		"PUT /users/{id}":                             api.RoleEditor,
+		"DELETE /users/{id}":                          api.RoleEditor,
+		"POST /users/encrypted":                       api.RoleEditor,
+		"POST /upload":                                api.RoleEditor,
+		"GET /files":                                  api.RoleEditor,
+		"GET /files/{id}":                             api.RoleEditor,
+		"DELETE /files/{id}":                          api.RoleEditor,
+		"POST /admin/keys":                            api.RoleAdmin,
+		"GET /admin/keys":                             api.RoleAdmin,
+		"POST /admin/keys/{id}/rotate":                api.RoleAdmin,
+		"DELETE /admin/keys/{id}":                     api.RoleAdmin,
+		"PUT /admin/users/{id}/role":                  api.RoleAdmin,
+		"POST /admin/webhooks":                        api.RoleAdmin,
+		"GET /admin/webhooks":                         api.RoleAdmin,
+		"DELETE /admin/webhooks/{id}":                 api.RoleAdmin,
+		"GET /admin/webhook-deliveries":               api.RoleAdmin,
+		"GET /admin/webhook-dead-letters":             api.RoleAdmin,
+		"POST /admin/webhook-dead-letters/{id}/retry": api.RoleAdmin,
+		"GET /audit":                                  api.RoleAdmin,
+	}
+	rank := map[Role]int{api.RoleViewer: 0, api.RoleEditor: 1, api.RoleAdmin: 2}
+	params := regexp.MustCompile(`\{[^}]+\}`)
+
+	// 事件流是长连接，用已取消的上下文让它在检查权限后立即返回
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	do := func(method, path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if strings.HasSuffix(path, api.PathEvents) {
+			req = req.WithContext(cancelled)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		s.mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for _, route := range s.routes {
+		if route.Scope == "" {
+			continue
+		}
+		key := route.Method + " " + strings.TrimPrefix(route.Pattern, "/"+route.Tag)
+		want, ok := minRole[key]
+		if !ok {
+			t.Errorf("路由 %s %s 没有出现在权限表中", route.Method, route.Pattern)
+			continue
+		}
+		path := params.ReplaceAllString(route.Pattern, "1")
+
+		for _, role := range api.Roles {
+			// 每次使用新会话，注销等请求不会影响后续检查
+			rec := do(route.Method, path, sessionFor(t, s, userIDs[role]))
+			if rank[role] >= rank[want] {
+				if rec.Code == http.StatusUnauthorized || rec.Code == http.StatusForbidden {
+					t.Errorf("%s %s: 角色 %s 期望允许，实际 %d %s", route.Method, route.Pattern, role, rec.Code, rec.Body.String())
+				}
+			} else if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "角色 "+string(role)+" 缺少权限 "+string(route.Scope)) {
+				t.Errorf("%s %s: 角色 %s 期望 403 并说明原因，实际 %d %s", route.Method, route.Pattern, role, rec.Code, rec.Body.String())
+			}
+		}
+
+		// 演示API Key拥有 users:read、users:write、upload，相当于 editor
+		rec := do(route.Method, path, "your-api-key-here")
+		if want == api.RoleAdmin {
+			if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "API Key缺少权限") {
+				t.Errorf("%s %s: 演示API Key期望 403，实际 %d", route.Method, route.Pattern, rec.Code)
+			}
+		} else if rec.Code == http.StatusUnauthorized || rec.Code == http.StatusForbidden {
+			t.Errorf("%s %s: 演示API Key期望允许，实际 %d", route.Method, route.Pattern, rec.Code)
+		}
+
+		if rec := do(route.Method, path, ""); rec.Code != http.StatusUnauthorized {
+			t.Errorf("%s %s: 匿名请求期望 401，实际 %d", route.Method, route.Pattern, rec.Code)
+		}
+	}
+}
+
+// TestSetUserRole 测试管理员修改角色立即对已有会话生效，更新用户和创建用户不能指定角色
+func TestSetUserRole(t *testing.T) {
+	s := newRoleTestServer(t)
+	admin := sessionFor(t, s, 1)
+	viewer := sessionFor(t, s, 3)
+
+	do := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("If-Match", "*")
+		rec := httptest.NewRecorder()
+		s.mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// 通过更新用户提升自己的角色不生效
+	do(http.MethodPut, "/v2/users/3", "your-api-key-here", `{"name":"viewer","email":"viewer@example.com","role":"admin"}`)
+	if user, _ := s.store.Get(3); user.Role != api.RoleViewer {
+		t.Errorf("更新用户不应修改角色，实际 %s", user.Role)
+	}
+	rec := do(http.MethodPost, "/v2/users", "your-api-key-here", `{"name":"新用户","email":"new@example.com","role":"admin"}`)
+	if rec.Code != http.StatusCreated || !strings.Contains(rec.Body.String(), `"role":"viewer"`) {
+		t.Errorf("新用户期望为 viewer，实际 %d %s", rec.Code, rec.Body.String())
+	}
+
+	if rec := do(http.MethodDelete, "/v2/users/4", viewer, ""); rec.Code != http.StatusForbidden {
+		t.Fatalf("viewer 删除用户期望 403，实际 %d", rec.Code)
+	}
+	if rec := do(http.MethodPut, "/admin/users/3/role", viewer, `{"role":"admin"}`); rec.Code != http.StatusForbidden {
+		t.Errorf("viewer 修改角色期望 403，实际 %d", rec.Code)
+	}
+	if rec := do(http.MethodPut, "/admin/users/3/role", admin, `{"role":"owner"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("未知角色期望 400，实际 %d", rec.Code)
+	}
+	if rec := do(http.MethodPut, "/admin/users/99/role", admin, `{"role":"editor"}`); rec.Code != http.StatusNotFound {
+		t.Errorf("不存在的用户期望 404，实际 %d", rec.Code)
+	}
+
+	rec = do(http.MethodPut, "/admin/users/3/role", admin, `{"role":"editor"}`)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"role":"editor"`) {
+		t.Fatalf("修改角色期望 200，实际 %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := do(http.MethodDelete, "/v2/users/4", viewer, ""); rec.Code != http.StatusOK {
+		t.Errorf("改为 editor 后已有会话期望可以删除用户，实际 %d %s", rec.Code, rec.Body.String())
+	}
+
+	// editor 不能修改或删除 admin，也不能修改其他用户的密码；修改自己的密码和 admin 修改密码不受限制
+	editor := sessionFor(t, s, 2)
+	for _, tt := range []struct {
+		token, method, path, body string
+		status                    int
+	}{
+		{editor, http.MethodPut, "/v2/users/1", `{"name":"admin","email":"admin@example.com","password":"hacked1234"}`, http.StatusForbidden},
+		{editor, http.MethodPut, "/v2/users/1", `{"name":"admin","email":"admin@example.com"}`, http.StatusForbidden},
+		{editor, http.MethodDelete, "/v2/users/1", "", http.StatusForbidden},
+		{editor, http.MethodPut, "/v2/users/3", `{"name":"viewer","email":"viewer@example.com","password":"changed123"}`, http.StatusForbidden},
+		{editor, http.MethodPut, "/v2/users/3", `{"name":"viewer2","email":"viewer@example.com"}`, http.StatusOK},
+		{admin, http.MethodPut, "/v2/users/3", `{"name":"viewer","email":"viewer@example.com","password":"changed123"}`, http.StatusOK},
+		// 修改自己的密码后会话失效，放在最后
+		{editor, http.MethodPut, "/v2/users/2", `{"name":"editor","email":"editor@example.com","password":"changed123"}`, http.StatusOK},
+	} {
+		if rec := do(tt.method, tt.path, tt.token, tt.body); rec.Code != tt.status {
+			t.Errorf("%s %s %s: 期望 %d，实际 %d %s", tt.method, tt.path, tt.body, tt.status, rec.Code, rec.Body.String())
+		}
+	}
+	if user, _ := s.store.Get(1); user.Name != "admin" {
+		t.Errorf("admin 用户不应被 editor 修改，实际 %+v", user)
+	}
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"http_client_demo/api"
+)
+
+// standardMethods 为每个路径注册405响应时考虑的HTTP方法
+var standardMethods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+	http.MethodOptions,
+}
+
+// routeSpec 一条路由的处理函数和元数据。认证和幂等中间件按元数据在注册时添加，
+// /openapi.json 也由同一份元数据生成，文档和实际行为不会各自漂移
+type routeSpec struct {
+	Summary string
+	// Scope 需要的权限，为空表示不需要认证。API Key按自身的权限范围检查，
+	// 登录会话按用户角色在 rolePolicy 中的权限检查
+	Scope Scope
+	// Session 为true时只接受登录会话，用于和当前用户相关的接口
+	Session bool
+	// Idempotent 为true时支持 Idempotency-Key 请求头，重复请求返回第一次的响应
+	Idempotent bool
+	// Request 请求体类型的零值，nil 表示没有请求体；RequestType 为空时按JSON处理
+	Request     interface{}
+	RequestType string
+	// Response 成功响应中 data 字段类型的零值，nil 表示没有数据
+	Response interface{}
+	// RawResponse 不使用 APIResponse 包装的响应的媒体类型，例如文件下载
+	RawResponse string
+	// Status 可能返回的状态码，第一个是成功状态码，401、403、429 按认证和限流自动补充
+	Status []int
+	// Params 查询参数和请求头，路径参数从路由模式中提取
+	Params []routeParam
+
+	Handler http.HandlerFunc
+}
+
+// routeParam 查询参数或请求头
+type routeParam struct {
+	In          string // query / header
+	Name        string
+	Description string
+	Required    bool
+}
+
+// ifMatchParam 更新和删除用户需要的 If-Match 请求头
+var ifMatchParam = routeParam{"header", "If-Match", "GET 返回的 ETag，\"*\" 表示不检查版本", true}
+
+// routeHandlers 返回 api 包声明的每条路由对应的处理函数和元数据，键为 "METHOD 路径"
+func (s *SimpleServer) routeHandlers() map[string]routeSpec {
+	return map[string]routeSpec{
+		"POST " + api.PathUsers: {
+			Summary: "创建用户", Scope: ScopeUsersWrite, Idempotent: true,
+			Request: User{}, Response: User{},
+			Status:  []int{http.StatusCreated, http.StatusBadRequest, http.StatusConflict},
+			Handler: s.createUser,
+		},
+		"GET " + api.PathUsers: {
+			Summary: "搜索用户", Scope: ScopeUsersRead,
+			Response: []User{},
+			Status:   []int{http.StatusOK, http.StatusBadRequest},
+			Params: []routeParam{
+				{"query", "q", "用户名或邮箱中包含的子串", false},
+				{"query", "email", "邮箱完全匹配", false},
+				{"query", "name_prefix", "用户名前缀", false},
+				{"query", "sort", "id、-id、name、-name", false},
+				{"query", "fields", "逗号分隔的返回字段：id、name、email", false},
+			},
+			Handler: s.searchUsers,
+		},
+		"GET " + api.PathUser: {
+			Summary: "获取用户", Scope: ScopeUsersRead,
+			Response: User{},
+			Status:   []int{http.StatusOK, http.StatusBadRequest, http.StatusNotFound},
+			Handler:  s.getUser,
+		},
+		"PUT " + api.PathUser: {
+			Summary: "更新用户", Scope: ScopeUsersWrite,
+			Request: User{}, Response: User{},
+			Status: []int{http.StatusOK, http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusConflict,
+				http.StatusPreconditionFailed, http.StatusPreconditionRequired},
+			Params:  []routeParam{ifMatchParam},
+			Handler: s.updateUser,
+		},
+		"DELETE " + api.PathUser: {
+			Summary: "删除用户", Scope: ScopeUsersWrite,
+			Status: []int{http.StatusOK, http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound,
+				http.StatusPreconditionFailed, http.StatusPreconditionRequired},
+			Params:  []routeParam{ifMatchParam},
+			Handler: s.deleteUser,
+		},
+		"POST " + api.PathEncryptedUsers: {
+			Summary: "创建加密用户", Scope: ScopeUsersWrite, Idempotent: true,
+			Request: encryptedUserRequest{}, Response: User{},
+			Status:  []int{http.StatusCreated, http.StatusBadRequest, http.StatusConflict},
+			Handler: s.handleEncryptedUser,
+		},
+		"POST " + api.PathLogin: {
+			Summary: "表单登录",
+			Request: loginForm{}, RequestType: "application/x-www-form-urlencoded",
+			Response: "",
+			Status:   []int{http.StatusOK, http.StatusBadRequest, http.StatusUnauthorized},
+			Handler:  s.handleLogin,
+		},
+		"POST " + api.PathLogout: {
+			Summary: "注销当前会话", Session: true,
+			Status:  []int{http.StatusOK},
+			Handler: s.handleLogout,
+		},
+		"GET " + api.PathMe: {
+			Summary: "获取当前登录用户", Session: true,
+			Response: User{},
+			Status:   []int{http.StatusOK, http.StatusNotFound},
+			Handler:  s.handleMe,
+		},
+		"GET " + api.PathEvents: {
+			Summary: "订阅用户变更事件（Server-Sent Events）", Scope: ScopeUsersRead,
+			RawResponse: "text/event-stream",
+			Status:      []int{http.StatusOK, http.StatusBadRequest, http.StatusServiceUnavailable},
+			Params:      []routeParam{{"header", "Last-Event-ID", "断线重连时最后收到的事件ID，服务端补发之后的事件", false}},
+			Handler:     s.handleEvents,
+		},
+		"POST " + api.PathUsersImport: {
+			Summary: "批量导入用户（NDJSON），逐行返回结果，最后一行为汇总", Scope: ScopeUsersWrite,
+			Request: User{}, RequestType: api.NDJSONContentType,
+			RawResponse: api.NDJSONContentType,
+			Status:      []int{http.StatusOK, http.StatusBadRequest},
+			Params:      []routeParam{{"query", "mode", "best-effort（默认，跳过出错的行）或 atomic（任何一行出错都不写入）", false}},
+			Handler:     s.importUsers,
+		},
+		"GET " + api.PathUsersExport: {
+			Summary: "导出所有用户（NDJSON或CSV）", Scope: ScopeUsersRead,
+			RawResponse: api.NDJSONContentType,
+			Status:      []int{http.StatusOK, http.StatusBadRequest},
+			Params:      []routeParam{{"query", "format", "ndjson（默认）或 csv，省略时按 Accept 头选择", false}},
+			Handler:     s.exportUsers,
+		},
+		// 上传按内容寻址，重复上传得到同一个文件，不需要幂等中间件缓存请求体
+		"POST " + api.PathUpload: {
+			Summary: "上传文件", Scope: ScopeUpload,
+			Request: []byte{}, RequestType: "application/octet-stream",
+			Response: FileInfo{},
+			Status:   []int{http.StatusOK, http.StatusBadRequest, http.StatusRequestEntityTooLarge},
+			Params:   []routeParam{{"header", "X-Filename", "文件名，只保留最后一段", false}},
+			Handler:  s.handleUpload,
+		},
+		"GET " + api.PathFiles: {
+			Summary: "列出文件", Scope: ScopeUpload,
+			Response: []FileInfo{},
+			Status:   []int{http.StatusOK},
+			Handler:  s.listFiles,
+		},
+		"GET " + api.PathFile: {
+			Summary: "下载文件", Scope: ScopeUpload,
+			RawResponse: "application/octet-stream",
+			Status:      []int{http.StatusOK, http.StatusPartialContent, http.StatusNotFound},
+			Params:      []routeParam{{"header", "Range", "按字节范围下载，例如 bytes=0-99", false}},
+			Handler:     s.downloadFile,
+		},
+		"DELETE " + api.PathFile: {
+			Summary: "删除文件", Scope: ScopeUpload,
+			Status:  []int{http.StatusOK, http.StatusNotFound},
+			Handler: s.deleteFile,
+		},
+	}
+}
+
+// serverRoute 服务端自有的路由，不属于 api 包的客户端契约，也不带版本前缀
+type serverRoute struct {
+	Method string
+	Path   string
+	routeSpec
+}
+
+// serverRoutes 返回健康检查、指标、文档和管理接口等服务端自有路由
+func (s *SimpleServer) serverRoutes() []serverRoute {
+	return []serverRoute{
+		{"GET", "/healthz", routeSpec{
+			Summary: "存活检查", Response: map[string]string{},
+			Status: []int{http.StatusOK}, Handler: s.handleHealthz,
+		}},
+		{"GET", "/readyz", routeSpec{
+			Summary: "就绪检查", Response: map[string]string{},
+			Status: []int{http.StatusOK, http.StatusServiceUnavailable}, Handler: s.handleReadyz,
+		}},
+		{"GET", "/metrics", routeSpec{
+			Summary: "Prometheus 指标", RawResponse: "text/plain",
+			Status: []int{http.StatusOK}, Handler: s.handleMetrics,
+		}},
+		{"GET", "/openapi.json", routeSpec{
+			Summary: "OpenAPI 文档", RawResponse: "application/json",
+			Status: []int{http.StatusOK}, Handler: s.handleOpenAPI,
+		}},
+		{"POST", "/admin/keys", routeSpec{
+			Summary: "创建API Key", Scope: ScopeAdmin,
+			Request: createAPIKeyRequest{}, Response: apiKeyWithSecret{},
+			Status:  []int{http.StatusCreated, http.StatusBadRequest},
+			Handler: s.createAPIKey,
+		}},
+		{"GET", "/admin/keys", routeSpec{
+			Summary: "列出API Key", Scope: ScopeAdmin,
+			Response: []*APIKey{},
+			Status:   []int{http.StatusOK},
+			Handler:  s.listAPIKeys,
+		}},
+		{"POST", "/admin/keys/{id}/rotate", routeSpec{
+			Summary: "轮换API Key", Scope: ScopeAdmin,
+			Request: rotateAPIKeyRequest{}, Response: apiKeyWithSecret{},
+			Status:  []int{http.StatusCreated, http.StatusBadRequest, http.StatusNotFound, http.StatusConflict},
+			Handler: s.rotateAPIKey,
+		}},
+		{"PUT", "/admin/users/{id}/role", routeSpec{
+			Summary: "修改用户角色", Scope: ScopeAdmin,
+			Request: setUserRoleRequest{}, Response: User{},
+			Status:  []int{http.StatusOK, http.StatusBadRequest, http.StatusNotFound, http.StatusPreconditionFailed},
+			Handler: s.setUserRole,
+		}},
+		{"POST", "/admin/webhooks", routeSpec{
+			Summary: "创建webhook", Scope: ScopeAdmin,
+			Request: createWebhookRequest{}, Response: Webhook{},
+			Status:  []int{http.StatusCreated, http.StatusBadRequest},
+			Handler: s.createWebhook,
+		}},
+		{"GET", "/admin/webhooks", routeSpec{
+			Summary: "列出webhook", Scope: ScopeAdmin,
+			Response: []*Webhook{},
+			Status:   []int{http.StatusOK},
+			Handler:  s.listWebhooks,
+		}},
+		{"DELETE", "/admin/webhooks/{id}", routeSpec{
+			Summary: "删除webhook", Scope: ScopeAdmin,
+			Status:  []int{http.StatusOK, http.StatusNotFound},
+			Handler: s.deleteWebhook,
+		}},
+		{"GET", "/admin/webhook-deliveries", routeSpec{
+			Summary: "查询webhook投递日志", Scope: ScopeAdmin,
+			Response: []webhookDelivery{},
+			Status:   []int{http.StatusOK},
+			Params: []routeParam{
+				{"query", "webhook_id", "只返回该webhook的投递", false},
+				{"query", "status", "pending、retrying、delivered 或 dead", false},
+			},
+			Handler: s.listWebhookDeliveries,
+		}},
+		{"GET", "/admin/webhook-dead-letters", routeSpec{
+			Summary: "查询webhook死信列表", Scope: ScopeAdmin,
+			Response: []webhookDelivery{},
+			Status:   []int{http.StatusOK},
+			Handler:  s.listWebhookDeadLetters,
+		}},
+		{"POST", "/admin/webhook-dead-letters/{id}/retry", routeSpec{
+			Summary: "重新投递死信", Scope: ScopeAdmin,
+			Response: webhookDelivery{},
+			Status:   []int{http.StatusAccepted, http.StatusNotFound},
+			Handler:  s.redeliverWebhook,
+		}},
+		{"DELETE", "/admin/keys/{id}", routeSpec{
+			Summary: "吊销API Key", Scope: ScopeAdmin,
+			Response: APIKey{},
+			Status:   []int{http.StatusOK, http.StatusNotFound, http.StatusConflict},
+			Handler:  s.revokeAPIKey,
+		}},
+		{"GET", "/audit", routeSpec{
+			Summary: "查询审计日志", Scope: ScopeAdmin,
+			Response: []AuditEntry{},
+			Status:   []int{http.StatusOK, http.StatusBadRequest, http.StatusInternalServerError},
+			Params: []routeParam{
+				{"query", "actor", "操作者，例如 key:<Key ID> 或 user:<用户ID>", false},
+				{"query", "resource", "资源名，例如 users/5；以 / 结尾时按前缀匹配，例如 users/", false},
+				{"query", "action", "操作类型，例如 user.update", false},
+				{"query", "since", "起始时间（包含），RFC 3339", false},
+				{"query", "until", "结束时间（不包含），RFC 3339", false},
+				{"query", "after", "只返回序号大于该值的记录，用于分页", false},
+				{"query", "limit", "最多返回的记录数，默认100，最大1000", false},
+			},
+			Handler: s.listAudit,
+		}},
+	}
+}
+
+// registeredRoute 已注册的路由，按注册顺序保存，用于生成文档
+type registeredRoute struct {
+	Method  string
+	Pattern string
+	// Tag 文档分组：API版本或 server
+	Tag string
+	routeSpec
+}
+
+// handler 按元数据为处理函数添加幂等和认证中间件，认证在幂等之前，未通过认证的请求不会占用幂等键
+func (s *SimpleServer) handler(spec routeSpec) http.HandlerFunc {
+	h := spec.Handler
+	if spec.Idempotent {
+		h = s.idempotent(h)
+	}
+	if spec.Scope != "" {
+		h = s.requireScope(spec.Scope, h)
+	}
+	if spec.Session {
+		h = s.requireSession(h)
+	}
+	return h
+}
+
+// 设置路由：每个API版本使用自己的路径前缀，不带前缀的旧路径等同于v1。
+// 路由按 "METHOD /路径/{参数}" 模式注册，路径存在但方法不支持时返回405并带 Allow 头，
+// 其余请求统一返回JSON格式的404
+func (s *SimpleServer) registerRoutes(mux *http.ServeMux) {
+	handlers := s.routeHandlers()
+
+	versions := map[string]api.Version{"": api.V1}
+	for _, v := range api.Versions {
+		versions[v.Prefix()] = v
+	}
+
+	// 限流按不带版本前缀的路由计算，同一客户端访问不同版本共用额度
+	allowed := make(map[string][]string)
+	handle := func(method, prefix, path, tag string, spec routeSpec) {
+		pattern := prefix + path
+		mux.HandleFunc(method+" "+pattern, withRoute(method+" "+pattern, s.rateLimited(method+" "+path, s.handler(spec))))
+		allowed[pattern] = append(allowed[pattern], method)
+		s.routes = append(s.routes, registeredRoute{Method: method, Pattern: pattern, Tag: tag, routeSpec: spec})
+	}
+
+	for prefix, v := range versions {
+		for _, route := range api.Routes(v) {
+			spec, ok := handlers[route.Method+" "+route.Path]
+			if !ok {
+				panic(fmt.Sprintf("路由 %s %s 没有对应的处理函数", route.Method, route.Path))
+			}
+			handle(route.Method, prefix, route.Path, string(v), spec)
+		}
+	}
+	for _, route := range s.serverRoutes() {
+		handle(route.Method, "", route.Path, "server", route.routeSpec)
+	}
+
+	allow := make(map[string]string, len(allowed))
+	for pattern, methods := range allowed {
+		methods = withHead(methods)
+		allow[pattern] = strings.Join(methods, ", ")
+		notAllowed := s.methodNotAllowed(allow[pattern])
+		for _, method := range standardMethods {
+			if !slices.Contains(methods, method) {
+				mux.HandleFunc(method+" "+pattern, notAllowed)
+			}
+		}
+	}
+
+	// 每个路径都注册了所有标准方法，非标准方法（如TRACE、PROPFIND）会落到这里，
+	// 用GET探测路径是否存在，存在则同样返回405
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if !slices.Contains(standardMethods, r.Method) {
+			probe := r.Clone(r.Context())
+			probe.Method = http.MethodGet
+			if _, pattern := mux.Handler(probe); strings.HasPrefix(pattern, http.MethodGet+" ") {
+				s.methodNotAllowed(allow[strings.TrimPrefix(pattern, http.MethodGet+" ")])(w, r)
+				return
+			}
+		}
+		s.notFound(w, r)
+	})
+}
+
+// withHead 返回排序后的方法列表，ServeMux 的GET路由同时处理HEAD
+func withHead(methods []string) []string {
+	list := slices.Clone(methods)
+	if slices.Contains(list, http.MethodGet) && !slices.Contains(list, http.MethodHead) {
+		list = append(list, http.MethodHead)
+	}
+	slices.Sort(list)
+	return list
+}
+
+// methodNotAllowed 路径存在但不支持该方法
+func (s *SimpleServer) methodNotAllowed(allow string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Allow", allow)
+		s.sendResponse(w, http.StatusMethodNotAllowed, APIResponse{
+			Success: false,
+			Message: "不支持的HTTP方法",
+		})
+	}
+}
+
+// notFound 没有匹配的路由
+func (s *SimpleServer) notFound(w http.ResponseWriter, r *http.Request) {
+	s.sendResponse(w, http.StatusNotFound, APIResponse{
+		Success: false,
+		Message: "接口不存在",
+	})
+}
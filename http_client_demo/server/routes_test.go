@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestRouteTable 测试方法和路径组合对应的状态码以及405响应的 Allow 头
+func TestRouteTable(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+		body   string
+		status int
+		allow  string
+	}{
+		{"GET", "/users/1", "", http.StatusOK, ""},
+		{"GET", "/v2/users/2", "", http.StatusOK, ""},
+		{"HEAD", "/v1/users/1", "", http.StatusOK, ""},
+		{"GET", "/users/abc", "", http.StatusBadRequest, ""},
+		{"GET", "/users/99", "", http.StatusNotFound, ""},
+		{"POST", "/users", `{"name":"赵六","email":"zhaoliu@example.com"}`, http.StatusCreated, ""},
+		{"POST", "/v2/users", `{"name":"钱七","email":"qianqi@example.com"}`, http.StatusCreated, ""},
+		{"POST", "/v2/users", `{"name":"赵六","email":"ZhaoLiu@example.com"}`, http.StatusConflict, ""},
+		{"POST", "/users/encrypted", `{"encrypted_data":"abc"}`, http.StatusCreated, ""},
+		{"PUT", "/v2/users/2", `{"name":"李四四","email":"lisi@example.com"}`, http.StatusOK, ""},
+		{"DELETE", "/v2/users/3", "", http.StatusOK, ""},
+		{"GET", "/v2/users?q=zhang", "", http.StatusOK, ""},
+
+		// 旧版本不提供 PUT/DELETE 和搜索
+		{"PUT", "/v1/users/1", `{"name":"张三丰","email":"zhangsan@example.com"}`, http.StatusMethodNotAllowed, "GET, HEAD"},
+		{"DELETE", "/users/1", "", http.StatusMethodNotAllowed, "GET, HEAD"},
+		{"GET", "/users/encrypted", "", http.StatusMethodNotAllowed, "POST"},
+		{"GET", "/v1/users", "", http.StatusMethodNotAllowed, "POST"},
+		{"PUT", "/v2/users", "", http.StatusMethodNotAllowed, "GET, HEAD, POST"},
+		{"PATCH", "/v2/users/1", "", http.StatusMethodNotAllowed, "DELETE, GET, HEAD, PUT"},
+		{"GET", "/v1/login", "", http.StatusMethodNotAllowed, "POST"},
+		{"DELETE", "/upload", "", http.StatusMethodNotAllowed, "POST"},
+		{"PUT", "/admin/keys/key_1", "", http.StatusMethodNotAllowed, "DELETE"},
+		{"TRACE", "/v2/users", "", http.StatusMethodNotAllowed, "GET, HEAD, POST"},
+		{"PROPFIND", "/users/1", "", http.StatusMethodNotAllowed, "GET, HEAD"},
+		{"TRACE", "/nope", "", http.StatusNotFound, ""},
+
+		{"GET", "/unknown", "", http.StatusNotFound, ""},
+		{"GET", "/", "", http.StatusNotFound, ""},
+		{"GET", "/users/1/extra", "", http.StatusNotFound, ""},
+		{"GET", "/v3/users/1", "", http.StatusNotFound, ""},
+	}
+
+	s := NewSimpleServer("0")
+	s.initTestData()
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+		req.Header.Set("Authorization", "Bearer your-api-key-here")
+		// 路由表只关心方法和路径，更新和删除不检查版本
+		req.Header.Set("If-Match", "*")
+		rec := httptest.NewRecorder()
+		s.mux.ServeHTTP(rec, req)
+
+		if rec.Code != tt.status {
+			t.Errorf("%s %s: 期望状态码 %d，实际 %d", tt.method, tt.path, tt.status, rec.Code)
+		}
+		if allow := rec.Header().Get("Allow"); allow != tt.allow {
+			t.Errorf("%s %s: 期望 Allow 头 %q，实际 %q", tt.method, tt.path, tt.allow, allow)
+		}
+		if tt.method == "HEAD" {
+			continue
+		}
+		var resp APIResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Errorf("%s %s: 响应不是JSON: %q", tt.method, tt.path, rec.Body.String())
+		}
+	}
+}
+
+// TestUnauthorizedBeforeRouting 测试未授权请求在处理前被拒绝
+func TestUnauthorizedBeforeRouting(t *testing.T) {
+	s := NewSimpleServer("0")
+	s.initTestData()
+
+	req := httptest.NewRequest(http.MethodGet, "/v2/users/1", nil)
+	rec := httptest.NewRecorder()
+	s.mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("期望状态码 %d，实际 %d", http.StatusUnauthorized, rec.Code)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// startTestServer 在随机端口启动服务器，测试结束时关闭
+func startTestServer(t *testing.T) *SimpleServer {
+	t.Helper()
+	return startTestServerWithConfig(t, DefaultServerConfig("0"))
+}
+
+// startTestServerWithConfig 使用指定配置和空的内存存储在随机端口启动服务器，测试结束时关闭
+func startTestServerWithConfig(t *testing.T, config ServerConfig) *SimpleServer {
+	t.Helper()
+	s := NewSimpleServerWithConfig(config, NewMemoryUserStore())
+	errCh := make(chan error, 1)
+	go func() { errCh <- s.Start() }()
+
+	select {
+	case <-s.Ready():
+	case err := <-errCh:
+		t.Fatalf("启动服务器失败: %v", err)
+	}
+	t.Cleanup(func() {
+		s.Shutdown(context.Background())
+		if err := <-errCh; err != nil {
+			t.Errorf("服务器异常退出: %v", err)
+		}
+	})
+	return s
+}
+
+// TestServerStartOnRandomPort 测试同一进程中可以启动多个服务器
+func TestServerStartOnRandomPort(t *testing.T) {
+	first := startTestServer(t)
+	second := startTestServer(t)
+	if first.Addr() == second.Addr() {
+		t.Fatalf("两个服务器监听了相同的地址 %s", first.Addr())
+	}
+
+	for _, s := range []*SimpleServer{first, second} {
+		req, _ := http.NewRequest(http.MethodGet, "http://"+s.Addr()+"/v1/users/1", nil)
+		req.Header.Set("Authorization", "Bearer your-api-key-here")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("请求失败: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("期望状态码 200，实际 %d", resp.StatusCode)
+		}
+	}
+}
+
+// TestServerShutdownDrainsRequests 测试关闭时等待进行中的请求完成
+func TestServerShutdownDrainsRequests(t *testing.T) {
+	s := NewSimpleServer("0")
+	errCh := make(chan error, 1)
+	go func() { errCh <- s.Start() }()
+	<-s.Ready()
+
+	// 请求体分两次发送，关闭时请求仍在进行中
+	body, writer := io.Pipe()
+	req, _ := http.NewRequest(http.MethodPost, "http://"+s.Addr()+"/v1/upload", body)
+	req.Header.Set("Authorization", "Bearer your-api-key-here")
+	req.Header.Set("X-Filename", "slow.txt")
+
+	respCh := make(chan *http.Response, 1)
+	go func() {
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Errorf("请求失败: %v", err)
+			respCh <- nil
+			return
+		}
+		respCh <- resp
+	}()
+	writer.Write([]byte("part1"))
+	time.Sleep(50 * time.Millisecond)
+
+	shutdownDone := make(chan error, 1)
+	go func() { shutdownDone <- s.Shutdown(context.Background()) }()
+
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case <-shutdownDone:
+		t.Fatal("请求尚未完成时 Shutdown 不应返回")
+	default:
+	}
+
+	writer.Write([]byte("part2"))
+	writer.Close()
+
+	resp := <-respCh
+	if resp == nil {
+		t.FailNow()
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("进行中的请求应正常完成，状态码 %d", resp.StatusCode)
+	}
+	if err := <-shutdownDone; err != nil {
+		t.Errorf("Shutdown 返回错误: %v", err)
+	}
+	if err := <-errCh; err != nil {
+		t.Errorf("Start 应在关闭后返回nil，实际 %v", err)
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"http_client_demo/api"
+)
+
+// 会话令牌明文前缀，认证中间件据此区分会话令牌和API Key
+const sessionTokenPrefix = "st_"
+
+// DefaultSessionTTL 登录会话的默认有效期
+const DefaultSessionTTL = 24 * time.Hour
+
+var (
+	// ErrSessionInvalid 不存在或已注销的会话令牌
+	ErrSessionInvalid = errors.New("无效的会话令牌")
+	// ErrSessionExpired 会话已过期
+	ErrSessionExpired = errors.New("会话已过期，请重新登录")
+)
+
+// Session 一个登录会话。服务端只保存令牌的SHA-256哈希，
+// 会话只保存在内存中，服务器重启后需要重新登录
+type Session struct {
+	ID        string    `json:"id"`
+	UserID    int       `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+
+	hash [sha256.Size]byte
+}
+
+// sessionStore 保存登录会话，按令牌哈希查找，过期的会话在创建新会话时定期清理
+type sessionStore struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mu        sync.Mutex
+	byHash    map[[sha256.Size]byte]*Session
+	lastSweep time.Time
+}
+
+// newSessionStore 创建会话存储，ttl 为0时使用默认有效期
+func newSessionStore(ttl time.Duration) *sessionStore {
+	if ttl <= 0 {
+		ttl = DefaultSessionTTL
+	}
+	return &sessionStore{
+		ttl:    ttl,
+		now:    time.Now,
+		byHash: make(map[[sha256.Size]byte]*Session),
+	}
+}
+
+// create 为用户创建新会话，返回会话和令牌明文，明文只返回这一次
+func (st *sessionStore) create(userID int) (*Session, string, error) {
+	random, err := randomHex(32)
+	if err != nil {
+		return nil, "", err
+	}
+	id, err := randomHex(8)
+	if err != nil {
+		return nil, "", err
+	}
+	token := sessionTokenPrefix + random
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	now := st.now()
+	st.sweep(now)
+	session := &Session{
+		ID:        "sess_" + id,
+		UserID:    userID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(st.ttl),
+		hash:      sha256.Sum256([]byte(token)),
+	}
+	st.byHash[session.hash] = session
+	copied := *session
+	return &copied, token, nil
+}
+
+// authenticate 按令牌明文查找会话并检查是否过期。
+// 限流和认证中间件对同一请求各查找一次，这里不删除过期会话，两次结果一致
+func (st *sessionStore) authenticate(token string) (*Session, error) {
+	hash := sha256.Sum256([]byte(token))
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	session, ok := st.byHash[hash]
+	if !ok {
+		return nil, ErrSessionInvalid
+	}
+	if !st.now().Before(session.ExpiresAt) {
+		return nil, ErrSessionExpired
+	}
+	copied := *session
+	return &copied, nil
+}
+
+// revoke 注销令牌对应的会话，会话不存在时返回false
+func (st *sessionStore) revoke(token string) bool {
+	hash := sha256.Sum256([]byte(token))
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	_, ok := st.byHash[hash]
+	delete(st.byHash, hash)
+	return ok
+}
+
+// revokeUser 注销用户的所有会话，用于删除用户或修改密码之后，返回注销的数量
+func (st *sessionStore) revokeUser(userID int) int {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	n := 0
+	for hash, session := range st.byHash {
+		if session.UserID == userID {
+			delete(st.byHash, hash)
+			n++
+		}
+	}
+	return n
+}
+
+// sweep 每分钟最多一次，清理已过期的会话，调用方需持有锁
+func (st *sessionStore) sweep(now time.Time) {
+	if now.Sub(st.lastSweep) < time.Minute {
+		return
+	}
+	st.lastSweep = now
+	for hash, session := range st.byHash {
+		if !now.Before(session.ExpiresAt) {
+			delete(st.byHash, hash)
+		}
+	}
+}
+
+// principal 通过认证的调用方，API Key 和登录会话二者之一
+type principal struct {
+	// Key API Key认证时的Key
+	Key *APIKey
+	// Session 会话认证时的会话
+	Session *Session
+	// Role 会话所属用户当前的角色
+	Role   Role
+	Scopes []Scope
+}
+
+// HasScope 判断调用方是否拥有指定权限
+func (p *principal) HasScope(scope Scope) bool {
+	return slices.Contains(p.Scopes, scope)
+}
+
+// principalContextKey 请求上下文中保存已认证调用方的键
+type principalContextKey struct{}
+
+// principalFromContext 取出认证中间件保存的调用方，未认证的请求返回nil
+func principalFromContext(ctx context.Context) *principal {
+	p, _ := ctx.Value(principalContextKey{}).(*principal)
+	return p
+}
+
+// authenticate 按令牌前缀区分登录会话和API Key。
+// 登录会话的权限由用户当前的角色决定，每次请求重新读取，管理员修改角色后立即生效
+func (s *SimpleServer) authenticate(token string) (*principal, error) {
+	if strings.HasPrefix(token, sessionTokenPrefix) {
+		session, err := s.sessions.authenticate(token)
+		if err != nil {
+			return nil, err
+		}
+		user, err := s.store.Get(session.UserID)
+		if errors.Is(err, ErrUserNotFound) {
+			return nil, ErrSessionInvalid
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &principal{Session: session, Role: user.Role, Scopes: roleScopes(user.Role)}, nil
+	}
+
+	key, err := s.apiKeys.authenticate(token)
+	if err != nil {
+		return nil, err
+	}
+	return &principal{Key: key, Scopes: key.Scopes}, nil
+}
+
+// authenticateRequest 校验 Authorization 头中的API Key或会话令牌，失败时返回401。
+// 通过后把调用方记入访问日志
+func (s *SimpleServer) authenticateRequest(w http.ResponseWriter, r *http.Request) (*principal, bool) {
+	token, ok := bearerToken(r.Header.Get("Authorization"))
+	if !ok {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		s.sendResponse(w, http.StatusUnauthorized, APIResponse{
+			Success: false,
+			Message: "缺少API Key或会话令牌",
+		})
+		return nil, false
+	}
+
+	p, err := s.authenticate(token)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		s.sendResponse(w, http.StatusUnauthorized, APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return nil, false
+	}
+
+	if info := requestInfoFromContext(r.Context()); info != nil {
+		if p.Key != nil {
+			info.keyID = p.Key.ID
+		} else {
+			info.userID = p.Session.UserID
+		}
+	}
+	return p, true
+}
+
+// requireSession 只接受登录会话的接口，API Key 没有对应的用户
+func (s *SimpleServer) requireSession(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok := s.authenticateRequest(w, r)
+		if !ok {
+			return
+		}
+		if p.Session == nil {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			s.sendResponse(w, http.StatusUnauthorized, APIResponse{
+				Success: false,
+				Message: "该接口需要登录会话，不接受API Key",
+			})
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), principalContextKey{}, p)))
+	}
+}
+
+// handleMe 返回当前会话所属的用户
+func (s *SimpleServer) handleMe(w http.ResponseWriter, r *http.Request) {
+	session := principalFromContext(r.Context()).Session
+	user, err := s.store.Get(session.UserID)
+	if err != nil {
+		s.sendStoreError(w, err)
+		return
+	}
+
+	w.Header().Set("ETag", api.UserETag(user.Version))
+	s.sendResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "获取当前用户成功",
+		Data:    user,
+	})
+}
+
+// handleLogout 注销当前会话，之后该令牌不能再使用
+func (s *SimpleServer) handleLogout(w http.ResponseWriter, r *http.Request) {
+	token, _ := bearerToken(r.Header.Get("Authorization"))
+	s.sessions.revoke(token)
+	if p := principalFromContext(r.Context()); p != nil && p.Session != nil {
+		s.audit(r, AuditLogout, "sessions/"+p.Session.ID, nil, nil)
+	}
+	s.sendResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "注销成功",
+	})
+}
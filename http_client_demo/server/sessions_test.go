@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSessionLifecycle 测试登录得到的会话令牌可以访问接口，过期、注销、修改密码和删除用户后失效
+func TestSessionLifecycle(t *testing.T) {
+	config := DefaultServerConfig("0")
+	config.RateLimit = RateLimitConfig{}
+	config.PasswordIterations = 1000
+	config.SessionTTL = time.Hour
+	// 修改其他用户的密码需要管理员权限
+	config.APIKeys = append(config.APIKeys, BootstrapAPIKey{Name: "admin", Key: "admin-key", Scopes: []Scope{ScopeUsersWrite, ScopeAdmin}})
+	s := NewSimpleServerWithConfig(config, NewMemoryUserStore())
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	s.sessions.now = clock.Now
+
+	do := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		req.Header.Set("If-Match", "*")
+		rec := httptest.NewRecorder()
+		s.mux.ServeHTTP(rec, req)
+		return rec
+	}
+	login := func() string {
+		form := url.Values{"username": {"zhaoliu@example.com"}, "password": {"s3cret-pass"}}
+		req := httptest.NewRequest(http.MethodPost, "/v2/login", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		s.mux.ServeHTTP(rec, req)
+		var resp APIResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		token, _ := resp.Data.(string)
+		if rec.Code != http.StatusOK || !strings.HasPrefix(token, sessionTokenPrefix) {
+			t.Fatalf("登录期望返回会话令牌，实际 %d %s", rec.Code, rec.Body.String())
+		}
+		return token
+	}
+
+	do(http.MethodPost, "/v2/users", "your-api-key-here", `{"name":"赵六","email":"zhaoliu@example.com","password":"s3cret-pass"}`)
+	token := login()
+	if other := login(); other == token {
+		t.Error("每次登录期望得到不同的令牌")
+	}
+
+	rec := do(http.MethodGet, "/v2/me", token, "")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "zhaoliu@example.com") {
+		t.Errorf("GET /v2/me 期望返回当前用户，实际 %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := do(http.MethodGet, "/v2/users/1", token, ""); rec.Code != http.StatusOK {
+		t.Errorf("会话令牌期望可以代替API Key，实际状态码 %d", rec.Code)
+	}
+	if rec := do(http.MethodGet, "/admin/keys", token, ""); rec.Code != http.StatusForbidden {
+		t.Errorf("会话令牌不能管理API Key，期望 403，实际 %d", rec.Code)
+	}
+	if rec := do(http.MethodGet, "/v2/me", "your-api-key-here", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("GET /v2/me 不接受API Key，期望 401，实际 %d", rec.Code)
+	}
+	for _, guess := range []string{"token_zhaoliu@example.com_1704067200", sessionTokenPrefix + "0000"} {
+		if rec := do(http.MethodGet, "/v2/me", guess, ""); rec.Code != http.StatusUnauthorized {
+			t.Errorf("令牌 %q 期望 401，实际 %d", guess, rec.Code)
+		}
+	}
+
+	// 过期
+	clock.now = clock.now.Add(time.Hour)
+	rec = do(http.MethodGet, "/v2/me", token, "")
+	if rec.Code != http.StatusUnauthorized || !strings.Contains(rec.Body.String(), ErrSessionExpired.Error()) {
+		t.Errorf("过期后期望 401 会话已过期，实际 %d %s", rec.Code, rec.Body.String())
+	}
+
+	// 注销
+	token = login()
+	if rec := do(http.MethodPost, "/v2/logout", token, ""); rec.Code != http.StatusOK {
+		t.Errorf("注销期望状态码 200，实际 %d", rec.Code)
+	}
+	if rec := do(http.MethodGet, "/v2/me", token, ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("注销后期望 401，实际 %d", rec.Code)
+	}
+
+	// 修改密码
+	token = login()
+	if rec := do(http.MethodPut, "/v2/users/1", "your-api-key-here", `{"name":"赵六","email":"zhaoliu@example.com","password":"s3cret-pass"}`); rec.Code != http.StatusForbidden {
+		t.Errorf("没有管理权限的Key修改其他用户的密码期望 403，实际 %d", rec.Code)
+	}
+	do(http.MethodPut, "/v2/users/1", "admin-key", `{"name":"赵六","email":"zhaoliu@example.com","password":"s3cret-pass"}`)
+	if rec := do(http.MethodGet, "/v2/me", token, ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("修改密码后期望 401，实际 %d", rec.Code)
+	}
+
+	// 删除用户
+	token = login()
+	do(http.MethodDelete, "/v2/users/1", "your-api-key-here", "")
+	if rec := do(http.MethodGet, "/v2/me", token, ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("删除用户后期望 401，实际 %d", rec.Code)
+	}
+}
@@ -1,111 +1,310 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log"
+	"log/slog"
+	"math"
+	"net"
 	"net/http"
+	"os"
+	"slices"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
+
+	"http_client_demo/api"
 )
 
-// User 用户结构体
-type User struct {
-	ID       int    `json:"id"`
-	Name     string `json:"name"`
-	Email    string `json:"email"`
-	Password string `json:"password,omitempty"`
-}
+// User 用户结构体，与客户端共用 api 包中的定义
+type User = api.User
 
 // APIResponse API响应结构体
-type APIResponse struct {
-	Success bool        `json:"success"`
-	Message string      `json:"message"`
-	Data    interface{} `json:"data"`
+type APIResponse = api.APIResponse
+
+// ServerConfig 服务器配置
+type ServerConfig struct {
+	// Addr 监听地址，端口为0时由系统分配，实际地址通过 Addr() 获取
+	Addr              string
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	// ShutdownTimeout Run 收到退出信号后等待进行中请求完成的最长时间
+	ShutdownTimeout time.Duration
+	// APIKeys 启动时导入的API Key，其余Key通过 /admin/keys 接口创建
+	APIKeys []BootstrapAPIKey
+	// RateLimit 限流配置，零值表示不限流
+	RateLimit RateLimitConfig
+	// Logger 访问日志输出，为nil时以JSON格式写到标准错误
+	Logger *slog.Logger
+	// AccessLogSampleRate 2xx响应的访问日志采样比例，1表示全部记录，其余状态码总是记录
+	AccessLogSampleRate float64
+	// UploadDir 上传文件的保存目录，为空时在首次上传时创建临时目录，Shutdown 时删除
+	UploadDir string
+	// MaxUploadBytes 单个上传文件的最大字节数，0表示使用默认值
+	MaxUploadBytes int64
+	// PasswordIterations 新密码哈希的PBKDF2迭代次数，调整后旧哈希在下次登录成功时重新计算
+	PasswordIterations int
+	// MaxLoginFailures 连续登录失败多少次后锁定账户，0表示不锁定
+	MaxLoginFailures int
+	// LoginLockout 账户锁定时长
+	LoginLockout time.Duration
+	// SessionTTL 登录会话的有效期
+	SessionTTL time.Duration
+	// Webhooks webhook 投递的并发数、队列长度和重试策略
+	Webhooks WebhookConfig
+	// Events GET /events 事件流的重放缓冲区和心跳间隔
+	Events EventStreamConfig
+	// MaxBodyBytes JSON请求体的最大字节数，0表示使用默认值，上传文件由 MaxUploadBytes 限制
+	MaxBodyBytes int64
+	// MaxImportUsers 单次批量导入的最多用户数，0表示使用默认值
+	MaxImportUsers int
+	// AuditLog 记录所有变更操作的审计日志，为nil时只在内存中保留最近 DefaultMemoryAuditEntries 条
+	AuditLog *AuditLog
+}
+
+// DefaultServerConfig 返回监听指定端口的默认配置
+func DefaultServerConfig(port string) ServerConfig {
+	return ServerConfig{
+		Addr:              ":" + port,
+		ReadTimeout:       30 * time.Second,
+		ReadHeaderTimeout: 5 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+		ShutdownTimeout:   15 * time.Second,
+		APIKeys: []BootstrapAPIKey{{
+			Name:   "demo",
+			Key:    "your-api-key-here",
+			Scopes: []Scope{ScopeUsersRead, ScopeUsersWrite, ScopeUpload},
+		}},
+		RateLimit:           DefaultRateLimitConfig(),
+		AccessLogSampleRate: 1,
+		MaxUploadBytes:      DefaultMaxUploadBytes,
+		MaxBodyBytes:        DefaultMaxBodyBytes,
+		MaxImportUsers:      DefaultMaxImportUsers,
+		PasswordIterations:  DefaultPasswordIterations,
+		MaxLoginFailures:    5,
+		LoginLockout:        15 * time.Minute,
+		SessionTTL:          DefaultSessionTTL,
+		Webhooks:            DefaultWebhookConfig(),
+		Events:              DefaultEventStreamConfig(),
+	}
 }
 
 // SimpleServer 简化的HTTP服务器
 type SimpleServer struct {
-	users map[int]*User
-	port  string
+	store       *eventUserStore
+	users       *IndexedUserStore
+	config      ServerConfig
+	idempotency *idempotencyStore
+	apiKeys     *apiKeyStore
+	limiter     *rateLimiter
+	logins      *loginGuard
+	sessions    *sessionStore
+	events      *eventBus
+	webhooks    *webhookDispatcher
+	streams     *eventStream
+	logger      *slog.Logger
+	metrics     *serverMetrics
+	uploads     lazyUploadStore
+	auditLog    *AuditLog
+
+	// shuttingDown Shutdown 调用后为true，/readyz 据此返回503
+	shuttingDown atomic.Bool
+
+	mux        *http.ServeMux
+	routes     []registeredRoute
+	httpServer *http.Server
+	listener   net.Listener
+	ready      chan struct{}
 }
 
-// NewSimpleServer 创建新的简化服务器
+// NewSimpleServer 创建使用内存存储的简化服务器
 func NewSimpleServer(port string) *SimpleServer {
-	return &SimpleServer{
-		users: make(map[int]*User),
-		port:  port,
+	return NewSimpleServerWithStore(port, NewMemoryUserStore())
+}
+
+// NewSimpleServerWithStore 创建使用指定用户存储的简化服务器
+func NewSimpleServerWithStore(port string, store UserStore) *SimpleServer {
+	return NewSimpleServerWithConfig(DefaultServerConfig(port), store)
+}
+
+// NewSimpleServerWithConfig 创建使用指定配置和用户存储的简化服务器
+func NewSimpleServerWithConfig(config ServerConfig, store UserStore) *SimpleServer {
+	// 所有写操作都经过索引装饰器，搜索接口才能看到最新数据；
+	// 外层的事件装饰器在写入成功后发布用户事件
+	users := NewIndexedUserStore(store)
+	events := newEventBus()
+	s := &SimpleServer{
+		store:       newEventUserStore(users, events),
+		users:       users,
+		config:      config,
+		idempotency: newIdempotencyStore(24 * time.Hour),
+		apiKeys:     newAPIKeyStore(),
+		limiter:     newRateLimiter(config.RateLimit),
+		logins:      newLoginGuard(config.MaxLoginFailures, config.LoginLockout),
+		sessions:    newSessionStore(config.SessionTTL),
+		events:      events,
+		webhooks:    newWebhookDispatcher(config.Webhooks),
+		streams:     newEventStream(config.Events),
+		logger:      config.Logger,
+		auditLog:    config.AuditLog,
+		mux:         http.NewServeMux(),
+		ready:       make(chan struct{}),
+	}
+	s.events.subscribe(s.webhooks.enqueue)
+	s.events.subscribe(s.streams.publish)
+	for _, bootstrap := range config.APIKeys {
+		if _, err := s.apiKeys.importKey(bootstrap); err != nil {
+			log.Printf("导入API Key %s 失败: %v", bootstrap.Name, err)
+		}
+	}
+	s.metrics = newServerMetrics(func() float64 {
+		users, err := s.store.List()
+		if err != nil {
+			return math.NaN()
+		}
+		return float64(len(users))
+	})
+	if s.auditLog == nil {
+		s.auditLog = NewMemoryAuditLog(0)
+	}
+	if s.logger == nil {
+		s.logger = slog.New(slog.NewJSONHandler(os.Stderr, nil))
 	}
+	s.registerRoutes(s.mux)
+	s.httpServer = &http.Server{
+		Addr:              config.Addr,
+		Handler:           s.accessLog(s.mux),
+		ReadTimeout:       config.ReadTimeout,
+		ReadHeaderTimeout: config.ReadHeaderTimeout,
+		WriteTimeout:      config.WriteTimeout,
+		IdleTimeout:       config.IdleTimeout,
+	}
+	return s
 }
 
-// 初始化测试数据
+// 初始化测试数据，存储中已有用户时不再重复写入
 func (s *SimpleServer) initTestData() {
-	s.users[1] = &User{
-		ID:    1,
-		Name:  "张三",
-		Email: "zhangsan@example.com",
+	users, err := s.store.List()
+	if err != nil || len(users) > 0 {
+		return
 	}
-	s.users[2] = &User{
-		ID:    2,
-		Name:  "李四",
-		Email: "lisi@example.com",
+
+	iterations := s.passwordIterations()
+	s.store.Create(&User{
+		Name:         "张三",
+		Email:        "zhangsan@example.com",
+		PasswordHash: cachedPasswordHash("password123", iterations),
+		Role:         api.RoleAdmin,
+	})
+	s.store.Create(&User{
+		Name:         "李四",
+		Email:        "lisi@example.com",
+		PasswordHash: cachedPasswordHash("password456", iterations),
+		Role:         api.RoleEditor,
+	})
+	s.store.Create(&User{
+		Name:         "王五",
+		Email:        "wangwu@example.com",
+		PasswordHash: cachedPasswordHash("password789", iterations),
+		Role:         api.RoleViewer,
+	})
+}
+
+// passwordIterations 返回配置的PBKDF2迭代次数，未配置时使用默认值
+func (s *SimpleServer) passwordIterations() int {
+	if s.config.PasswordIterations > 0 {
+		return s.config.PasswordIterations
 	}
-	s.users[3] = &User{
-		ID:    3,
-		Name:  "王五",
-		Email: "wangwu@example.com",
+	return DefaultPasswordIterations
+}
+
+// hashUserPassword 把提交的明文密码替换为哈希，没有提交密码时不做处理
+func (s *SimpleServer) hashUserPassword(user *User) error {
+	if user.Password == "" {
+		return nil
 	}
+	hash, err := hashPassword(user.Password, s.passwordIterations())
+	if err != nil {
+		return err
+	}
+	user.PasswordHash = hash
+	user.Password = ""
+	return nil
 }
 
-// 启动服务器
-func (s *SimpleServer) Start() {
+// Start 启动服务器并阻塞到服务器关闭，调用 Shutdown 后返回nil
+func (s *SimpleServer) Start() error {
 	s.initTestData()
 
-	// 设置路由
-	http.HandleFunc("/users/", s.handleUsers)
-	http.HandleFunc("/login", s.handleLogin)
-	http.HandleFunc("/upload", s.handleUpload)
-	http.HandleFunc("/users/encrypted", s.handleEncryptedUser)
+	listener, err := net.Listen("tcp", s.config.Addr)
+	if err != nil {
+		return fmt.Errorf("监听 %s 失败: %v", s.config.Addr, err)
+	}
+	s.listener = listener
+	close(s.ready)
 
-	log.Printf("简化服务器启动在端口 %s", s.port)
-	log.Fatal(http.ListenAndServe(":"+s.port, nil))
+	log.Printf("简化服务器启动在 %s", listener.Addr())
+	if err := s.httpServer.Serve(listener); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
 }
 
-// 处理用户相关请求
-func (s *SimpleServer) handleUsers(w http.ResponseWriter, r *http.Request) {
-	// 验证API Key
-	if !s.validateAPIKey(r) {
-		s.sendResponse(w, http.StatusUnauthorized, APIResponse{
-			Success: false,
-			Message: "无效的API Key",
-		})
-		return
-	}
+// Ready 返回一个channel，服务器开始监听后关闭
+func (s *SimpleServer) Ready() <-chan struct{} {
+	return s.ready
+}
 
-	path := strings.TrimPrefix(r.URL.Path, "/users/")
+// Addr 返回实际监听的地址，需在 Ready() 关闭后调用
+func (s *SimpleServer) Addr() string {
+	return s.listener.Addr().String()
+}
 
-	switch r.Method {
-	case "GET":
-		if path != "" {
-			s.getUser(w, r, path)
-		}
-	case "POST":
-		if path == "" {
-			s.createUser(w, r)
-		}
-	default:
-		s.sendResponse(w, http.StatusMethodNotAllowed, APIResponse{
-			Success: false,
-			Message: "不支持的HTTP方法",
-		})
+// Shutdown 停止接收新连接，等待进行中的请求完成或ctx结束
+func (s *SimpleServer) Shutdown(ctx context.Context) error {
+	s.shuttingDown.Store(true)
+	// 先结束事件流长连接，否则 Shutdown 会等到超时
+	s.streams.close()
+	err := s.httpServer.Shutdown(ctx)
+	s.webhooks.close()
+	s.uploads.cleanup()
+	return err
+}
+
+// Run 启动服务器，ctx结束（例如收到SIGINT/SIGTERM）时优雅关闭
+func (s *SimpleServer) Run(ctx context.Context) error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- s.Start()
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Printf("正在关闭服务器，等待进行中的请求完成...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.config.ShutdownTimeout)
+	defer cancel()
+	if err := s.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("关闭服务器失败: %v", err)
 	}
+	return <-errCh
 }
 
 // 获取用户
-func (s *SimpleServer) getUser(w http.ResponseWriter, r *http.Request, idStr string) {
-	id, err := strconv.Atoi(idStr)
+func (s *SimpleServer) getUser(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
 	if err != nil {
 		s.sendResponse(w, http.StatusBadRequest, APIResponse{
 			Success: false,
@@ -114,15 +313,13 @@ func (s *SimpleServer) getUser(w http.ResponseWriter, r *http.Request, idStr str
 		return
 	}
 
-	user, exists := s.users[id]
-	if !exists {
-		s.sendResponse(w, http.StatusNotFound, APIResponse{
-			Success: false,
-			Message: "用户不存在",
-		})
+	user, err := s.store.Get(id)
+	if err != nil {
+		s.sendStoreError(w, err)
 		return
 	}
 
+	w.Header().Set("ETag", api.UserETag(user.Version))
 	s.sendResponse(w, http.StatusOK, APIResponse{
 		Success: true,
 		Message: "获取用户成功",
@@ -133,35 +330,195 @@ func (s *SimpleServer) getUser(w http.ResponseWriter, r *http.Request, idStr str
 // 创建用户
 func (s *SimpleServer) createUser(w http.ResponseWriter, r *http.Request) {
 	var user User
-	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+	if err := s.decodeJSON(w, r, &user); err != nil {
+		s.sendDecodeError(w, r, err)
+		return
+	}
+
+	if err := user.Validate(); err != nil {
+		s.sendValidationError(w, r, err)
+		return
+	}
+	if err := s.hashUserPassword(&user); err != nil {
+		s.sendStoreError(w, err)
+		return
+	}
+
+	// 新用户总是只读角色，角色只能由管理员修改
+	user.Role = api.RoleViewer
+	created, err := s.store.Create(&user)
+	if err != nil {
+		s.sendStoreError(w, err)
+		return
+	}
+	s.audit(r, AuditUserCreate, userResource(created.ID), nil, created)
+
+	w.Header().Set("ETag", api.UserETag(created.Version))
+	s.sendResponse(w, http.StatusCreated, APIResponse{
+		Success: true,
+		Message: "创建用户成功",
+		Data:    created,
+	})
+}
+
+// 更新用户，需要通过 If-Match 指定基于哪个版本修改
+func (s *SimpleServer) updateUser(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
 		s.sendResponse(w, http.StatusBadRequest, APIResponse{
 			Success: false,
-			Message: "无效的请求数据",
+			Message: "无效的用户ID",
 		})
 		return
 	}
+	version, ok := s.ifMatchVersion(w, r, id)
+	if !ok {
+		return
+	}
+
+	var user User
+	if err := s.decodeJSON(w, r, &user); err != nil {
+		s.sendDecodeError(w, r, err)
+		return
+	}
+	if err := user.Validate(); err != nil {
+		s.sendValidationError(w, r, err)
+		return
+	}
 
-	// 生成新ID
-	user.ID = len(s.users) + 1
-	s.users[user.ID] = &user
+	// 没有提交密码时存储保留原有的密码哈希
+	passwordChanged := user.Password != ""
+	if err := s.hashUserPassword(&user); err != nil {
+		s.sendStoreError(w, err)
+		return
+	}
 
-	s.sendResponse(w, http.StatusCreated, APIResponse{
+	user.ID = id
+	user.Version = version
+	// 角色为空时存储保留原有角色，不能通过更新用户提升自己的权限
+	user.Role = ""
+	before, err := s.store.Get(id)
+	if err != nil {
+		s.sendStoreError(w, err)
+		return
+	}
+	if reason := userChangeForbidden(principalFromContext(r.Context()), before, passwordChanged); reason != "" {
+		s.sendResponse(w, http.StatusForbidden, APIResponse{
+			Success: false,
+			Message: reason,
+		})
+		return
+	}
+	updated, err := s.store.Update(&user)
+	if err != nil {
+		s.sendStoreError(w, err)
+		return
+	}
+	s.audit(r, AuditUserUpdate, userResource(id), before, updated)
+	// 修改密码后已登录的会话全部失效
+	if passwordChanged {
+		s.sessions.revokeUser(id)
+	}
+
+	w.Header().Set("ETag", api.UserETag(updated.Version))
+	s.sendResponse(w, http.StatusOK, APIResponse{
 		Success: true,
-		Message: "创建用户成功",
-		Data:    user,
+		Message: "更新用户成功",
+		Data:    updated,
 	})
 }
 
-// 处理登录
-func (s *SimpleServer) handleLogin(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "POST" {
-		s.sendResponse(w, http.StatusMethodNotAllowed, APIResponse{
+// 删除用户，需要通过 If-Match 指定要删除的版本
+func (s *SimpleServer) deleteUser(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		s.sendResponse(w, http.StatusBadRequest, APIResponse{
 			Success: false,
-			Message: "只支持POST方法",
+			Message: "无效的用户ID",
 		})
 		return
 	}
+	version, ok := s.ifMatchVersion(w, r, id)
+	if !ok {
+		return
+	}
+
+	before, err := s.store.Get(id)
+	if err != nil {
+		s.sendStoreError(w, err)
+		return
+	}
+	if reason := userChangeForbidden(principalFromContext(r.Context()), before, false); reason != "" {
+		s.sendResponse(w, http.StatusForbidden, APIResponse{
+			Success: false,
+			Message: reason,
+		})
+		return
+	}
+	if err := s.store.Delete(id, version); err != nil {
+		s.sendStoreError(w, err)
+		return
+	}
+	s.sessions.revokeUser(id)
+	s.audit(r, AuditUserDelete, userResource(id), before, nil)
+
+	s.sendResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "删除用户成功",
+	})
+}
+
+// checkPassword 按邮箱（不区分大小写）查找唯一的用户并校验密码。
+// 用户不存在或没有设置密码时也计算一次哈希，响应时间不会暴露账户是否存在
+func (s *SimpleServer) checkPassword(email, password string) (*User, bool) {
+	iterations := s.passwordIterations()
+	users, err := s.users.Search(UserQuery{Email: email})
+	if err != nil {
+		log.Printf("查找登录用户失败: %v", err)
+	}
+	// 邮箱唯一，只接受恰好匹配一个用户的情况；唯一约束之前遗留的重复邮箱不能登录
+	if len(users) > 1 {
+		log.Printf("邮箱 %s 对应 %d 个用户，拒绝登录", email, len(users))
+	}
+	if len(users) == 1 && users[0].PasswordHash != "" {
+		user := users[0]
+		ok, rehash := verifyPassword(password, user.PasswordHash, iterations)
+		if !ok {
+			return nil, false
+		}
+		if rehash {
+			s.rehashPassword(user, password)
+		}
+		return user, true
+	}
+
+	verifyPassword(password, cachedPasswordHash("", iterations), iterations)
+	return nil, false
+}
+
+// rehashPassword 迭代次数调整后，用新的迭代次数重新计算密码哈希，失败时保留旧哈希。
+// 哈希对外不可见，直接写入底层存储，不发布 user.updated 事件和webhook
+func (s *SimpleServer) rehashPassword(user *User, password string) {
+	hash, err := hashPassword(password, s.passwordIterations())
+	if err != nil {
+		log.Printf("重新计算密码哈希失败: %v", err)
+		return
+	}
+	updated := *user
+	updated.PasswordHash = hash
+	if _, err := s.users.Update(&updated); err != nil && !errors.Is(err, ErrVersionConflict) {
+		log.Printf("保存密码哈希失败: %v", err)
+	}
+}
 
+// loginForm 登录表单字段，与 handleLogin 读取的字段一致，用于生成文档
+type loginForm struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// 处理登录
+func (s *SimpleServer) handleLogin(w http.ResponseWriter, r *http.Request) {
 	// 解析表单数据
 	if err := r.ParseForm(); err != nil {
 		s.sendResponse(w, http.StatusBadRequest, APIResponse{
@@ -182,128 +539,161 @@ func (s *SimpleServer) handleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 简单的用户验证
-	if username == "zhangsan@example.com" && password == "password123" {
-		// 生成简单的token
-		token := fmt.Sprintf("token_%s_%d", username, time.Now().Unix())
-		s.sendResponse(w, http.StatusOK, APIResponse{
-			Success: true,
-			Message: "登录成功",
-			Data:    token,
-		})
-	} else {
-		s.sendResponse(w, http.StatusUnauthorized, APIResponse{
+	// 按账户（不区分大小写）计数失败次数，锁定期间不再校验密码
+	account := strings.ToLower(username)
+	if remaining, locked := s.logins.locked(account); locked {
+		w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(remaining)))
+		s.sendResponse(w, http.StatusTooManyRequests, APIResponse{
 			Success: false,
-			Message: "用户名或密码错误",
+			Message: "登录失败次数过多，账户已锁定，请稍后重试",
 		})
+		return
 	}
-}
 
-// 处理文件上传
-func (s *SimpleServer) handleUpload(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "POST" {
-		s.sendResponse(w, http.StatusMethodNotAllowed, APIResponse{
+	user, ok := s.checkPassword(username, password)
+	if !ok {
+		s.logins.fail(account)
+		s.audit(r, AuditLoginFailed, "accounts/"+account, nil, nil)
+		s.sendResponse(w, http.StatusUnauthorized, APIResponse{
 			Success: false,
-			Message: "只支持POST方法",
+			Message: "用户名或密码错误",
 		})
 		return
 	}
+	s.logins.succeed(account)
 
-	// 验证API Key
-	if !s.validateAPIKey(r) {
-		s.sendResponse(w, http.StatusUnauthorized, APIResponse{
+	// 返回随机的会话令牌，服务端只保存哈希
+	session, token, err := s.sessions.create(user.ID)
+	if err != nil {
+		s.sendResponse(w, http.StatusInternalServerError, APIResponse{
 			Success: false,
-			Message: "无效的API Key",
+			Message: fmt.Sprintf("创建会话失败: %v", err),
 		})
 		return
 	}
+	// 登录请求本身没有认证信息，操作者是刚登录的用户
+	entry := s.auditEntry(r, AuditLogin, userResource(user.ID), nil, nil)
+	entry.Actor = "user:" + strconv.Itoa(user.ID)
+	entry.Session = session.ID
+	s.appendAudit(entry)
+	s.sendResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "登录成功",
+		Data:    token,
+	})
+}
 
-	filename := r.Header.Get("X-Filename")
-	if filename == "" {
-		s.sendResponse(w, http.StatusBadRequest, APIResponse{
-			Success: false,
-			Message: "缺少文件名",
-		})
+// encryptedUserRequest 加密用户创建请求，encrypted_data 是加密后的用户数据
+type encryptedUserRequest struct {
+	Data string `json:"encrypted_data"`
+}
+
+// 处理加密用户创建
+func (s *SimpleServer) handleEncryptedUser(w http.ResponseWriter, r *http.Request) {
+	var encryptedRequest encryptedUserRequest
+
+	if err := s.decodeJSON(w, r, &encryptedRequest); err != nil {
+		s.sendDecodeError(w, r, err)
 		return
 	}
 
-	// 读取文件内容
-	body, err := io.ReadAll(r.Body)
+	// 为了演示，服务端不解密，直接创建一个新用户。邮箱必须唯一，取自密文的哈希：
+	// 密文带有随机nonce，每次加密都不同，原样重发的请求返回409
+	sum := sha256.Sum256([]byte(encryptedRequest.Data))
+	user, err := s.store.Create(&User{
+		Name:  "加密用户",
+		Email: "encrypted-" + hex.EncodeToString(sum[:6]) + "@example.com",
+		Role:  api.RoleViewer,
+	})
 	if err != nil {
-		s.sendResponse(w, http.StatusBadRequest, APIResponse{
-			Success: false,
-			Message: "读取文件失败",
-		})
+		s.sendStoreError(w, err)
 		return
 	}
+	s.audit(r, AuditUserCreate, userResource(user.ID), nil, user)
 
-	log.Printf("接收到文件上传: %s, 大小: %d bytes", filename, len(body))
-
-	s.sendResponse(w, http.StatusOK, APIResponse{
+	s.sendResponse(w, http.StatusCreated, APIResponse{
 		Success: true,
-		Message: "文件上传成功",
-		Data: map[string]interface{}{
-			"filename": filename,
-			"size":     len(body),
-		},
+		Message: "创建加密用户成功",
+		Data:    user,
 	})
 }
 
-// 处理加密用户创建
-func (s *SimpleServer) handleEncryptedUser(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "POST" {
-		s.sendResponse(w, http.StatusMethodNotAllowed, APIResponse{
+// ifMatchVersion 从 If-Match 请求头得到修改前期望的用户版本，"*" 返回0表示不检查版本。
+// 缺少请求头返回428；列出多个ETag时取与当前版本相同的一个，都不相同返回412。
+// 返回的版本由存储在写入时再次比较，检查和写入之间被修改同样返回412
+func (s *SimpleServer) ifMatchVersion(w http.ResponseWriter, r *http.Request, id int) (int, bool) {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		s.sendResponse(w, http.StatusPreconditionRequired, APIResponse{
 			Success: false,
-			Message: "只支持POST方法",
+			Message: "缺少If-Match请求头，请先获取用户的ETag",
 		})
-		return
+		return 0, false
 	}
 
-	// 验证API Key
-	if !s.validateAPIKey(r) {
-		s.sendResponse(w, http.StatusUnauthorized, APIResponse{
-			Success: false,
-			Message: "无效的API Key",
-		})
-		return
+	var versions []int
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return 0, true
+		}
+		// 弱ETag和无法解析的值按强比较规则永远不匹配
+		if v, ok := api.ParseUserETag(tag); ok {
+			versions = append(versions, v)
+		}
+	}
+	if len(versions) == 1 {
+		return versions[0], true
 	}
 
-	var encryptedRequest struct {
-		Data string `json:"encrypted_data"`
+	current, err := s.store.Get(id)
+	if err != nil {
+		s.sendStoreError(w, err)
+		return 0, false
+	}
+	if slices.Contains(versions, current.Version) {
+		return current.Version, true
 	}
+	s.sendStoreError(w, ErrVersionConflict)
+	return 0, false
+}
 
-	if err := json.NewDecoder(r.Body).Decode(&encryptedRequest); err != nil {
-		s.sendResponse(w, http.StatusBadRequest, APIResponse{
+// sendStoreError 把存储层错误转换为响应
+func (s *SimpleServer) sendStoreError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrUserNotFound) {
+		s.sendResponse(w, http.StatusNotFound, APIResponse{
 			Success: false,
-			Message: "无效的请求数据",
+			Message: "用户不存在",
 		})
 		return
 	}
-
-	// 为了演示，直接创建一个新用户
-	user := &User{
-		ID:    len(s.users) + 1,
-		Name:  "加密用户",
-		Email: "encrypted@example.com",
+	if errors.Is(err, ErrEmailTaken) {
+		s.sendResponse(w, http.StatusConflict, APIResponse{
+			Success: false,
+			Message: "邮箱已被其他用户使用",
+		})
+		return
+	}
+	if errors.Is(err, ErrVersionConflict) {
+		s.sendResponse(w, http.StatusPreconditionFailed, APIResponse{
+			Success: false,
+			Message: "用户已被修改，请重新获取后再试",
+		})
+		return
 	}
 
-	s.users[user.ID] = user
-
-	s.sendResponse(w, http.StatusCreated, APIResponse{
-		Success: true,
-		Message: "创建加密用户成功",
-		Data:    user,
+	log.Printf("用户存储错误: %v", err)
+	s.sendResponse(w, http.StatusInternalServerError, APIResponse{
+		Success: false,
+		Message: "服务器内部错误",
 	})
 }
 
-// 验证API Key
-func (s *SimpleServer) validateAPIKey(r *http.Request) bool {
-	authHeader := r.Header.Get("Authorization")
-	return strings.HasPrefix(authHeader, "Bearer your-api-key-here")
-}
-
-// 发送响应
+// 发送响应，错误响应中回显请求ID
 func (s *SimpleServer) sendResponse(w http.ResponseWriter, statusCode int, response APIResponse) {
+	if !response.Success && response.RequestID == "" {
+		response.RequestID = w.Header().Get(RequestIDHeader)
+	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
 	json.NewEncoder(w).Encode(response)
@@ -0,0 +1,450 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"http_client_demo/api"
+)
+
+// FileInfo 上传文件的元数据，与客户端共用 api 包中的定义
+type FileInfo = api.FileInfo
+
+// ErrFileNotFound 文件不存在
+var ErrFileNotFound = errors.New("文件不存在")
+
+const (
+	uploadIndexFileName = "index.json"
+	uploadObjectsDir    = "objects"
+	uploadTmpDir        = "tmp"
+	// 文件名的最大字节数
+	maxFileNameLength = 255
+	// DefaultMaxUploadBytes 单个上传文件默认的最大字节数
+	DefaultMaxUploadBytes = 32 << 20
+)
+
+// UploadStore 把上传的文件按内容的SHA-256保存在目录中，相同内容只保存一份。
+// 目录结构：
+//
+//	objects/ab/cdef...  文件内容，路径由哈希决定，与客户端提供的文件名无关
+//	tmp/                上传过程中的临时文件
+//	index.json          文件元数据索引
+type UploadStore struct {
+	dir string
+
+	mu    sync.RWMutex
+	files map[string]*FileInfo
+}
+
+// OpenUploadStore 打开或创建上传目录并加载索引
+func OpenUploadStore(dir string) (*UploadStore, error) {
+	for _, sub := range []string{uploadObjectsDir, uploadTmpDir} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			return nil, fmt.Errorf("创建上传目录失败: %v", err)
+		}
+	}
+
+	st := &UploadStore{dir: dir, files: make(map[string]*FileInfo)}
+	data, err := os.ReadFile(filepath.Join(dir, uploadIndexFileName))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("读取上传索引失败: %v", err)
+	}
+	if len(data) > 0 {
+		var files []*FileInfo
+		if err := json.Unmarshal(data, &files); err != nil {
+			return nil, fmt.Errorf("解析上传索引失败: %v", err)
+		}
+		for _, f := range files {
+			st.files[f.ID] = f
+		}
+	}
+	return st, nil
+}
+
+// sanitizeFileName 清理客户端提供的文件名：去掉目录部分和控制字符，
+// 拒绝 "."、".." 等特殊名称。文件名只作为元数据保存，不参与磁盘路径
+func sanitizeFileName(name string) (string, error) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	name = name[strings.LastIndex(name, "/")+1:]
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+
+	if name == "" || name == "." || name == ".." {
+		return "", fmt.Errorf("无效的文件名")
+	}
+	if len(name) > maxFileNameLength {
+		return "", fmt.Errorf("文件名过长")
+	}
+	return name, nil
+}
+
+// objectPath 返回内容哈希对应的文件路径
+func (st *UploadStore) objectPath(id string) string {
+	return filepath.Join(st.dir, uploadObjectsDir, id[:2], id[2:])
+}
+
+// validFileID 文件ID必须是64位小写十六进制，防止拼接出目录外的路径
+func validFileID(id string) bool {
+	if len(id) != sha256.Size*2 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// Save 把r的内容流式写入临时文件并计算哈希，完成后移动到内容地址。
+// 读取r出错（例如超过大小限制）时丢弃临时文件并返回该错误
+func (st *UploadStore) Save(name, contentType string, r io.Reader) (*FileInfo, error) {
+	tmp, err := os.CreateTemp(filepath.Join(st.dir, uploadTmpDir), "upload-*")
+	if err != nil {
+		return nil, fmt.Errorf("创建临时文件失败: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), r)
+	if err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return nil, fmt.Errorf("写入文件失败: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, fmt.Errorf("写入文件失败: %v", err)
+	}
+
+	id := hex.EncodeToString(hash.Sum(nil))
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	// 相同内容已经保存过时直接返回已有的元数据
+	if existing, ok := st.files[id]; ok {
+		copied := *existing
+		return &copied, nil
+	}
+
+	path := st.objectPath(id)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("创建目录失败: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return nil, fmt.Errorf("保存文件失败: %v", err)
+	}
+
+	info := &FileInfo{
+		ID:          id,
+		Name:        name,
+		Size:        size,
+		ContentType: contentType,
+		UploadedAt:  time.Now().UTC(),
+	}
+	st.files[id] = info
+	if err := st.writeIndex(); err != nil {
+		delete(st.files, id)
+		os.Remove(path)
+		return nil, err
+	}
+
+	copied := *info
+	return &copied, nil
+}
+
+// Open 打开文件内容，调用方负责关闭
+func (st *UploadStore) Open(id string) (*os.File, *FileInfo, error) {
+	if !validFileID(id) {
+		return nil, nil, ErrFileNotFound
+	}
+
+	st.mu.RLock()
+	info, ok := st.files[id]
+	st.mu.RUnlock()
+	if !ok {
+		return nil, nil, ErrFileNotFound
+	}
+
+	f, err := os.Open(st.objectPath(id))
+	if err != nil {
+		return nil, nil, fmt.Errorf("打开文件失败: %v", err)
+	}
+	copied := *info
+	return f, &copied, nil
+}
+
+// List 按上传时间返回所有文件的元数据
+func (st *UploadStore) List() []*FileInfo {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	files := make([]*FileInfo, 0, len(st.files))
+	for _, f := range st.files {
+		copied := *f
+		files = append(files, &copied)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].UploadedAt.Equal(files[j].UploadedAt) {
+			return files[i].ID < files[j].ID
+		}
+		return files[i].UploadedAt.Before(files[j].UploadedAt)
+	})
+	return files
+}
+
+// Delete 删除文件
+func (st *UploadStore) Delete(id string) error {
+	if !validFileID(id) {
+		return ErrFileNotFound
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	info, ok := st.files[id]
+	if !ok {
+		return ErrFileNotFound
+	}
+	delete(st.files, id)
+	if err := st.writeIndex(); err != nil {
+		st.files[id] = info
+		return err
+	}
+	if err := os.Remove(st.objectPath(id)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("删除文件失败: %v", err)
+	}
+	return nil
+}
+
+// writeIndex 先写临时文件再重命名，保证索引文件始终完整，调用方需持有写锁
+func (st *UploadStore) writeIndex() error {
+	files := make([]*FileInfo, 0, len(st.files))
+	for _, f := range st.files {
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].ID < files[j].ID })
+
+	data, err := json.MarshalIndent(files, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(st.dir, uploadIndexFileName)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("写入上传索引失败: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("写入上传索引失败: %v", err)
+	}
+	syncDir(st.dir)
+	return nil
+}
+
+// lazyUploadStore 在首次使用时打开上传存储。没有配置目录时使用临时目录，cleanup 时删除
+type lazyUploadStore struct {
+	mu      sync.Mutex
+	store   *UploadStore
+	tempDir string
+}
+
+// get 返回上传存储，必要时按配置的目录打开
+func (l *lazyUploadStore) get(dir string) (*UploadStore, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.store != nil {
+		return l.store, nil
+	}
+
+	if dir == "" {
+		tempDir, err := os.MkdirTemp("", "simple-server-uploads-")
+		if err != nil {
+			return nil, fmt.Errorf("创建临时上传目录失败: %v", err)
+		}
+		l.tempDir = tempDir
+		dir = tempDir
+	}
+	store, err := OpenUploadStore(dir)
+	if err != nil {
+		return nil, err
+	}
+	l.store = store
+	return store, nil
+}
+
+// cleanup 删除自动创建的临时上传目录
+func (l *lazyUploadStore) cleanup() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.tempDir != "" {
+		os.RemoveAll(l.tempDir)
+		l.tempDir = ""
+		l.store = nil
+	}
+}
+
+// maxUploadBytes 返回单个上传文件的最大字节数，未配置时使用默认值
+func (s *SimpleServer) maxUploadBytes() int64 {
+	if s.config.MaxUploadBytes > 0 {
+		return s.config.MaxUploadBytes
+	}
+	return DefaultMaxUploadBytes
+}
+
+// 处理文件上传：请求体边读边写入磁盘，不在内存中缓存整个文件
+func (s *SimpleServer) handleUpload(w http.ResponseWriter, r *http.Request) {
+	filename := r.Header.Get("X-Filename")
+	if filename == "" {
+		s.sendResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: "缺少文件名",
+		})
+		return
+	}
+	name, err := sanitizeFileName(filename)
+	if err != nil {
+		s.sendResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	store, err := s.uploads.get(s.config.UploadDir)
+	if err != nil {
+		s.sendFileError(w, err)
+		return
+	}
+
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	body := http.MaxBytesReader(w, r.Body, s.maxUploadBytes())
+	info, err := store.Save(name, contentType, body)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			s.sendResponse(w, http.StatusRequestEntityTooLarge, APIResponse{
+				Success: false,
+				Message: fmt.Sprintf("文件超过大小限制（%d 字节）", tooLarge.Limit),
+			})
+			return
+		}
+		s.sendFileError(w, err)
+		return
+	}
+
+	s.metrics.uploadBytes.add(float64(info.Size))
+	s.audit(r, AuditFileUpload, "files/"+info.ID, nil, info)
+	s.logger.Info("接收到文件上传",
+		slog.String("request_id", w.Header().Get(RequestIDHeader)),
+		slog.String("file_id", info.ID),
+		slog.String("filename", info.Name),
+		slog.Int64("size", info.Size),
+	)
+
+	s.sendResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "文件上传成功",
+		Data:    info,
+	})
+}
+
+// 列出上传的文件
+func (s *SimpleServer) listFiles(w http.ResponseWriter, r *http.Request) {
+	store, err := s.uploads.get(s.config.UploadDir)
+	if err != nil {
+		s.sendFileError(w, err)
+		return
+	}
+
+	s.sendResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "获取文件列表成功",
+		Data:    store.List(),
+	})
+}
+
+// 下载文件，通过 http.ServeContent 支持 Range 和条件请求
+func (s *SimpleServer) downloadFile(w http.ResponseWriter, r *http.Request) {
+	store, err := s.uploads.get(s.config.UploadDir)
+	if err != nil {
+		s.sendFileError(w, err)
+		return
+	}
+	f, info, err := store.Open(r.PathValue("id"))
+	if err != nil {
+		s.sendFileError(w, err)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", info.ContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": info.Name}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	// 内容不可变，哈希即可作为强ETag
+	w.Header().Set("ETag", `"`+info.ID+`"`)
+	http.ServeContent(w, r, "", info.UploadedAt, f)
+}
+
+// 删除文件
+func (s *SimpleServer) deleteFile(w http.ResponseWriter, r *http.Request) {
+	store, err := s.uploads.get(s.config.UploadDir)
+	if err != nil {
+		s.sendFileError(w, err)
+		return
+	}
+	if err := store.Delete(r.PathValue("id")); err != nil {
+		s.sendFileError(w, err)
+		return
+	}
+	s.audit(r, AuditFileDelete, "files/"+r.PathValue("id"), nil, nil)
+
+	s.sendResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "删除文件成功",
+	})
+}
+
+// sendFileError 把上传存储的错误转换为响应
+func (s *SimpleServer) sendFileError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrFileNotFound) {
+		s.sendResponse(w, http.StatusNotFound, APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	s.logger.Error("上传存储错误",
+		slog.String("request_id", w.Header().Get(RequestIDHeader)),
+		slog.String("error", err.Error()),
+	)
+	s.sendResponse(w, http.StatusInternalServerError, APIResponse{
+		Success: false,
+		Message: "服务器内部错误",
+	})
+}
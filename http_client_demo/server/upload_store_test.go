@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newUploadTestServer 创建上传目录和大小限制可控的服务器
+func newUploadTestServer(t *testing.T, dir string, maxBytes int64) *SimpleServer {
+	config := DefaultServerConfig("0")
+	config.UploadDir = dir
+	config.MaxUploadBytes = maxBytes
+	return NewSimpleServerWithConfig(config, NewMemoryUserStore())
+}
+
+// upload 上传文件并返回响应
+func upload(s *SimpleServer, filename, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/v2/upload", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer your-api-key-here")
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set("X-Filename", filename)
+	rec := httptest.NewRecorder()
+	s.mux.ServeHTTP(rec, req)
+	return rec
+}
+
+// TestSanitizeFileName 测试文件名清理
+func TestSanitizeFileName(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		ok       bool
+	}{
+		{"report.pdf", "report.pdf", true},
+		{"../../etc/passwd", "passwd", true},
+		{`..\..\windows\system.ini`, "system.ini", true},
+		{"/tmp/a b.txt", "a b.txt", true},
+		{"evil\r\nname.txt", "evilname.txt", true},
+		{"报告.docx", "报告.docx", true},
+		{"..", "", false},
+		{"dir/", "", false},
+		{strings.Repeat("a", 300), "", false},
+	}
+	for _, tt := range tests {
+		got, err := sanitizeFileName(tt.input)
+		if (err == nil) != tt.ok || got != tt.expected {
+			t.Errorf("sanitizeFileName(%q) = %q, %v，期望 %q", tt.input, got, err, tt.expected)
+		}
+	}
+}
+
+// TestUploadDownloadDelete 测试上传、列表、按范围下载和删除
+func TestUploadDownloadDelete(t *testing.T) {
+	dir := t.TempDir()
+	s := newUploadTestServer(t, dir, 1024)
+
+	rec := upload(s, "../secret/hello.txt", "hello, world")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("上传失败，状态码 %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp struct {
+		Data FileInfo `json:"data"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	info := resp.Data
+	if info.Name != "hello.txt" || info.Size != 12 || len(info.ID) != 64 {
+		t.Fatalf("上传结果不正确: %+v", info)
+	}
+	if _, err := os.Stat(filepath.Join(dir, uploadObjectsDir, info.ID[:2], info.ID[2:])); err != nil {
+		t.Errorf("文件应按内容哈希保存: %v", err)
+	}
+
+	// 相同内容只保存一份
+	upload(s, "copy.txt", "hello, world")
+	store, _ := s.uploads.get(dir)
+	if files := store.List(); len(files) != 1 {
+		t.Errorf("相同内容期望只有 1 个文件，实际 %d", len(files))
+	}
+
+	// 按范围下载
+	req := httptest.NewRequest(http.MethodGet, "/v2/files/"+info.ID, nil)
+	req.Header.Set("Authorization", "Bearer your-api-key-here")
+	req.Header.Set("Range", "bytes=7-11")
+	rec = httptest.NewRecorder()
+	s.mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusPartialContent || rec.Body.String() != "world" {
+		t.Errorf("期望 206 和 world，实际 %d %q", rec.Code, rec.Body.String())
+	}
+	if cd := rec.Header().Get("Content-Disposition"); !strings.Contains(cd, "hello.txt") {
+		t.Errorf("Content-Disposition 应包含文件名，实际 %q", cd)
+	}
+
+	// 重新打开目录后索引仍然有效
+	reopened, err := OpenUploadStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files := reopened.List(); len(files) != 1 || files[0].Name != "hello.txt" {
+		t.Errorf("重新打开后索引不正确: %+v", files)
+	}
+
+	req = httptest.NewRequest(http.MethodDelete, "/v2/files/"+info.ID, nil)
+	req.Header.Set("Authorization", "Bearer your-api-key-here")
+	rec = httptest.NewRecorder()
+	s.mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("删除失败，状态码 %d", rec.Code)
+	}
+
+	for _, id := range []string{info.ID, "..%2F..%2Findex.json", strings.Repeat("z", 64)} {
+		req = httptest.NewRequest(http.MethodGet, "/v2/files/"+id, nil)
+		req.Header.Set("Authorization", "Bearer your-api-key-here")
+		rec = httptest.NewRecorder()
+		s.mux.ServeHTTP(rec, req)
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("GET /v2/files/%s 期望状态码 404，实际 %d", id, rec.Code)
+		}
+	}
+}
+
+// TestUploadTooLarge 测试超过大小限制返回413且不留下临时文件
+func TestUploadTooLarge(t *testing.T) {
+	dir := t.TempDir()
+	s := newUploadTestServer(t, dir, 8)
+
+	rec := upload(s, "big.bin", "0123456789")
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("期望状态码 413，实际 %d", rec.Code)
+	}
+	entries, _ := os.ReadDir(filepath.Join(dir, uploadTmpDir))
+	if len(entries) != 0 {
+		t.Errorf("超出限制的上传应清理临时文件，剩余 %d 个", len(entries))
+	}
+
+	if rec := upload(s, "..", "data"); rec.Code != http.StatusBadRequest {
+		t.Errorf("非法文件名期望状态码 400，实际 %d", rec.Code)
+	}
+
+	// 未配置大小限制时使用默认值，而不是拒绝所有上传
+	s = newUploadTestServer(t, t.TempDir(), 0)
+	if rec := upload(s, "big.bin", "0123456789"); rec.Code != http.StatusOK {
+		t.Errorf("大小限制为0时期望使用默认值，实际 %d %s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"http_client_demo/api"
+)
+
+// UserQuery 用户搜索条件
+type UserQuery = api.UserQuery
+
+// idSet 用户ID集合
+type idSet map[int]struct{}
+
+// nameEntry 按用户名排序的索引项，key 为小写用户名
+type nameEntry struct {
+	key string
+	id  int
+}
+
+// IndexedUserStore 在任意 UserStore 外维护内存二级索引的装饰器：
+// 邮箱到用户的映射、按用户名排序的列表（前缀查找）和用户名、邮箱的三元组倒排索引（子串查找）。
+// 读操作直接转发给内部存储；写操作在索引锁内先写内部存储再更新索引，保证两者一致。
+// 邮箱不区分大小写唯一，创建和更新时由邮箱索引检查，因此索引在第一次搜索或写入时从内部存储加载
+type IndexedUserStore struct {
+	inner UserStore
+
+	mu      sync.RWMutex
+	loaded  bool
+	users   map[int]*User
+	byEmail map[string]idSet
+	names   []nameEntry
+	grams   map[string]idSet
+}
+
+// NewIndexedUserStore 为用户存储添加搜索索引
+func NewIndexedUserStore(inner UserStore) *IndexedUserStore {
+	return &IndexedUserStore{inner: inner}
+}
+
+// Get 获取用户
+func (st *IndexedUserStore) Get(id int) (*User, error) {
+	return st.inner.Get(id)
+}
+
+// List 列出所有用户
+func (st *IndexedUserStore) List() ([]*User, error) {
+	return st.inner.List()
+}
+
+// Create 创建用户并加入索引
+func (st *IndexedUserStore) Create(user *User) (*User, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if err := st.loadLocked(); err != nil {
+		return nil, err
+	}
+	if st.emailTaken(user.Email, 0) {
+		return nil, ErrEmailTaken
+	}
+	created, err := st.inner.Create(user)
+	if err != nil {
+		return nil, err
+	}
+	st.add(created)
+	return created, nil
+}
+
+// Update 更新用户并重建该用户的索引项
+func (st *IndexedUserStore) Update(user *User) (*User, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if err := st.loadLocked(); err != nil {
+		return nil, err
+	}
+	if st.emailTaken(user.Email, user.ID) {
+		return nil, ErrEmailTaken
+	}
+	updated, err := st.inner.Update(user)
+	if err != nil {
+		return nil, err
+	}
+	st.remove(updated.ID)
+	st.add(updated)
+	return updated, nil
+}
+
+// Delete 删除用户并移除索引项
+func (st *IndexedUserStore) Delete(id int, version int) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if err := st.inner.Delete(id, version); err != nil {
+		return err
+	}
+	if st.loaded {
+		st.remove(id)
+	}
+	return nil
+}
+
+// Ping 检查内部存储是否可用
+func (st *IndexedUserStore) Ping() error {
+	if p, ok := st.inner.(pinger); ok {
+		return p.Ping()
+	}
+	return nil
+}
+
+// Search 按条件搜索用户。先用索引缩小候选范围，再逐个校验全部条件；
+// 少于3个字符的子串无法使用三元组索引，在内存中的索引副本上逐个比较
+func (st *IndexedUserStore) Search(q UserQuery) ([]*User, error) {
+	if err := st.load(); err != nil {
+		return nil, err
+	}
+
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	text := strings.ToLower(q.Text)
+	email := strings.ToLower(q.Email)
+	prefix := strings.ToLower(q.NamePrefix)
+
+	// candidates 为nil表示尚未被任何索引限制
+	var candidates idSet
+	narrow := func(ids idSet) {
+		if candidates == nil {
+			candidates = make(idSet, len(ids))
+			for id := range ids {
+				candidates[id] = struct{}{}
+			}
+			return
+		}
+		for id := range candidates {
+			if _, ok := ids[id]; !ok {
+				delete(candidates, id)
+			}
+		}
+	}
+	if email != "" {
+		narrow(st.byEmail[email])
+	}
+	if prefix != "" {
+		narrow(st.namePrefix(prefix))
+	}
+	if grams := trigrams(text); len(grams) > 0 {
+		for _, g := range grams {
+			narrow(st.grams[g])
+		}
+	}
+	if candidates == nil {
+		candidates = make(idSet, len(st.users))
+		for id := range st.users {
+			candidates[id] = struct{}{}
+		}
+	}
+
+	result := make([]*User, 0, len(candidates))
+	for id := range candidates {
+		user := st.users[id]
+		name, mail := strings.ToLower(user.Name), strings.ToLower(user.Email)
+		if email != "" && mail != email {
+			continue
+		}
+		if prefix != "" && !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if text != "" && !strings.Contains(name, text) && !strings.Contains(mail, text) {
+			continue
+		}
+		copied := *user
+		result = append(result, &copied)
+	}
+	sortUsers(result, q.Sort)
+	return result, nil
+}
+
+// load 第一次搜索时从内部存储加载索引
+func (st *IndexedUserStore) load() error {
+	st.mu.RLock()
+	loaded := st.loaded
+	st.mu.RUnlock()
+	if loaded {
+		return nil
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.loadLocked()
+}
+
+// loadLocked 索引尚未加载时从内部存储加载，调用方需持有写锁
+func (st *IndexedUserStore) loadLocked() error {
+	if st.loaded {
+		return nil
+	}
+	users, err := st.inner.List()
+	if err != nil {
+		return err
+	}
+	st.users = make(map[int]*User, len(users))
+	st.byEmail = make(map[string]idSet)
+	st.grams = make(map[string]idSet)
+	st.names = nil
+	for _, user := range users {
+		st.add(user)
+	}
+	st.loaded = true
+	return nil
+}
+
+// emailTaken 判断邮箱是否已被 id 以外的用户使用，调用方需持有锁
+func (st *IndexedUserStore) emailTaken(email string, id int) bool {
+	for other := range st.byEmail[strings.ToLower(email)] {
+		if other != id {
+			return true
+		}
+	}
+	return false
+}
+
+// add 把用户加入各个索引，调用方需持有写锁
+func (st *IndexedUserStore) add(user *User) {
+	copied := *user
+	st.users[user.ID] = &copied
+
+	email := strings.ToLower(user.Email)
+	if st.byEmail[email] == nil {
+		st.byEmail[email] = make(idSet)
+	}
+	st.byEmail[email][user.ID] = struct{}{}
+
+	entry := nameEntry{key: strings.ToLower(user.Name), id: user.ID}
+	i, _ := slices.BinarySearchFunc(st.names, entry, compareNameEntry)
+	st.names = slices.Insert(st.names, i, entry)
+
+	for _, g := range userTrigrams(user) {
+		if st.grams[g] == nil {
+			st.grams[g] = make(idSet)
+		}
+		st.grams[g][user.ID] = struct{}{}
+	}
+}
+
+// remove 把用户从各个索引中移除，调用方需持有写锁
+func (st *IndexedUserStore) remove(id int) {
+	user, ok := st.users[id]
+	if !ok {
+		return
+	}
+	delete(st.users, id)
+
+	email := strings.ToLower(user.Email)
+	delete(st.byEmail[email], id)
+	if len(st.byEmail[email]) == 0 {
+		delete(st.byEmail, email)
+	}
+
+	entry := nameEntry{key: strings.ToLower(user.Name), id: id}
+	if i, found := slices.BinarySearchFunc(st.names, entry, compareNameEntry); found {
+		st.names = slices.Delete(st.names, i, i+1)
+	}
+
+	for _, g := range userTrigrams(user) {
+		delete(st.grams[g], id)
+		if len(st.grams[g]) == 0 {
+			delete(st.grams, g)
+		}
+	}
+}
+
+// namePrefix 在有序的用户名列表中二分查找前缀范围
+func (st *IndexedUserStore) namePrefix(prefix string) idSet {
+	ids := make(idSet)
+	i := sort.Search(len(st.names), func(i int) bool { return st.names[i].key >= prefix })
+	for ; i < len(st.names) && strings.HasPrefix(st.names[i].key, prefix); i++ {
+		ids[st.names[i].id] = struct{}{}
+	}
+	return ids
+}
+
+// compareNameEntry 先按用户名再按ID排序
+func compareNameEntry(a, b nameEntry) int {
+	if c := strings.Compare(a.key, b.key); c != 0 {
+		return c
+	}
+	return a.id - b.id
+}
+
+// userTrigrams 返回用户名和邮箱的全部三元组（去重）
+func userTrigrams(user *User) []string {
+	grams := trigrams(strings.ToLower(user.Name))
+	grams = append(grams, trigrams(strings.ToLower(user.Email))...)
+	slices.Sort(grams)
+	return slices.Compact(grams)
+}
+
+// trigrams 按字符（而非字节）切分出连续三个字符的片段，不足3个字符时返回nil
+func trigrams(s string) []string {
+	runes := []rune(s)
+	if len(runes) < 3 {
+		return nil
+	}
+	grams := make([]string, 0, len(runes)-2)
+	for i := 0; i+3 <= len(runes); i++ {
+		grams = append(grams, string(runes[i:i+3]))
+	}
+	return grams
+}
+
+// sortUsers 按搜索条件指定的方式排序，用户名相同时按ID升序
+func sortUsers(users []*User, by api.UserSort) {
+	slices.SortFunc(users, func(a, b *User) int {
+		switch by {
+		case api.SortByIDDesc:
+			return b.ID - a.ID
+		case api.SortByName, api.SortByNameDesc:
+			c := strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+			if by == api.SortByNameDesc {
+				c = -c
+			}
+			if c != 0 {
+				return c
+			}
+		}
+		return a.ID - b.ID
+	})
+}
+
+// searchUsers 搜索用户（v2），fields 参数指定时只返回选中的字段
+func (s *SimpleServer) searchUsers(w http.ResponseWriter, r *http.Request) {
+	q, err := api.ParseUserQuery(r.URL.Query())
+	if err != nil {
+		s.sendResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	users, err := s.users.Search(q)
+	if err != nil {
+		s.sendStoreError(w, err)
+		return
+	}
+
+	var data interface{} = users
+	if len(q.Fields) > 0 {
+		projected := make([]map[string]interface{}, len(users))
+		for i, user := range users {
+			projected[i] = projectUser(user, q.Fields)
+		}
+		data = projected
+	}
+	s.sendResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "搜索用户成功",
+		Data:    data,
+	})
+}
+
+// projectUser 只保留选中的用户字段
+func projectUser(user *User, fields []string) map[string]interface{} {
+	m := make(map[string]interface{}, len(fields))
+	for _, field := range fields {
+		switch field {
+		case "id":
+			m[field] = user.ID
+		case "name":
+			m[field] = user.Name
+		case "email":
+			m[field] = user.Email
+		}
+	}
+	return m
+}
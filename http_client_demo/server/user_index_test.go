@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"http_client_demo/api"
+)
+
+// searchIDs 执行搜索并返回结果的用户ID
+func searchIDs(t *testing.T, st *IndexedUserStore, q UserQuery) []int {
+	t.Helper()
+	users, err := st.Search(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]int, len(users))
+	for i, u := range users {
+		ids[i] = u.ID
+	}
+	return ids
+}
+
+// TestIndexedUserStoreSearch 测试各种搜索条件以及写操作后索引保持一致
+func TestIndexedUserStoreSearch(t *testing.T) {
+	inner := NewMemoryUserStore()
+	// 加载索引之前写入的数据在第一次搜索时载入
+	inner.Create(&User{Name: "Zhang San", Email: "zhangsan@example.com"})
+	st := NewIndexedUserStore(inner)
+	st.Create(&User{Name: "Li Si", Email: "LiSi@Example.com"})
+	st.Create(&User{Name: "Zhang Wei", Email: "wei@example.org"})
+	st.Create(&User{Name: "王五", Email: "wangwu@example.com"})
+
+	tests := []struct {
+		name  string
+		query UserQuery
+		want  []int
+	}{
+		{"全部", UserQuery{}, []int{1, 2, 3, 4}},
+		{"子串匹配用户名", UserQuery{Text: "zhang"}, []int{1, 3}},
+		{"子串匹配邮箱", UserQuery{Text: "example.org"}, []int{3}},
+		{"短子串", UserQuery{Text: "an"}, []int{1, 3, 4}},
+		{"中文子串", UserQuery{Text: "王五"}, []int{4}},
+		{"邮箱不区分大小写", UserQuery{Email: "lisi@example.com"}, []int{2}},
+		{"用户名前缀", UserQuery{NamePrefix: "zhang "}, []int{1, 3}},
+		{"组合条件", UserQuery{NamePrefix: "zhang", Text: "wei"}, []int{3}},
+		{"无结果", UserQuery{Text: "nobody"}, []int{}},
+		{"按用户名排序", UserQuery{Sort: api.SortByName}, []int{2, 1, 3, 4}},
+		{"按用户名降序", UserQuery{Sort: api.SortByNameDesc}, []int{4, 3, 1, 2}},
+		{"按ID降序", UserQuery{Sort: api.SortByIDDesc}, []int{4, 3, 2, 1}},
+	}
+	for _, tt := range tests {
+		if got := searchIDs(t, st, tt.query); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: 期望 %v，实际 %v", tt.name, tt.want, got)
+		}
+	}
+
+	// 更新和删除后旧的索引项不再命中
+	st.Update(&User{ID: 3, Name: "Chen Wei", Email: "wei@example.org"})
+	if got := searchIDs(t, st, UserQuery{NamePrefix: "zhang"}); !reflect.DeepEqual(got, []int{1}) {
+		t.Errorf("更新后期望 [1]，实际 %v", got)
+	}
+	if got := searchIDs(t, st, UserQuery{Text: "chen"}); !reflect.DeepEqual(got, []int{3}) {
+		t.Errorf("更新后期望 [3]，实际 %v", got)
+	}
+	st.Delete(1, 0)
+	if got := searchIDs(t, st, UserQuery{Text: "zhangsan"}); len(got) != 0 {
+		t.Errorf("删除后期望没有结果，实际 %v", got)
+	}
+	if len(st.byEmail) != 3 || len(st.names) != 3 {
+		t.Errorf("索引项未清理: %d 个邮箱，%d 个用户名", len(st.byEmail), len(st.names))
+	}
+}
+
+// TestSearchUsersHandler 测试搜索接口的字段选择和参数校验
+func TestSearchUsersHandler(t *testing.T) {
+	s := NewSimpleServer("0")
+	s.initTestData()
+
+	get := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/v2/users?"+query, nil)
+		req.Header.Set("Authorization", "Bearer your-api-key-here")
+		rec := httptest.NewRecorder()
+		s.mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("q=example.com&sort=-id&fields=id,name")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("期望状态码 200，实际 %d", rec.Code)
+	}
+	var resp struct {
+		Data []map[string]interface{} `json:"data"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if len(resp.Data) != 3 {
+		t.Fatalf("期望 3 个用户，实际 %d", len(resp.Data))
+	}
+	if first := resp.Data[0]; first["id"] != float64(3) || first["name"] != "王五" || first["email"] != nil {
+		t.Errorf("字段选择或排序不正确: %v", first)
+	}
+
+	for _, query := range []string{"sort=age", "fields=password"} {
+		if rec := get(query); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: 期望状态码 400，实际 %d", query, rec.Code)
+		}
+	}
+}
+
+// TestIndexedUserStoreUniqueEmail 测试邮箱不区分大小写唯一，更新时可以保留自己的邮箱
+func TestIndexedUserStoreUniqueEmail(t *testing.T) {
+	inner := NewMemoryUserStore()
+	inner.Create(&User{Name: "张三", Email: "zhangsan@example.com"})
+	st := NewIndexedUserStore(inner)
+	lisi, err := st.Create(&User{Name: "李四", Email: "lisi@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := st.Create(&User{Name: "张三", Email: "ZhangSan@Example.com"}); !errors.Is(err, ErrEmailTaken) {
+		t.Errorf("创建重复邮箱期望 ErrEmailTaken，实际 %v", err)
+	}
+	update := *lisi
+	update.Email = "ZHANGSAN@example.com"
+	if _, err := st.Update(&update); !errors.Is(err, ErrEmailTaken) {
+		t.Errorf("更新为其他用户的邮箱期望 ErrEmailTaken，实际 %v", err)
+	}
+	update.Email = "LiSi@example.com"
+	if _, err := st.Update(&update); err != nil {
+		t.Errorf("只修改自己邮箱的大小写期望成功，实际 %v", err)
+	}
+	if ids := searchIDs(t, st, UserQuery{Email: "zhangsan@example.com"}); !reflect.DeepEqual(ids, []int{1}) {
+		t.Errorf("期望只有一个用户使用该邮箱，实际 %v", ids)
+	}
+
+	// 通过接口创建重复邮箱返回 409
+	s := newRoleTestServer(t)
+	req := httptest.NewRequest(http.MethodPost, "/v2/users", strings.NewReader(`{"name":"冒名","email":"Admin@example.com"}`))
+	req.Header.Set("Authorization", "Bearer your-api-key-here")
+	rec := httptest.NewRecorder()
+	s.mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusConflict {
+		t.Errorf("重复邮箱期望 409，实际 %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+// TestEncryptedUserUniqueEmail 测试多次创建加密用户不会因为邮箱重复而失败
+func TestEncryptedUserUniqueEmail(t *testing.T) {
+	s := newRoleTestServer(t)
+	create := func(data string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v2/users/encrypted", strings.NewReader(`{"encrypted_data":"`+data+`"}`))
+		req.Header.Set("Authorization", "Bearer your-api-key-here")
+		rec := httptest.NewRecorder()
+		s.mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	emails := map[string]bool{}
+	for _, data := range []string{"Y2lwaGVyMQ==", "Y2lwaGVyMg=="} {
+		rec := create(data)
+		var resp struct {
+			Data User `json:"data"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		if rec.Code != http.StatusCreated || emails[resp.Data.Email] {
+			t.Fatalf("期望创建邮箱不同的加密用户，实际 %d %s", rec.Code, rec.Body.String())
+		}
+		emails[resp.Data.Email] = true
+	}
+	if rec := create("Y2lwaGVyMQ=="); rec.Code != http.StatusConflict {
+		t.Errorf("原样重发的密文期望 409，实际 %d", rec.Code)
+	}
+}
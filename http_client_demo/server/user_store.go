@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+// ErrUserNotFound 用户不存在
+var ErrUserNotFound = errors.New("用户不存在")
+
+// ErrEmailTaken 邮箱（不区分大小写）已被其他用户使用
+var ErrEmailTaken = errors.New("邮箱已被使用")
+
+// ErrVersionConflict 用户版本号与期望的不一致，说明已被其他请求修改
+var ErrVersionConflict = errors.New("用户版本冲突")
+
+// UserStore 用户存储接口，实现必须支持并发调用。
+// 传入和返回的都是副本，调用方修改不会影响存储中的数据。
+// 版本号由存储维护：创建时为1，每次更新加1
+type UserStore interface {
+	Get(id int) (*User, error)
+	// Create 分配新ID并保存用户，返回保存后的用户
+	Create(user *User) (*User, error)
+	// Update 按ID覆盖已有用户，用户不存在时返回 ErrUserNotFound。
+	// user.Version 不为0时必须等于当前版本，否则返回 ErrVersionConflict；
+	// user.PasswordHash 和 user.Role 为空时保留原有的值
+	Update(user *User) (*User, error)
+	// Delete 删除用户，version 不为0时必须等于当前版本，否则返回 ErrVersionConflict
+	Delete(id int, version int) error
+	// List 按ID升序返回所有用户
+	List() ([]*User, error)
+}
+
+// MemoryUserStore 基于互斥锁的内存用户存储
+type MemoryUserStore struct {
+	mu     sync.RWMutex
+	users  map[int]*User
+	nextID int
+}
+
+// NewMemoryUserStore 创建内存用户存储
+func NewMemoryUserStore() *MemoryUserStore {
+	return &MemoryUserStore{
+		users:  make(map[int]*User),
+		nextID: 1,
+	}
+}
+
+// Get 获取用户
+func (st *MemoryUserStore) Get(id int) (*User, error) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	user, ok := st.users[id]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	copied := *user
+	return &copied, nil
+}
+
+// Create 创建用户，ID单调递增，删除后也不会复用
+func (st *MemoryUserStore) Create(user *User) (*User, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	created := *user
+	created.ID = st.nextID
+	created.Version = 1
+	st.nextID++
+	st.users[created.ID] = &created
+
+	result := created
+	return &result, nil
+}
+
+// Update 更新用户
+func (st *MemoryUserStore) Update(user *User) (*User, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	current, ok := st.users[user.ID]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	if user.Version != 0 && user.Version != current.Version {
+		return nil, ErrVersionConflict
+	}
+	updated := *user
+	updated.Version = current.Version + 1
+	if updated.PasswordHash == "" {
+		updated.PasswordHash = current.PasswordHash
+	}
+	if updated.Role == "" {
+		updated.Role = current.Role
+	}
+	st.users[user.ID] = &updated
+
+	result := updated
+	return &result, nil
+}
+
+// Delete 删除用户
+func (st *MemoryUserStore) Delete(id int, version int) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	current, ok := st.users[id]
+	if !ok {
+		return ErrUserNotFound
+	}
+	if version != 0 && version != current.Version {
+		return ErrVersionConflict
+	}
+	delete(st.users, id)
+	return nil
+}
+
+// List 列出所有用户
+func (st *MemoryUserStore) List() ([]*User, error) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	users := make([]*User, 0, len(st.users))
+	for _, user := range st.users {
+		copied := *user
+		users = append(users, &copied)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users, nil
+}
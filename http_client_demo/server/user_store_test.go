@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestConcurrentCreateUsers 并发创建用户，配合 go test -race 验证没有数据竞争且ID不冲突
+func TestConcurrentCreateUsers(t *testing.T) {
+	// 压力测试关注存储的并发安全，关闭限流
+	config := DefaultServerConfig("0")
+	config.RateLimit = RateLimitConfig{}
+	s := NewSimpleServerWithConfig(config, NewMemoryUserStore())
+	s.initTestData()
+
+	const workers, perWorker = 20, 25
+	var wg sync.WaitGroup
+	ids := make(chan int, workers*perWorker)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for j := 0; j < perWorker; j++ {
+				body := fmt.Sprintf(`{"name":"用户%d_%d","email":"u%d_%d@example.com"}`, worker, j, worker, j)
+				req := httptest.NewRequest(http.MethodPost, "/v2/users", strings.NewReader(body))
+				req.Header.Set("Authorization", "Bearer your-api-key-here")
+				rec := httptest.NewRecorder()
+				s.mux.ServeHTTP(rec, req)
+				if rec.Code != http.StatusCreated {
+					t.Errorf("创建用户失败，状态码 %d", rec.Code)
+					continue
+				}
+
+				var resp struct {
+					Data User `json:"data"`
+				}
+				json.Unmarshal(rec.Body.Bytes(), &resp)
+				ids <- resp.Data.ID
+
+				// 同时读取和更新已有用户
+				read := httptest.NewRequest(http.MethodGet, "/v2/users/1", nil)
+				read.Header.Set("Authorization", "Bearer your-api-key-here")
+				s.mux.ServeHTTP(httptest.NewRecorder(), read)
+
+				update := httptest.NewRequest(http.MethodPut, "/v2/users/2", strings.NewReader(body))
+				update.Header.Set("Authorization", "Bearer your-api-key-here")
+				update.Header.Set("If-Match", "*")
+				s.mux.ServeHTTP(httptest.NewRecorder(), update)
+
+				s.store.List()
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(ids)
+
+	seen := make(map[int]bool)
+	for id := range ids {
+		if seen[id] {
+			t.Errorf("用户ID %d 被重复分配", id)
+		}
+		seen[id] = true
+	}
+
+	users, _ := s.store.List()
+	if expected := workers*perWorker + 3; len(users) != expected {
+		t.Errorf("期望 %d 个用户，实际 %d", expected, len(users))
+	}
+}
+
+// TestMemoryUserStoreIDsNotReused 测试删除用户后ID不会被复用
+func TestMemoryUserStoreIDsNotReused(t *testing.T) {
+	store := NewMemoryUserStore()
+	first, _ := store.Create(&User{Name: "张三"})
+	second, _ := store.Create(&User{Name: "李四"})
+	if err := store.Delete(second.ID, 0); err != nil {
+		t.Fatal(err)
+	}
+	third, _ := store.Create(&User{Name: "王五"})
+
+	if first.ID != 1 || second.ID != 2 || third.ID != 3 {
+		t.Errorf("期望ID依次为 1、2、3，实际 %d、%d、%d", first.ID, second.ID, third.ID)
+	}
+	if err := store.Delete(second.ID, 0); err != ErrUserNotFound {
+		t.Errorf("重复删除应返回 ErrUserNotFound，实际 %v", err)
+	}
+}
+
+// TestMemoryUserStoreReturnsCopies 测试修改返回值不会影响存储
+func TestMemoryUserStoreReturnsCopies(t *testing.T) {
+	store := NewMemoryUserStore()
+	created, _ := store.Create(&User{Name: "张三"})
+	created.Name = "被修改"
+
+	got, _ := store.Get(created.ID)
+	if got.Name != "张三" {
+		t.Errorf("存储中的用户被外部修改: %s", got.Name)
+	}
+}
+
+// TestUpdateRequiresIfMatch 测试更新和删除必须带 If-Match，基于旧版本的修改返回412
+func TestUpdateRequiresIfMatch(t *testing.T) {
+	s := NewSimpleServer("0")
+	s.initTestData()
+
+	send := func(method, ifMatch, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/v2/users/2", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer your-api-key-here")
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		rec := httptest.NewRecorder()
+		s.mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	etag := send(http.MethodGet, "", "").Header().Get("ETag")
+	if etag != `"1"` {
+		t.Fatalf(`期望 ETag "1"，实际 %q`, etag)
+	}
+
+	body := `{"name":"李四四","email":"lisi@example.com"}`
+	if rec := send(http.MethodPut, "", body); rec.Code != http.StatusPreconditionRequired {
+		t.Errorf("缺少 If-Match 期望状态码 428，实际 %d", rec.Code)
+	}
+	if rec := send(http.MethodDelete, "", ""); rec.Code != http.StatusPreconditionRequired {
+		t.Errorf("删除缺少 If-Match 期望状态码 428，实际 %d", rec.Code)
+	}
+
+	// 两个管理员基于同一个版本修改，只有第一个成功
+	first := send(http.MethodPut, etag, body)
+	if first.Code != http.StatusOK || first.Header().Get("ETag") != `"2"` {
+		t.Fatalf(`期望 200 和 ETag "2"，实际 %d %q`, first.Code, first.Header().Get("ETag"))
+	}
+	if rec := send(http.MethodPut, etag, `{"name":"李四","email":"other@example.com"}`); rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("基于旧版本更新期望状态码 412，实际 %d", rec.Code)
+	}
+	if rec := send(http.MethodDelete, etag, ""); rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("基于旧版本删除期望状态码 412，实际 %d", rec.Code)
+	}
+	if rec := send(http.MethodPut, `W/"2"`, body); rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("弱ETag期望状态码 412，实际 %d", rec.Code)
+	}
+	if rec := send(http.MethodPut, `"1", "2"`, body); rec.Code != http.StatusOK {
+		t.Errorf("多个ETag中包含当前版本期望状态码 200，实际 %d", rec.Code)
+	}
+
+	if rec := send(http.MethodDelete, "*", ""); rec.Code != http.StatusOK {
+		t.Errorf("If-Match: * 期望状态码 200，实际 %d", rec.Code)
+	}
+}
@@ -0,0 +1,504 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SyncPolicy 预写日志的落盘策略
+type SyncPolicy int
+
+const (
+	// SyncAlways 每次写入后立即fsync，崩溃不会丢失已确认的写入
+	SyncAlways SyncPolicy = iota
+	// SyncInterval 后台定期fsync，崩溃时最多丢失一个周期内的写入
+	SyncInterval
+	// SyncNever 不主动fsync，由操作系统决定何时落盘
+	SyncNever
+)
+
+const (
+	walFileName      = "users.wal"
+	snapshotFileName = "users.snapshot"
+	// 日志记录头：4字节长度 + 4字节CRC32
+	walHeaderSize = 8
+	// 单条日志记录的最大长度，超过视为损坏
+	maxWALRecordSize = 16 << 20
+)
+
+var walCRCTable = crc32.MakeTable(crc32.Castagnoli)
+
+// FileStoreConfig 文件用户存储配置
+type FileStoreConfig struct {
+	Dir           string
+	Sync          SyncPolicy
+	SyncInterval  time.Duration // SyncInterval 策略的落盘周期，默认1秒
+	SnapshotEvery int           // 每写入多少条日志生成一次快照，默认1000
+}
+
+// walEntry 一条日志记录
+type walEntry struct {
+	Seq  uint64   `json:"seq"`
+	Op   string   `json:"op"` // put / delete
+	User *walUser `json:"user,omitempty"`
+	ID   int      `json:"id,omitempty"`
+}
+
+// walSnapshot 快照文件内容
+type walSnapshot struct {
+	Seq    uint64     `json:"seq"`
+	NextID int        `json:"next_id"`
+	Users  []*walUser `json:"users"`
+}
+
+// walUser 日志和快照中保存的用户。User.PasswordHash 不参与JSON序列化，在这里单独保存
+type walUser struct {
+	*User
+	PasswordHash string `json:"password_hash,omitempty"`
+}
+
+// newWALUser 包装要写入日志的用户
+func newWALUser(user *User) *walUser {
+	return &walUser{User: user, PasswordHash: user.PasswordHash}
+}
+
+// user 还原为用户。旧版本可能以明文保存了密码，加载时丢弃，这些用户需要重新设置密码
+func (w *walUser) user() *User {
+	user := *w.User
+	user.PasswordHash = w.PasswordHash
+	user.Password = ""
+	return &user
+}
+
+// FileUserStore 基于预写日志和快照的持久化用户存储。
+// 每次变更先追加写入带校验和的日志再修改内存，启动时加载最近的快照并重放之后的日志
+type FileUserStore struct {
+	config FileStoreConfig
+
+	mu         sync.RWMutex
+	users      map[int]*User
+	nextID     int
+	seq        uint64
+	wal        *os.File
+	walSize    int64 // 日志中完整记录的总字节数，写入失败时截断到这里
+	walRecords int
+	dirty      bool
+	// failed 日志处于无法确定的状态（截断失败或fsync失败）时的原因，之后拒绝所有写入
+	failed error
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// OpenFileUserStore 打开或创建文件用户存储，并从磁盘恢复数据
+func OpenFileUserStore(config FileStoreConfig) (*FileUserStore, error) {
+	if config.SyncInterval <= 0 {
+		config.SyncInterval = time.Second
+	}
+	if config.SnapshotEvery <= 0 {
+		config.SnapshotEvery = 1000
+	}
+	if err := os.MkdirAll(config.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("创建数据目录失败: %v", err)
+	}
+
+	st := &FileUserStore{
+		config: config,
+		users:  make(map[int]*User),
+		nextID: 1,
+	}
+	if err := st.loadSnapshot(); err != nil {
+		return nil, err
+	}
+	if err := st.replayWAL(); err != nil {
+		return nil, err
+	}
+
+	if config.Sync == SyncInterval {
+		st.stop = make(chan struct{})
+		st.done = make(chan struct{})
+		go st.syncLoop()
+	}
+	return st, nil
+}
+
+// loadSnapshot 加载快照，快照不存在时从空状态开始
+func (st *FileUserStore) loadSnapshot() error {
+	data, err := os.ReadFile(filepath.Join(st.config.Dir, snapshotFileName))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("读取快照失败: %v", err)
+	}
+
+	var snap walSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return fmt.Errorf("解析快照失败: %v", err)
+	}
+	for _, w := range snap.Users {
+		user := w.user()
+		st.users[user.ID] = user
+	}
+	st.seq = snap.Seq
+	st.nextID = snap.NextID
+	return nil
+}
+
+// replayWAL 重放快照之后的日志。遇到不完整或校验失败的记录时，
+// 说明上次写入时发生了崩溃，截断到最后一条完整记录后继续使用
+func (st *FileUserStore) replayWAL() error {
+	path := filepath.Join(st.config.Dir, walFileName)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("打开日志失败: %v", err)
+	}
+
+	r := bufio.NewReader(f)
+	var offset int64
+	for {
+		entry, n, err := readWALRecord(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Printf("日志在偏移 %d 处损坏，截断后续内容: %v", offset, err)
+			if err := f.Truncate(offset); err != nil {
+				f.Close()
+				return fmt.Errorf("截断日志失败: %v", err)
+			}
+			break
+		}
+		offset += n
+		st.walRecords++
+
+		// 快照生成后、日志截断前崩溃时，日志中会残留已包含在快照里的记录
+		if entry.Seq <= st.seq {
+			continue
+		}
+		st.apply(entry)
+	}
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return fmt.Errorf("定位日志失败: %v", err)
+	}
+	st.wal = f
+	st.walSize = offset
+	return nil
+}
+
+// readWALRecord 读取一条日志记录，返回记录和占用的字节数
+func readWALRecord(r io.Reader) (walEntry, int64, error) {
+	var entry walEntry
+
+	header := make([]byte, walHeaderSize)
+	if n, err := io.ReadFull(r, header); err != nil {
+		if err == io.EOF && n == 0 {
+			return entry, 0, io.EOF
+		}
+		return entry, 0, fmt.Errorf("记录头不完整")
+	}
+
+	size := binary.LittleEndian.Uint32(header[0:4])
+	checksum := binary.LittleEndian.Uint32(header[4:8])
+	if size > maxWALRecordSize {
+		return entry, 0, fmt.Errorf("记录长度异常: %d", size)
+	}
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return entry, 0, fmt.Errorf("记录内容不完整")
+	}
+	if crc32.Checksum(payload, walCRCTable) != checksum {
+		return entry, 0, fmt.Errorf("校验和不匹配")
+	}
+	if err := json.Unmarshal(payload, &entry); err != nil {
+		return entry, 0, fmt.Errorf("解析记录失败: %v", err)
+	}
+	return entry, int64(walHeaderSize + size), nil
+}
+
+// apply 把日志记录应用到内存状态
+func (st *FileUserStore) apply(entry walEntry) {
+	switch entry.Op {
+	case "put":
+		user := entry.User.user()
+		// 增加版本号之前写入的记录没有版本号，按版本1处理
+		if user.Version == 0 {
+			user.Version = 1
+		}
+		st.users[user.ID] = user
+		if user.ID >= st.nextID {
+			st.nextID = user.ID + 1
+		}
+	case "delete":
+		delete(st.users, entry.ID)
+	}
+	st.seq = entry.Seq
+}
+
+// commit 写入日志后应用到内存，调用方需持有写锁
+func (st *FileUserStore) commit(entry walEntry) error {
+	if st.wal == nil {
+		return fmt.Errorf("存储已关闭")
+	}
+	if st.failed != nil {
+		return fmt.Errorf("存储不可用: %v", st.failed)
+	}
+	entry.Seq = st.seq + 1
+
+	payload, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	record := make([]byte, walHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(record[4:8], crc32.Checksum(payload, walCRCTable))
+	copy(record[walHeaderSize:], payload)
+
+	if _, err := st.wal.Write(record); err != nil {
+		st.discardPartial()
+		return fmt.Errorf("写入日志失败: %v", err)
+	}
+	switch st.config.Sync {
+	case SyncAlways:
+		if err := st.wal.Sync(); err != nil {
+			// fsync失败后无法确定哪些数据已经落盘，丢弃本条记录并停止写入
+			st.discardPartial()
+			st.failed = fmt.Errorf("同步日志失败: %v", err)
+			return st.failed
+		}
+	case SyncInterval:
+		st.dirty = true
+	}
+	st.walSize += int64(len(record))
+
+	st.apply(entry)
+	st.walRecords++
+	if st.walRecords >= st.config.SnapshotEvery {
+		if err := st.snapshot(); err != nil {
+			// 快照失败不影响已写入日志的数据，下次写入时重试
+			log.Printf("生成快照失败: %v", err)
+		}
+	}
+	return nil
+}
+
+// discardPartial 写入失败后截断到上一条完整记录，避免之后的记录接在不完整的记录后面，
+// 重启时被当作损坏截断。截断失败时标记存储不可用。调用方需持有写锁
+func (st *FileUserStore) discardPartial() {
+	if err := st.wal.Truncate(st.walSize); err != nil {
+		st.failed = fmt.Errorf("截断不完整的日志失败: %v", err)
+		return
+	}
+	if _, err := st.wal.Seek(st.walSize, io.SeekStart); err != nil {
+		st.failed = fmt.Errorf("定位日志失败: %v", err)
+	}
+}
+
+// snapshot 把当前状态写入快照并清空日志，调用方需持有写锁
+func (st *FileUserStore) snapshot() error {
+	users := st.sortedUsers()
+	snap := walSnapshot{Seq: st.seq, NextID: st.nextID, Users: make([]*walUser, len(users))}
+	for i, user := range users {
+		snap.Users[i] = newWALUser(user)
+	}
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(st.config.Dir, snapshotFileName)
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	syncDir(st.config.Dir)
+
+	// 快照已落盘，之前的日志可以丢弃
+	if err := st.wal.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := st.wal.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	st.walSize = 0
+	st.walRecords = 0
+	st.dirty = false
+	return st.wal.Sync()
+}
+
+// syncDir 同步目录，保证重命名操作落盘
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
+
+// syncLoop SyncInterval 策略下定期落盘
+func (st *FileUserStore) syncLoop() {
+	defer close(st.done)
+
+	ticker := time.NewTicker(st.config.SyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-st.stop:
+			return
+		case <-ticker.C:
+			st.mu.Lock()
+			if st.dirty && st.wal != nil {
+				if err := st.wal.Sync(); err != nil {
+					log.Printf("同步日志失败: %v", err)
+				} else {
+					st.dirty = false
+				}
+			}
+			st.mu.Unlock()
+		}
+	}
+}
+
+func (st *FileUserStore) sortedUsers() []*User {
+	users := make([]*User, 0, len(st.users))
+	for _, user := range st.users {
+		copied := *user
+		users = append(users, &copied)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users
+}
+
+// Get 获取用户
+func (st *FileUserStore) Get(id int) (*User, error) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	user, ok := st.users[id]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	copied := *user
+	return &copied, nil
+}
+
+// Create 创建用户
+func (st *FileUserStore) Create(user *User) (*User, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	created := *user
+	created.ID = st.nextID
+	created.Version = 1
+	if err := st.commit(walEntry{Op: "put", User: newWALUser(&created)}); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// Update 更新用户
+func (st *FileUserStore) Update(user *User) (*User, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	current, ok := st.users[user.ID]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	if user.Version != 0 && user.Version != current.Version {
+		return nil, ErrVersionConflict
+	}
+	updated := *user
+	updated.Version = current.Version + 1
+	if updated.PasswordHash == "" {
+		updated.PasswordHash = current.PasswordHash
+	}
+	if updated.Role == "" {
+		updated.Role = current.Role
+	}
+	if err := st.commit(walEntry{Op: "put", User: newWALUser(&updated)}); err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+// Delete 删除用户
+func (st *FileUserStore) Delete(id int, version int) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	current, ok := st.users[id]
+	if !ok {
+		return ErrUserNotFound
+	}
+	if version != 0 && version != current.Version {
+		return ErrVersionConflict
+	}
+	return st.commit(walEntry{Op: "delete", ID: id})
+}
+
+// List 列出所有用户
+func (st *FileUserStore) List() ([]*User, error) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	return st.sortedUsers(), nil
+}
+
+// Ping 检查存储是否可用
+func (st *FileUserStore) Ping() error {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	if st.wal == nil {
+		return fmt.Errorf("存储已关闭")
+	}
+	if st.failed != nil {
+		return fmt.Errorf("存储不可用: %v", st.failed)
+	}
+	return nil
+}
+
+// Close 落盘并关闭日志
+func (st *FileUserStore) Close() error {
+	if st.stop != nil {
+		close(st.stop)
+		<-st.done
+		st.stop = nil
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.wal == nil {
+		return nil
+	}
+	err := errors.Join(st.wal.Sync(), st.wal.Close())
+	st.wal = nil
+	return err
+}
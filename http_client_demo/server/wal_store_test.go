@@ -0,0 +1,172 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func openTestStore(t *testing.T, dir string, snapshotEvery int) *FileUserStore {
+	t.Helper()
+	st, err := OpenFileUserStore(FileStoreConfig{Dir: dir, SnapshotEvery: snapshotEvery})
+	if err != nil {
+		t.Fatalf("打开存储失败: %v", err)
+	}
+	return st
+}
+
+// TestFileUserStoreRecovery 测试重启后从日志恢复所有变更
+func TestFileUserStoreRecovery(t *testing.T) {
+	dir := t.TempDir()
+	st := openTestStore(t, dir, 0)
+	a, _ := st.Create(&User{Name: "张三", Email: "zhangsan@example.com", PasswordHash: "pbkdf2-sha256$1$c2FsdA$aGFzaA"})
+	b, _ := st.Create(&User{Name: "李四", Email: "lisi@example.com"})
+	st.Update(&User{ID: a.ID, Name: "张三丰", Email: "zhangsan@example.com"})
+	st.Delete(b.ID, 0)
+	st.Close()
+
+	st = openTestStore(t, dir, 0)
+	defer st.Close()
+
+	users, _ := st.List()
+	if len(users) != 1 || users[0].Name != "张三丰" || users[0].Version != 2 ||
+		users[0].PasswordHash != "pbkdf2-sha256$1$c2FsdA$aGFzaA" {
+		t.Fatalf("恢复后的数据不正确: %+v", users)
+	}
+	if _, err := st.Update(&User{ID: a.ID, Name: "张三", Version: 1}); err != ErrVersionConflict {
+		t.Errorf("基于旧版本更新期望 ErrVersionConflict，实际 %v", err)
+	}
+	c, _ := st.Create(&User{Name: "王五"})
+	if c.ID != 3 {
+		t.Errorf("恢复后ID应继续递增，期望 3，实际 %d", c.ID)
+	}
+}
+
+// TestFileUserStoreTornWrite 测试日志在记录中间被截断时，恢复到最后一条完整记录
+func TestFileUserStoreTornWrite(t *testing.T) {
+	dir := t.TempDir()
+	st := openTestStore(t, dir, 0)
+	st.Create(&User{Name: "张三"})
+	st.Create(&User{Name: "李四"})
+	st.Create(&User{Name: "王五"})
+	st.Close()
+
+	walPath := filepath.Join(dir, walFileName)
+	info, err := os.Stat(walPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 截掉最后一条记录的后半部分，模拟写入过程中崩溃
+	if err := os.Truncate(walPath, info.Size()-5); err != nil {
+		t.Fatal(err)
+	}
+
+	st = openTestStore(t, dir, 0)
+	users, _ := st.List()
+	if len(users) != 2 || users[1].Name != "李四" {
+		t.Fatalf("期望恢复前两条记录，实际 %+v", users)
+	}
+
+	// 截断后的日志可以继续追加，再次重启数据完整
+	st.Create(&User{Name: "赵六"})
+	st.Close()
+
+	st = openTestStore(t, dir, 0)
+	defer st.Close()
+	users, _ = st.List()
+	if len(users) != 3 || users[2].Name != "赵六" || users[2].ID != 3 {
+		t.Errorf("截断后追加的数据不正确: %+v", users)
+	}
+}
+
+// TestFileUserStoreCorruptRecord 测试校验和不匹配的记录被丢弃
+func TestFileUserStoreCorruptRecord(t *testing.T) {
+	dir := t.TempDir()
+	st := openTestStore(t, dir, 0)
+	st.Create(&User{Name: "张三"})
+	st.Create(&User{Name: "李四"})
+	st.Close()
+
+	walPath := filepath.Join(dir, walFileName)
+	data, _ := os.ReadFile(walPath)
+	data[len(data)-2] ^= 0xff
+	os.WriteFile(walPath, data, 0o644)
+
+	st = openTestStore(t, dir, 0)
+	defer st.Close()
+	if users, _ := st.List(); len(users) != 1 {
+		t.Errorf("期望丢弃损坏的记录后剩 1 个用户，实际 %d", len(users))
+	}
+}
+
+// TestFileUserStoreSnapshot 测试快照生成后日志被压缩且数据完整
+func TestFileUserStoreSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	st := openTestStore(t, dir, 3)
+	for i := 0; i < 10; i++ {
+		st.Create(&User{Name: "用户"})
+	}
+	st.Delete(1, 0)
+	st.Close()
+
+	if _, err := os.Stat(filepath.Join(dir, snapshotFileName)); err != nil {
+		t.Fatalf("应生成快照文件: %v", err)
+	}
+
+	st = openTestStore(t, dir, 3)
+	defer st.Close()
+	if st.walRecords >= 3 {
+		t.Errorf("快照后日志应被压缩，实际剩余 %d 条", st.walRecords)
+	}
+	users, _ := st.List()
+	if len(users) != 9 || users[0].ID != 2 {
+		t.Errorf("快照恢复的数据不正确: %d 个用户", len(users))
+	}
+}
+
+// TestFileUserStoreWriteFailure 测试写入失败后截断不完整的记录，之后的写入在重启后仍然有效；
+// 无法截断时存储标记为不可用
+func TestFileUserStoreWriteFailure(t *testing.T) {
+	dir := t.TempDir()
+	st := openTestStore(t, dir, 0)
+	st.Create(&User{Name: "张三"})
+
+	// 模拟写了一半的记录
+	st.mu.Lock()
+	st.wal.Write([]byte{0x10, 0, 0, 0, 1, 2})
+	st.discardPartial()
+	st.mu.Unlock()
+	if _, err := st.Create(&User{Name: "李四"}); err != nil {
+		t.Fatal(err)
+	}
+	st.Close()
+
+	st = openTestStore(t, dir, 0)
+	if users, _ := st.List(); len(users) != 2 {
+		t.Fatalf("截断不完整的记录后期望恢复 2 个用户，实际 %+v", users)
+	}
+
+	// 只读的文件既不能写入也不能截断
+	st.mu.Lock()
+	wal := st.wal
+	readOnly, err := os.Open(filepath.Join(dir, walFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.wal = readOnly
+	st.mu.Unlock()
+	if _, err := st.Create(&User{Name: "王五"}); err == nil {
+		t.Fatal("写入失败时期望返回错误")
+	}
+	if _, err := st.Create(&User{Name: "王五"}); err == nil || st.Ping() == nil {
+		t.Errorf("无法截断后期望拒绝写入并报告不可用，实际 %v", err)
+	}
+	st.Close()
+	wal.Close()
+
+	st = openTestStore(t, dir, 0)
+	defer st.Close()
+	if users, _ := st.List(); len(users) != 2 {
+		t.Errorf("期望之前的数据不受影响，实际 %+v", users)
+	}
+}
@@ -0,0 +1,580 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"http_client_demo/api"
+)
+
+// webhook 请求头
+const (
+	// WebhookSignatureHeader HMAC-SHA256(密钥, 时间戳 + "." + 请求体) 的十六进制，带 "sha256=" 前缀
+	WebhookSignatureHeader = "X-Webhook-Signature"
+	// WebhookTimestampHeader 签名时的Unix时间戳（秒），接收方应拒绝过旧的请求以防重放
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	// WebhookDeliveryHeader 投递ID，重试时不变，接收方可据此去重
+	WebhookDeliveryHeader = "X-Webhook-Delivery"
+	// WebhookEventHeader 事件类型
+	WebhookEventHeader = "X-Webhook-Event"
+)
+
+// 签名密钥的最少字节数
+const minWebhookSecretLength = 16
+
+// 投递日志和死信列表各自最多保留的记录数，超出时丢弃最旧的
+const maxWebhookRecords = 1000
+
+// 投递状态
+const (
+	deliveryPending   = "pending"
+	deliveryRetrying  = "retrying"
+	deliverySucceeded = "delivered"
+	deliveryDead      = "dead"
+)
+
+var (
+	// ErrWebhookNotFound 按ID找不到webhook
+	ErrWebhookNotFound = errors.New("webhook不存在")
+	// ErrDeliveryNotFound 死信列表中找不到投递记录
+	ErrDeliveryNotFound = errors.New("死信列表中没有该投递")
+)
+
+// WebhookConfig webhook 投递配置
+type WebhookConfig struct {
+	// Workers 并发投递的协程数
+	Workers int
+	// QueueSize 等待投递的队列长度，队列满时新的投递直接进入死信列表
+	QueueSize int
+	// MaxAttempts 每次投递最多尝试的次数，全部失败后进入死信列表
+	MaxAttempts int
+	// RetryBase 第一次重试前的等待时间，之后每次翻倍
+	RetryBase time.Duration
+	// RetryMax 重试等待时间的上限
+	RetryMax time.Duration
+	// Timeout 单次投递请求的超时
+	Timeout time.Duration
+}
+
+// DefaultWebhookConfig 返回默认投递配置：最多尝试5次，分别等待1、2、4、8秒后重试
+func DefaultWebhookConfig() WebhookConfig {
+	return WebhookConfig{
+		Workers:     4,
+		QueueSize:   1000,
+		MaxAttempts: 5,
+		RetryBase:   time.Second,
+		RetryMax:    5 * time.Minute,
+		Timeout:     10 * time.Second,
+	}
+}
+
+// backoff 第attempt次失败后等待的时间
+func (c WebhookConfig) backoff(attempt int) time.Duration {
+	d := c.RetryBase
+	for i := 1; i < attempt && d < c.RetryMax; i++ {
+		d *= 2
+	}
+	return min(d, c.RetryMax)
+}
+
+// Webhook 一个webhook订阅。密钥只用于签名，不会在响应中返回
+type Webhook struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+	// Events 订阅的事件类型，为空表示全部事件
+	Events    []api.UserEventType `json:"events"`
+	CreatedAt time.Time           `json:"created_at"`
+
+	secret string
+}
+
+// wants 判断是否订阅了指定事件
+func (h *Webhook) wants(typ api.UserEventType) bool {
+	return len(h.Events) == 0 || slices.Contains(h.Events, typ)
+}
+
+// webhookDelivery 一个事件到一个webhook的投递，重试时复用同一条记录
+type webhookDelivery struct {
+	ID        string        `json:"id"`
+	WebhookID string        `json:"webhook_id"`
+	Event     api.UserEvent `json:"event"`
+	// Status pending、retrying、delivered 或 dead
+	Status   string `json:"status"`
+	Attempts int    `json:"attempts"`
+	// ResponseStatus 最后一次请求的响应状态码，连接失败时为0
+	ResponseStatus int        `json:"response_status,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+	NextAttemptAt  *time.Time `json:"next_attempt_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// webhookDispatcher 保存webhook订阅，通过后台队列投递事件。
+// 订阅、投递日志和死信只保存在内存中，服务器重启后清空
+type webhookDispatcher struct {
+	config WebhookConfig
+	client *http.Client
+	now    func() time.Time
+
+	mu         sync.Mutex
+	hooks      map[string]*Webhook
+	deliveries []*webhookDelivery
+	dead       []*webhookDelivery
+
+	queue   chan *webhookDelivery
+	start   sync.Once
+	stop    chan struct{}
+	stopped bool
+	wg      sync.WaitGroup
+}
+
+// newWebhookDispatcher 创建投递器，投递协程在第一次有事件时启动
+func newWebhookDispatcher(config WebhookConfig) *webhookDispatcher {
+	defaults := DefaultWebhookConfig()
+	if config.Workers <= 0 {
+		config.Workers = defaults.Workers
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = defaults.QueueSize
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = defaults.MaxAttempts
+	}
+	if config.RetryBase <= 0 {
+		config.RetryBase = defaults.RetryBase
+	}
+	if config.RetryMax < config.RetryBase {
+		config.RetryMax = max(defaults.RetryMax, config.RetryBase)
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaults.Timeout
+	}
+	return &webhookDispatcher{
+		config: config,
+		client: &http.Client{Timeout: config.Timeout},
+		now:    time.Now,
+		hooks:  make(map[string]*Webhook),
+		queue:  make(chan *webhookDelivery, config.QueueSize),
+		stop:   make(chan struct{}),
+	}
+}
+
+// add 创建订阅，校验URL、事件类型和密钥
+func (d *webhookDispatcher) add(rawURL string, events []api.UserEventType, secret string) (*Webhook, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("无效的webhook地址: %s", rawURL)
+	}
+	for _, typ := range events {
+		if !typ.Valid() {
+			return nil, fmt.Errorf("未知的事件类型: %s", typ)
+		}
+	}
+	if len(secret) < minWebhookSecretLength {
+		return nil, fmt.Errorf("签名密钥至少需要%d个字符", minWebhookSecretLength)
+	}
+	id, err := randomHex(8)
+	if err != nil {
+		return nil, err
+	}
+
+	hook := &Webhook{
+		ID:        "wh_" + id,
+		URL:       u.String(),
+		Events:    slices.Clone(events),
+		CreatedAt: d.now(),
+		secret:    secret,
+	}
+	if hook.Events == nil {
+		hook.Events = []api.UserEventType{}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.hooks[hook.ID] = hook
+	copied := *hook
+	return &copied, nil
+}
+
+// remove 删除订阅，尚未完成的投递在下次尝试时进入死信列表
+func (d *webhookDispatcher) remove(id string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.hooks[id]; !ok {
+		return ErrWebhookNotFound
+	}
+	delete(d.hooks, id)
+	return nil
+}
+
+// list 按创建时间返回所有订阅
+func (d *webhookDispatcher) list() []*Webhook {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	hooks := make([]*Webhook, 0, len(d.hooks))
+	for _, hook := range d.hooks {
+		copied := *hook
+		hooks = append(hooks, &copied)
+	}
+	sort.Slice(hooks, func(i, j int) bool {
+		return hooks[i].CreatedAt.Before(hooks[j].CreatedAt)
+	})
+	return hooks
+}
+
+// enqueue 为每个订阅了该事件的webhook创建投递并放入队列，作为事件总线的订阅者调用，不会阻塞
+func (d *webhookDispatcher) enqueue(event UserEvent) {
+	d.mu.Lock()
+	var pending []*webhookDelivery
+	for _, hook := range d.hooks {
+		if !hook.wants(event.Type) {
+			continue
+		}
+		id, err := randomHex(8)
+		if err != nil {
+			continue
+		}
+		now := d.now()
+		delivery := &webhookDelivery{
+			ID:        "dlv_" + id,
+			WebhookID: hook.ID,
+			Event:     event,
+			Status:    deliveryPending,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		d.deliveries = appendBounded(d.deliveries, delivery)
+		pending = append(pending, delivery)
+	}
+	d.mu.Unlock()
+
+	if len(pending) > 0 {
+		d.start.Do(d.startWorkers)
+	}
+	for _, delivery := range pending {
+		d.push(delivery)
+	}
+}
+
+// push 把投递放入队列，队列已满或投递器已关闭时进入死信列表
+func (d *webhookDispatcher) push(delivery *webhookDelivery) {
+	select {
+	case <-d.stop:
+		d.finish(delivery, 0, errors.New("服务器已关闭"))
+		return
+	default:
+	}
+	select {
+	case d.queue <- delivery:
+	default:
+		d.mu.Lock()
+		d.markDead(delivery, "投递队列已满")
+		d.mu.Unlock()
+	}
+}
+
+// startWorkers 启动投递协程
+func (d *webhookDispatcher) startWorkers() {
+	for i := 0; i < d.config.Workers; i++ {
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			for {
+				select {
+				case <-d.stop:
+					return
+				case delivery := <-d.queue:
+					d.attempt(delivery)
+				}
+			}
+		}()
+	}
+}
+
+// close 停止投递协程，等待进行中的请求结束。等待重试的投递不再发送
+func (d *webhookDispatcher) close() {
+	d.mu.Lock()
+	if d.stopped {
+		d.mu.Unlock()
+		return
+	}
+	d.stopped = true
+	close(d.stop)
+	d.mu.Unlock()
+	d.wg.Wait()
+}
+
+// attempt 发送一次投递并根据结果更新状态
+func (d *webhookDispatcher) attempt(delivery *webhookDelivery) {
+	d.mu.Lock()
+	hook, ok := d.hooks[delivery.WebhookID]
+	var target, secret string
+	if ok {
+		target, secret = hook.URL, hook.secret
+	}
+	event := delivery.Event
+	d.mu.Unlock()
+
+	if !ok {
+		d.mu.Lock()
+		delivery.Attempts++
+		d.markDead(delivery, ErrWebhookNotFound.Error())
+		d.mu.Unlock()
+		return
+	}
+
+	status, err := d.send(target, secret, delivery.ID, event)
+	d.finish(delivery, status, err)
+}
+
+// send 签名并发送事件，非2xx响应视为失败
+func (d *webhookDispatcher) send(target, secret, deliveryID string, event UserEvent) (int, error) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return 0, fmt.Errorf("序列化事件失败: %v", err)
+	}
+	timestamp := strconv.FormatInt(d.now().Unix(), 10)
+
+	ctx, cancel := context.WithTimeout(context.Background(), d.config.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("创建请求失败: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "SimpleServer-Webhook/1.0")
+	req.Header.Set(WebhookDeliveryHeader, deliveryID)
+	req.Header.Set(WebhookEventHeader, string(event.Type))
+	req.Header.Set(WebhookTimestampHeader, timestamp)
+	req.Header.Set(WebhookSignatureHeader, signWebhook(secret, timestamp, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("发送请求失败: %v", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("接收方返回状态码 %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// finish 记录一次尝试的结果：成功、按退避时间安排重试，或达到上限后进入死信列表
+func (d *webhookDispatcher) finish(delivery *webhookDelivery, status int, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delivery.Attempts++
+	delivery.ResponseStatus = status
+	delivery.UpdatedAt = d.now()
+	delivery.NextAttemptAt = nil
+	if err == nil {
+		delivery.Status = deliverySucceeded
+		delivery.LastError = ""
+		return
+	}
+	if delivery.Attempts >= d.config.MaxAttempts || d.stopped {
+		d.markDead(delivery, err.Error())
+		return
+	}
+
+	wait := d.config.backoff(delivery.Attempts)
+	next := delivery.UpdatedAt.Add(wait)
+	delivery.Status = deliveryRetrying
+	delivery.LastError = err.Error()
+	delivery.NextAttemptAt = &next
+	time.AfterFunc(wait, func() { d.push(delivery) })
+}
+
+// markDead 把投递放入死信列表，调用方需持有锁
+func (d *webhookDispatcher) markDead(delivery *webhookDelivery, reason string) {
+	delivery.Status = deliveryDead
+	delivery.LastError = reason
+	delivery.NextAttemptAt = nil
+	delivery.UpdatedAt = d.now()
+	d.dead = appendBounded(d.dead, delivery)
+}
+
+// redeliver 把死信列表中的投递重新放入队列，重新计算尝试次数
+func (d *webhookDispatcher) redeliver(id string) (*webhookDelivery, error) {
+	d.mu.Lock()
+	i := slices.IndexFunc(d.dead, func(delivery *webhookDelivery) bool { return delivery.ID == id })
+	if i < 0 {
+		d.mu.Unlock()
+		return nil, ErrDeliveryNotFound
+	}
+	delivery := d.dead[i]
+	d.dead = slices.Delete(d.dead, i, i+1)
+	delivery.Status = deliveryPending
+	delivery.Attempts = 0
+	delivery.UpdatedAt = d.now()
+	// 已经被投递日志淘汰的记录重新加入日志
+	if !slices.Contains(d.deliveries, delivery) {
+		d.deliveries = appendBounded(d.deliveries, delivery)
+	}
+	copied := *delivery
+	d.mu.Unlock()
+
+	d.start.Do(d.startWorkers)
+	d.push(delivery)
+	return &copied, nil
+}
+
+// log 按时间倒序返回投递记录，webhookID 和 status 不为空时按其过滤
+func (d *webhookDispatcher) log(webhookID, status string) []webhookDelivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return filterDeliveries(d.deliveries, webhookID, status)
+}
+
+// deadLetters 按时间倒序返回死信列表
+func (d *webhookDispatcher) deadLetters() []webhookDelivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return filterDeliveries(d.dead, "", "")
+}
+
+// filterDeliveries 倒序复制符合条件的投递记录，调用方需持有锁
+func filterDeliveries(deliveries []*webhookDelivery, webhookID, status string) []webhookDelivery {
+	result := make([]webhookDelivery, 0, len(deliveries))
+	for i := len(deliveries) - 1; i >= 0; i-- {
+		delivery := deliveries[i]
+		if (webhookID == "" || delivery.WebhookID == webhookID) && (status == "" || delivery.Status == status) {
+			result = append(result, *delivery)
+		}
+	}
+	return result
+}
+
+// appendBounded 追加记录，超过上限时丢弃最旧的
+func appendBounded(list []*webhookDelivery, delivery *webhookDelivery) []*webhookDelivery {
+	list = append(list, delivery)
+	if len(list) > maxWebhookRecords {
+		list = slices.Delete(list, 0, len(list)-maxWebhookRecords)
+	}
+	return list
+}
+
+// signWebhook 计算请求签名，时间戳参与签名，防止把旧请求体配上新时间戳重放
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// createWebhookRequest 创建webhook的请求体
+type createWebhookRequest struct {
+	URL string `json:"url"`
+	// Events 订阅的事件类型，为空表示全部事件
+	Events []api.UserEventType `json:"events,omitempty"`
+	// Secret 签名密钥，至少16个字符，只在创建时提交
+	Secret string `json:"secret"`
+}
+
+// 创建webhook
+func (s *SimpleServer) createWebhook(w http.ResponseWriter, r *http.Request) {
+	var req createWebhookRequest
+	if err := s.decodeJSON(w, r, &req); err != nil {
+		s.sendDecodeError(w, r, err)
+		return
+	}
+
+	hook, err := s.webhooks.add(req.URL, req.Events, req.Secret)
+	if err != nil {
+		s.sendResponse(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+	s.audit(r, AuditWebhookCreate, "webhooks/"+hook.ID, nil, hook)
+
+	s.sendResponse(w, http.StatusCreated, APIResponse{
+		Success: true,
+		Message: "创建webhook成功",
+		Data:    hook,
+	})
+}
+
+// 列出webhook
+func (s *SimpleServer) listWebhooks(w http.ResponseWriter, r *http.Request) {
+	s.sendResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "获取webhook列表成功",
+		Data:    s.webhooks.list(),
+	})
+}
+
+// 删除webhook
+func (s *SimpleServer) deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	if err := s.webhooks.remove(r.PathValue("id")); err != nil {
+		s.sendResponse(w, http.StatusNotFound, APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+	s.audit(r, AuditWebhookDelete, "webhooks/"+r.PathValue("id"), nil, nil)
+
+	s.sendResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "删除webhook成功",
+	})
+}
+
+// 查询投递日志
+func (s *SimpleServer) listWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	s.sendResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "获取投递日志成功",
+		Data:    s.webhooks.log(query.Get("webhook_id"), query.Get("status")),
+	})
+}
+
+// 查询死信列表
+func (s *SimpleServer) listWebhookDeadLetters(w http.ResponseWriter, r *http.Request) {
+	s.sendResponse(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "获取死信列表成功",
+		Data:    s.webhooks.deadLetters(),
+	})
+}
+
+// 重新投递死信
+func (s *SimpleServer) redeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	delivery, err := s.webhooks.redeliver(r.PathValue("id"))
+	if err != nil {
+		s.sendResponse(w, http.StatusNotFound, APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+	s.audit(r, AuditWebhookRedeliver, "webhook-deliveries/"+r.PathValue("id"), nil, nil)
+
+	s.sendResponse(w, http.StatusAccepted, APIResponse{
+		Success: true,
+		Message: "已重新加入投递队列",
+		Data:    delivery,
+	})
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"http_client_demo/api"
+)
+
+// webhookReceiver 记录收到的事件，前 failures 次请求返回500
+type webhookReceiver struct {
+	t        *testing.T
+	secret   string
+	mu       sync.Mutex
+	failures int
+	requests int
+	events   []UserEvent
+	ids      map[string]int
+}
+
+func (rv *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	timestamp := r.Header.Get(WebhookTimestampHeader)
+	if got := r.Header.Get(WebhookSignatureHeader); got != signWebhook(rv.secret, timestamp, body) {
+		rv.t.Errorf("签名不正确: %s", got)
+	}
+
+	rv.mu.Lock()
+	defer rv.mu.Unlock()
+	rv.requests++
+	rv.ids[r.Header.Get(WebhookDeliveryHeader)]++
+	if rv.requests <= rv.failures {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	var event UserEvent
+	json.Unmarshal(body, &event)
+	rv.events = append(rv.events, event)
+}
+
+// received 返回收到的事件类型
+func (rv *webhookReceiver) received() []api.UserEventType {
+	rv.mu.Lock()
+	defer rv.mu.Unlock()
+	types := make([]api.UserEventType, 0, len(rv.events))
+	for _, event := range rv.events {
+		types = append(types, event.Type)
+	}
+	return types
+}
+
+// waitFor 等待条件成立，最多2秒
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("等待超时: %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// newWebhookTestServer 创建带管理员Key、重试间隔很短的服务器
+func newWebhookTestServer(t *testing.T) *SimpleServer {
+	config := DefaultServerConfig("0")
+	config.RateLimit = RateLimitConfig{}
+	config.APIKeys = append(config.APIKeys, BootstrapAPIKey{Name: "admin", Key: "admin-key", Scopes: []Scope{ScopeAdmin}})
+	config.Webhooks = WebhookConfig{MaxAttempts: 3, RetryBase: time.Millisecond, RetryMax: 10 * time.Millisecond}
+	s := NewSimpleServerWithConfig(config, NewMemoryUserStore())
+	t.Cleanup(s.webhooks.close)
+	return s
+}
+
+// TestWebhookDelivery 测试按事件过滤投递、签名、失败重试，以及进入死信后重新投递
+func TestWebhookDelivery(t *testing.T) {
+	s := newWebhookTestServer(t)
+	do := func(method, path, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+key)
+		req.Header.Set("If-Match", "*")
+		rec := httptest.NewRecorder()
+		s.mux.ServeHTTP(rec, req)
+		return rec
+	}
+	subscribe := func(url, events string) string {
+		rec := do(http.MethodPost, "/admin/webhooks", "admin-key",
+			`{"url":"`+url+`","events":`+events+`,"secret":"0123456789abcdef"}`)
+		var resp struct {
+			Data Webhook `json:"data"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		if rec.Code != http.StatusCreated || strings.Contains(rec.Body.String(), "0123456789abcdef") {
+			t.Fatalf("创建webhook期望 201 且不返回密钥，实际 %d %s", rec.Code, rec.Body.String())
+		}
+		return resp.Data.ID
+	}
+
+	for _, body := range []string{
+		`{"url":"ftp://example.com","secret":"0123456789abcdef"}`,
+		`{"url":"http://example.com","events":["user.renamed"],"secret":"0123456789abcdef"}`,
+		`{"url":"http://example.com","secret":"short"}`,
+	} {
+		if rec := do(http.MethodPost, "/admin/webhooks", "admin-key", body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s 期望 400，实际 %d", body, rec.Code)
+		}
+	}
+
+	// 只订阅创建和删除，前两次投递失败后重试成功
+	flaky := &webhookReceiver{t: t, secret: "0123456789abcdef", failures: 2, ids: make(map[string]int)}
+	flakySrv := httptest.NewServer(flaky)
+	defer flakySrv.Close()
+	flakyID := subscribe(flakySrv.URL, `["user.created","user.deleted"]`)
+
+	// 订阅全部事件，接收方一直失败
+	down := &webhookReceiver{t: t, secret: "0123456789abcdef", failures: 1 << 30, ids: make(map[string]int)}
+	downSrv := httptest.NewServer(down)
+	defer downSrv.Close()
+	downID := subscribe(downSrv.URL, `[]`)
+
+	do(http.MethodPost, "/v2/users", "your-api-key-here", `{"name":"赵六","email":"zhaoliu@example.com"}`)
+	waitFor(t, "创建事件重试成功", func() bool { return len(flaky.received()) == 1 })
+	do(http.MethodPut, "/v2/users/1", "your-api-key-here", `{"name":"赵六六","email":"zhaoliu@example.com"}`)
+	do(http.MethodDelete, "/v2/users/1", "your-api-key-here", "")
+	waitFor(t, "删除事件送达", func() bool { return len(flaky.received()) == 2 })
+
+	if got := flaky.received(); got[0] != api.UserCreated || got[1] != api.UserDeleted {
+		t.Errorf("期望只收到创建和删除事件，实际 %v", got)
+	}
+	flaky.mu.Lock()
+	if flaky.events[0].User.Name != "赵六" || flaky.events[1].User.Name != "赵六六" || flaky.events[0].ID >= flaky.events[1].ID {
+		t.Errorf("事件内容或顺序不正确: %+v", flaky.events)
+	}
+	for id, n := range flaky.ids {
+		if n != 3 && n != 1 {
+			t.Errorf("投递 %s 期望重试时使用同一个ID，实际收到 %d 次", id, n)
+		}
+	}
+	flaky.mu.Unlock()
+
+	var deliveries []webhookDelivery
+	waitFor(t, "三个事件进入死信列表", func() bool {
+		deliveries = s.webhooks.deadLetters()
+		return len(deliveries) == 3
+	})
+	for _, delivery := range deliveries {
+		if delivery.WebhookID != downID || delivery.Attempts != 3 || delivery.ResponseStatus != http.StatusInternalServerError {
+			t.Errorf("死信记录不正确: %+v", delivery)
+		}
+	}
+	rec := do(http.MethodGet, "/admin/webhook-deliveries?webhook_id="+flakyID, "admin-key", "")
+	if rec.Code != http.StatusOK || strings.Count(rec.Body.String(), `"status":"delivered"`) != 2 {
+		t.Errorf("投递日志期望有2条成功记录，实际 %d %s", rec.Code, rec.Body.String())
+	}
+
+	// 接收方恢复后重新投递死信
+	down.mu.Lock()
+	down.failures = 0
+	down.mu.Unlock()
+	if rec := do(http.MethodPost, "/admin/webhook-dead-letters/"+deliveries[0].ID+"/retry", "admin-key", ""); rec.Code != http.StatusAccepted {
+		t.Fatalf("重新投递期望 202，实际 %d", rec.Code)
+	}
+	waitFor(t, "重新投递送达", func() bool { return len(down.received()) == 1 })
+	if got := len(s.webhooks.deadLetters()); got != 2 {
+		t.Errorf("重新投递后死信期望剩 2 条，实际 %d", got)
+	}
+	if rec := do(http.MethodPost, "/admin/webhook-dead-letters/dlv_missing/retry", "admin-key", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("不存在的死信期望 404，实际 %d", rec.Code)
+	}
+
+	if rec := do(http.MethodDelete, "/admin/webhooks/"+flakyID, "admin-key", ""); rec.Code != http.StatusOK {
+		t.Errorf("删除webhook期望 200，实际 %d", rec.Code)
+	}
+	if hooks := s.webhooks.list(); len(hooks) != 1 || hooks[0].ID != downID {
+		t.Errorf("删除后期望只剩一个webhook，实际 %+v", hooks)
+	}
+}
+
+// TestWebhookBackoff 测试重试间隔按指数增长并有上限
+func TestWebhookBackoff(t *testing.T) {
+	c := WebhookConfig{RetryBase: time.Second, RetryMax: 5 * time.Second}
+	for attempt, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second, 10: 5 * time.Second} {
+		if got := c.backoff(attempt); got != want {
+			t.Errorf("第 %d 次失败后期望等待 %v，实际 %v", attempt, want, got)
+		}
+	}
+}